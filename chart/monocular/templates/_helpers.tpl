@@ -49,6 +49,9 @@ spec:
     args:
     - sync
     - --user-agent-comment=monocular/{{ $global.Chart.AppVersion }}
+    {{- if $global.Values.blobStore.url }}
+    - --blob-store={{ $global.Values.blobStore.url }}
+    {{- end }}
     {{- if and $global.Values.global.mongoUrl (not $global.Values.mongodb.enabled) }}
     - --mongo-url={{ $global.Values.global.mongoUrl }}
     {{- else }}
@@ -76,6 +79,11 @@ spec:
           key: mongodb-root-password
           name: {{ template "mongodb.fullname" $global }}
     {{- end }}
+    {{- if $global.Values.blobStore.existingSecret }}
+    envFrom:
+    - secretRef:
+        name: {{ $global.Values.blobStore.existingSecret }}
+    {{- end }}
     resources:
 {{ toYaml $global.Values.sync.resources | indent 6 }}
 {{- with $global.Values.sync.nodeSelector }}
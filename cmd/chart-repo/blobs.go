@@ -0,0 +1,177 @@
+/*
+Copyright (c) 2018 The Helm Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"time"
+
+	"github.com/globalsign/mgo/bson"
+	"github.com/helm/monocular/pkg/blobstore"
+	"github.com/kubeapps/common/datastore"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+// blobs stores the icons, READMEs, values, schemas and tarballs of the charts
+// if a blob store is configured, the documents then only hold their keys.
+// Otherwise icons and files are kept in the documents, and tarballs are not
+// stored.
+var blobs blobstore.Store
+
+// openBlobStore opens the blob store given with the --blob-store flag, if any
+func openBlobStore(cmd *cobra.Command) error {
+	u, err := cmd.Flags().GetString("blob-store")
+	if err != nil || u == "" {
+		return err
+	}
+	blobs, err = blobstore.New(u)
+	return err
+}
+
+// iconUpdate returns the update setting the icon of a chart and its variants,
+// which are moved to the blob store if one is configured
+func iconUpdate(icon []byte, contentType string, variants []iconVariant) (bson.M, error) {
+	if blobs == nil {
+		return bson.M{"$set": bson.M{"raw_icon": icon, "icon_content_type": contentType, "icon_variants": variants}}, nil
+	}
+	key, err := blobstore.Put(blobs, icon)
+	if err != nil {
+		return nil, err
+	}
+	var stored []iconVariant
+	for _, v := range variants {
+		if v.Key, err = blobstore.Put(blobs, v.Data); err != nil {
+			return nil, err
+		}
+		v.Data = nil
+		stored = append(stored, v)
+	}
+	return bson.M{
+		"$set":   bson.M{"icon_key": key, "icon_content_type": contentType, "icon_variants": stored},
+		"$unset": bson.M{"raw_icon": ""},
+	}, nil
+}
+
+// storeFileBlobs moves the files of a chart version and its tarball to the blob
+// store if one is configured
+func storeFileBlobs(files *chartFiles, tarball []byte) error {
+	if blobs == nil {
+		return nil
+	}
+	var err error
+	for _, f := range []struct {
+		content *string
+		key     *string
+	}{
+		{&files.Readme, &files.ReadmeKey},
+		{&files.Values, &files.ValuesKey},
+		{&files.Schema, &files.SchemaKey},
+	} {
+		if *f.content == "" {
+			continue
+		}
+		if *f.key, err = blobstore.Put(blobs, []byte(*f.content)); err != nil {
+			return err
+		}
+		*f.content = ""
+	}
+	for i, f := range files.Files {
+		if f.Content == "" {
+			continue
+		}
+		if files.Files[i].Key, err = blobstore.Put(blobs, []byte(f.Content)); err != nil {
+			return err
+		}
+		files.Files[i].Content = ""
+	}
+	files.TarballKey, err = blobstore.Put(blobs, tarball)
+	return err
+}
+
+// blobRefs holds the keys of the blobs referenced by a chart, by the files of a
+// chart version or by their shared content
+type blobRefs struct {
+	IconKey      string        `bson:"icon_key"`
+	IconVariants []iconVariant `bson:"icon_variants"`
+	ReadmeKey    string
+	ValuesKey    string
+	SchemaKey    string
+	TarballKey   string
+	Files        []chartFile
+}
+
+var fileBlobFields = bson.M{"readmekey": 1, "valueskey": 1, "schemakey": 1, "tarballkey": 1, "files.key": 1}
+
+// referencedBlobs returns the keys of the blobs referenced by the documents
+func referencedBlobs(dbSession datastore.Session) (map[string]bool, error) {
+	db, closer := dbSession.DB()
+	defer closer()
+	keys := map[string]bool{}
+	for _, c := range []struct {
+		collection string
+		fields     bson.M
+	}{
+		{chartCollection, bson.M{"icon_key": 1, "icon_variants.key": 1}},
+		{chartFilesCollection, fileBlobFields},
+		{chartContentsCollection, fileBlobFields},
+	} {
+		var docs []blobRefs
+		if err := db.C(c.collection).Find(bson.M{}).Select(c.fields).All(&docs); err != nil {
+			return nil, err
+		}
+		for _, d := range docs {
+			for _, key := range []string{d.IconKey, d.ReadmeKey, d.ValuesKey, d.SchemaKey, d.TarballKey} {
+				keys[key] = true
+			}
+			for _, v := range d.IconVariants {
+				keys[v.Key] = true
+			}
+			for _, f := range d.Files {
+				keys[f.Key] = true
+			}
+		}
+	}
+	return keys, nil
+}
+
+// collectBlobGarbage deletes the blobs no longer referenced by any document,
+// such as replaced icons and the files of deleted repositories, and returns
+// how many were deleted. A sync stores the blobs before the documents
+// referencing them, so blobs modified less than minAge ago are kept.
+func collectBlobGarbage(dbSession datastore.Session, minAge time.Duration, now time.Time) (int, error) {
+	referenced, err := referencedBlobs(dbSession)
+	if err != nil {
+		return 0, err
+	}
+	var orphans []string
+	err = blobs.Walk(func(key string, modified time.Time) error {
+		if !referenced[key] && now.Sub(modified) >= minAge {
+			orphans = append(orphans, key)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	for i, key := range orphans {
+		if err := blobs.Delete(key); err != nil {
+			return i, err
+		}
+		logrus.WithField("key", key).Debug("deleted unreferenced blob")
+	}
+	return len(orphans), nil
+}
@@ -0,0 +1,129 @@
+/*
+Copyright (c) 2018 The Helm Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/arschles/assert"
+	"github.com/globalsign/mgo/bson"
+	"github.com/helm/monocular/pkg/blobstore"
+	"github.com/kubeapps/common/datastore/mockstore"
+	"github.com/stretchr/testify/mock"
+)
+
+// withBlobStore configures a blob store in a temporary directory until the
+// returned function is called
+func withBlobStore(t *testing.T) func() {
+	dir, err := ioutil.TempDir("", "blobs")
+	assert.NoErr(t, err)
+	blobs = blobstore.NewFileStore(dir)
+	return func() {
+		blobs = nil
+		os.RemoveAll(dir)
+	}
+}
+
+func blobContent(t *testing.T, key string) string {
+	r, _, err := blobs.Get(key)
+	assert.NoErr(t, err)
+	defer r.Close()
+	b, err := ioutil.ReadAll(r)
+	assert.NoErr(t, err)
+	return string(b)
+}
+
+func Test_iconUpdate(t *testing.T) {
+	variants := []iconVariant{{Size: 64, Format: "webp", ContentType: "image/webp", Data: []byte("webp")}}
+
+	update, err := iconUpdate([]byte("png"), "image/png", variants)
+	assert.NoErr(t, err)
+	assert.Equal(t, update, bson.M{"$set": bson.M{"raw_icon": []byte("png"), "icon_content_type": "image/png", "icon_variants": variants}}, "update without a blob store")
+
+	defer withBlobStore(t)()
+	update, err = iconUpdate([]byte("png"), "image/png", variants)
+	assert.NoErr(t, err)
+	stored := []iconVariant{{Size: 64, Format: "webp", ContentType: "image/webp", Key: blobstore.Key([]byte("webp"))}}
+	assert.Equal(t, update, bson.M{
+		"$set":   bson.M{"icon_key": blobstore.Key([]byte("png")), "icon_content_type": "image/png", "icon_variants": stored},
+		"$unset": bson.M{"raw_icon": ""},
+	}, "update with a blob store")
+	assert.Equal(t, blobContent(t, stored[0].Key), "webp", "variant")
+	assert.Equal(t, string(variants[0].Data), "webp", "the variants are not modified")
+}
+
+func Test_storeFileBlobs(t *testing.T) {
+	files := chartFiles{ID: "stable/wordpress-0.7.5", Readme: "# WordPress", Values: "replicas: 1"}
+	assert.NoErr(t, storeFileBlobs(&files, []byte("tarball")))
+	assert.Equal(t, files.Readme, "# WordPress", "README without a blob store")
+
+	defer withBlobStore(t)()
+	assert.NoErr(t, storeFileBlobs(&files, []byte("tarball")))
+	assert.Equal(t, files.Readme, "", "README")
+	assert.Equal(t, files.Values, "", "values")
+	assert.Equal(t, files.SchemaKey, "", "missing schema")
+	assert.Equal(t, blobContent(t, files.ReadmeKey), "# WordPress", "README")
+	assert.Equal(t, blobContent(t, files.ValuesKey), "replicas: 1", "values")
+	assert.Equal(t, blobContent(t, files.TarballKey), "tarball", "tarball")
+}
+
+func Test_collectBlobGarbage(t *testing.T) {
+	defer withBlobStore(t)()
+	var keys []string
+	for _, content := range []string{"icon", "variant", "README", "file", "tarball", "replaced icon", "deleted README"} {
+		key, err := blobstore.Put(blobs, []byte(content))
+		assert.NoErr(t, err)
+		keys = append(keys, key)
+	}
+
+	m := &mock.Mock{}
+	dbSession := mockstore.NewMockSession(m)
+	// The charts, files and contents are queried in order by each collection
+	expectRefs := func() {
+		var refs []blobRefs
+		for _, docs := range [][]blobRefs{
+			{{IconKey: keys[0], IconVariants: []iconVariant{{Key: keys[1]}}}},
+			{{ReadmeKey: keys[2], Files: []chartFile{{Path: "templates/NOTES.txt", Key: keys[3]}}}},
+			{{TarballKey: keys[4]}},
+		} {
+			docs := docs
+			m.On("All", &refs).Run(func(args mock.Arguments) {
+				*args.Get(0).(*[]blobRefs) = docs
+			}).Once()
+		}
+	}
+
+	expectRefs()
+	deleted, err := collectBlobGarbage(dbSession, 24*time.Hour, time.Now())
+	assert.NoErr(t, err)
+	assert.Equal(t, deleted, 0, "recent blobs deleted")
+
+	expectRefs()
+
+	deleted, err = collectBlobGarbage(dbSession, 24*time.Hour, time.Now().Add(25*time.Hour))
+	assert.NoErr(t, err)
+	assert.Equal(t, deleted, 2, "unreferenced blobs deleted")
+	for i, key := range keys {
+		exists, err := blobs.Exists(key)
+		assert.NoErr(t, err)
+		assert.Equal(t, exists, i < 5, "blob "+strconv.Itoa(i)+" exists")
+	}
+}
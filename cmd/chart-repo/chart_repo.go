@@ -18,7 +18,11 @@ package main
 
 import (
 	"os"
+	"strings"
+	"time"
 
+	"github.com/kubeapps/common/datastore"
+	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 )
 
@@ -38,7 +42,7 @@ func main() {
 }
 
 func init() {
-	cmds := []*cobra.Command{syncCmd, deleteCmd}
+	cmds := []*cobra.Command{syncCmd, deleteCmd, serveCmd, gcBlobsCmd}
 	filterAnnotations := []string{}
 	filterNames := []string{}
 
@@ -49,10 +53,93 @@ func init() {
 		cmd.Flags().String("mongo-user", "", "MongoDB user")
 		cmd.Flags().StringSliceVar(&filterAnnotations, "filter-annotation", []string{}, "Filter by charts that match any of these annotations")
 		cmd.Flags().StringSliceVar(&filterNames, "filter-name", []string{}, "Filter by charts that match these names")
+		// see repo_config.go
+		cmd.Flags().String("repos-file", "", "File with the credentials of each repository")
+		cmd.Flags().StringSliceVar(&authHosts, "auth-host", authHosts, "Host, or glob pattern, other than the host of the repository the credentials are sent to")
+
+		// see blobs.go
+		cmd.Flags().String("blob-store", "", "URL of the store of icons and files (file:///path or s3://bucket/prefix), stored in MongoDB if not set")
 
 		// see version.go
 		cmd.Flags().StringVarP(&userAgentComment, "user-agent-comment", "", "", "UserAgent comment used during outbound requests")
+		// see index.go
+		cmd.Flags().Int64Var(&maxIndexSize, "max-index-size", maxIndexSize, "Maximum size in bytes of a repository index")
+		// see retry.go
+		cmd.Flags().DurationVar(&requestTimeout, "request-timeout", requestTimeout, "Timeout of each attempt of an outbound request")
+		cmd.Flags().IntVar(&maxRetries, "max-retries", maxRetries, "Number of times a failed outbound request is retried")
+		cmd.Flags().DurationVar(&retryDelay, "retry-delay", retryDelay, "Delay before the first retry of a failed request, doubled with each attempt")
+		cmd.Flags().DurationVar(&maxRetryDelay, "max-retry-delay", maxRetryDelay, "Maximum delay between attempts of a failed request")
+		// see limits.go
+		cmd.Flags().IntVar(&iconWorkers, "icon-workers", iconWorkers, "Number of chart icons imported concurrently")
+		cmd.Flags().IntVar(&filesWorkers, "files-workers", filesWorkers, "Number of chart versions whose files are imported concurrently")
+		cmd.Flags().IntVar(&maxHostConcurrency, "max-host-concurrency", maxHostConcurrency, "Maximum number of outbound requests in flight to a single host, 0 for no limit")
+		cmd.Flags().Float64Var(&maxRequestsPerSecond, "max-requests-per-second", maxRequestsPerSecond, "Maximum rate of outbound requests, 0 for no limit")
+		// see icons.go
+		cmd.Flags().IntSliceVar(&iconSizes, "icon-size", iconSizes, "Size raster icons are resized to, the first one is served by default")
+		cmd.Flags().StringSliceVar(&iconFormats, "icon-format", iconFormats, "Format resized icons are stored in (png, webp)")
+		// see listing.go
+		cmd.Flags().Int64Var(&maxStoredFileSize, "max-stored-file-size", maxStoredFileSize, "Size in bytes of the largest text file of a chart stored with its listing, 0 to only list the files")
+		cmd.Flags().Int64Var(&maxStoredFilesSize, "max-stored-files-size", maxStoredFilesSize, "Total size in bytes of the text files of a chart version stored in its document without a blob store")
+		cmd.Flags().BoolVar(&storeFileContents, "store-file-contents", storeFileContents, "Store the text files of the charts in the database when no blob store is configured")
+		// see render.go
+		cmd.Flags().DurationVar(&renderTimeout, "render-timeout", renderTimeout, "Timeout of the rendering of the templates of a chart version to find its images")
+		cmd.Flags().Int64Var(&renderMemoryLimit, "render-memory-limit", renderMemoryLimit, "Memory in bytes of the process rendering the templates of a chart version, 0 for no limit")
 		cmd.Flags().Bool("debug", false, "verbose logging")
 	}
 	rootCmd.AddCommand(versionCmd)
+	// see blobs.go
+	gcBlobsCmd.Flags().Duration("min-age", 24*time.Hour, "Minimum age of the unreferenced blobs deleted")
+	// see render.go
+	renderImagesCmd.Flags().Int64Var(&renderMemoryLimit, "memory-limit", renderMemoryLimit, "Memory in bytes of the process, 0 for no limit")
+	rootCmd.AddCommand(renderImagesCmd)
+}
+
+// dbSessionFromFlags enables debug logging if --debug is set and connects to
+// the database given with the --mongo-* flags and the MONGO_PASSWORD
+// environment variable
+func dbSessionFromFlags(cmd *cobra.Command) (datastore.Session, error) {
+	mongoURL, err := cmd.Flags().GetString("mongo-url")
+	if err != nil {
+		return nil, err
+	}
+	mongoDB, err := cmd.Flags().GetString("mongo-database")
+	if err != nil {
+		return nil, err
+	}
+	mongoUser, err := cmd.Flags().GetString("mongo-user")
+	if err != nil {
+		return nil, err
+	}
+	debug, err := cmd.Flags().GetBool("debug")
+	if err != nil {
+		return nil, err
+	}
+	if debug {
+		logrus.SetLevel(logrus.DebugLevel)
+	}
+	mongoConfig := datastore.Config{URL: mongoURL, Database: mongoDB, Username: mongoUser, Password: os.Getenv("MONGO_PASSWORD")}
+	return datastore.NewSession(mongoConfig)
+}
+
+// filtersFromFlags returns the filters given with the --filter-annotation and
+// --filter-name flags
+func filtersFromFlags(cmd *cobra.Command) (*filters, error) {
+	filter := new(filters)
+	filter.Annotations = make(map[string]string)
+	filterAnnotations, err := cmd.Flags().GetStringSlice("filter-annotation")
+	if err != nil {
+		return nil, err
+	}
+	for _, a := range filterAnnotations {
+		kv := strings.Split(a, "=")
+		if len(kv) == 2 {
+			filter.Annotations[kv[0]] = kv[1]
+		} else {
+			filter.Annotations[a] = ""
+		}
+	}
+	if filter.Names, err = cmd.Flags().GetStringSlice("filter-name"); err != nil {
+		return nil, err
+	}
+	return filter, nil
 }
@@ -0,0 +1,95 @@
+/*
+Copyright (c) 2018 The Helm Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+
+	"github.com/globalsign/mgo/bson"
+	"github.com/kubeapps/common/datastore"
+)
+
+// sharedContent returns the files of the tarball with the digest if they have
+// already been imported, from any repository
+func sharedContent(db datastore.Database, digest string) (chartContent, bool) {
+	var content chartContent
+	if digest == "" {
+		return content, false
+	}
+	return content, db.C(chartContentsCollection).Find(bson.M{"_id": digest, "files_version": chartFilesVersion}).One(&content) == nil
+}
+
+// digestMatches returns true if the digest from the repository index is the
+// SHA-256 digest of the tarball
+func digestMatches(digest string, tarball []byte) bool {
+	sum := sha256.Sum256(tarball)
+	return digest != "" && strings.EqualFold(digest, hex.EncodeToString(sum[:]))
+}
+
+// shareContent moves the content of the files to the document shared by the
+// chart versions with the same digest, the files then only reference it
+func shareContent(files *chartFiles) chartContent {
+	content := chartContent{
+		ID:           files.Digest,
+		Readme:       files.Readme,
+		Values:       files.Values,
+		Schema:       files.Schema,
+		Changelog:    files.Changelog,
+		ReadmeKey:    files.ReadmeKey,
+		ValuesKey:    files.ValuesKey,
+		SchemaKey:    files.SchemaKey,
+		TarballKey:   files.TarballKey,
+		Files:        files.Files,
+		CRDs:         files.CRDs,
+		Images:       files.Images,
+		ImagesError:  files.ImagesError,
+		FilesVersion: files.FilesVersion,
+	}
+	// the CRDs and images are kept with the files of each repository so that
+	// they can be queried along with it
+	*files = chartFiles{ID: files.ID, Repo: files.Repo, Digest: files.Digest, Signed: files.Signed, Changes: files.Changes, Shared: true, CRDs: files.CRDs, Images: files.Images, ImagesError: files.ImagesError, Chart: files.Chart, Version: files.Version, FilesVersion: files.FilesVersion}
+	return content
+}
+
+// removeUnreferencedContent removes the shared content of the digests that no
+// files reference anymore, e.g. once the repository importing them is deleted
+func removeUnreferencedContent(db datastore.Database, digests []string) error {
+	if len(digests) == 0 {
+		return nil
+	}
+	var files []chartFiles
+	if err := db.C(chartFilesCollection).Find(bson.M{"digest": bson.M{"$in": digests}, "shared": true}).Select(bson.M{"digest": 1}).All(&files); err != nil {
+		return err
+	}
+	referenced := map[string]bool{}
+	for _, f := range files {
+		referenced[f.Digest] = true
+	}
+	var unreferenced []string
+	for _, d := range digests {
+		if !referenced[d] {
+			unreferenced = append(unreferenced, d)
+		}
+	}
+	if len(unreferenced) == 0 {
+		return nil
+	}
+	_, err := db.C(chartContentsCollection).RemoveAll(bson.M{"_id": bson.M{"$in": unreferenced}})
+	return err
+}
@@ -0,0 +1,89 @@
+/*
+Copyright (c) 2018 The Helm Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/arschles/assert"
+	"github.com/kubeapps/common/datastore/mockstore"
+	"github.com/stretchr/testify/mock"
+)
+
+// provOnlyClient fails the test if anything but a provenance file is requested
+type provOnlyClient struct {
+	t *testing.T
+}
+
+func (h *provOnlyClient) Do(req *http.Request) (*http.Response, error) {
+	if !strings.HasSuffix(req.URL.Path, ".prov") {
+		h.t.Errorf("unexpected request of %s", req.URL)
+	}
+	return (&badHTTPClient{}).Do(req)
+}
+
+func Test_fetchAndImportFilesSharedContent(t *testing.T) {
+	charts := indexCharts(t, validRepoIndexYAML, repo{Name: "mirror", URL: "http://mirror.example.com"}, new(filters))
+	c := charts[0]
+	cv := c.ChartVersions[0]
+	chartFilesID := fmt.Sprintf("mirror/%s-%s", c.Name, cv.Version)
+
+	t.Run("mirrored chart version", func(t *testing.T) {
+		netClient = &provOnlyClient{t}
+		m := mock.Mock{}
+		m.On("One", &chartFiles{}).Return(errors.New("not imported from this repository"))
+		m.On("One", &chartContent{}).Return(nil)
+		m.On("UpsertId", chartFilesID, chartFiles{ID: chartFilesID, Repo: c.Repo, Digest: cv.Digest, Shared: true, Chart: c.Name, Version: cv.Version, FilesVersion: chartFilesVersion})
+		dbSession := mockstore.NewMockSession(&m)
+		assert.NoErr(t, fetchAndImportFiles(dbSession, c.Name, c.Repo, cv))
+		m.AssertExpectations(t)
+	})
+
+	t.Run("tarball matching its digest", func(t *testing.T) {
+		client := &goodTarballClient{c: c, skipSchema: true}
+		req, _ := http.NewRequest("GET", "http://mirror.example.com/tarball.tgz", nil)
+		res, _ := client.Do(req)
+		tarball, _ := ioutil.ReadAll(res.Body)
+		sum := sha256.Sum256(tarball)
+		cv := cv
+		cv.Digest = hex.EncodeToString(sum[:])
+
+		netClient = client
+		m := mock.Mock{}
+		m.On("One", mock.Anything).Return(errors.New("not imported"))
+		m.On("UpsertId", cv.Digest, chartContent{ID: cv.Digest, Readme: testChartReadme, Values: testChartValues, Files: testChartFileListing(c.Name, client.files()), FilesVersion: chartFilesVersion})
+		m.On("UpsertId", chartFilesID, chartFiles{ID: chartFilesID, Repo: c.Repo, Digest: cv.Digest, Shared: true, Chart: c.Name, Version: cv.Version, FilesVersion: chartFilesVersion})
+		dbSession := mockstore.NewMockSession(&m)
+		assert.NoErr(t, fetchAndImportFiles(dbSession, c.Name, c.Repo, cv))
+		m.AssertExpectations(t)
+	})
+}
+
+func Test_digestMatches(t *testing.T) {
+	const digest = "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824"
+	assert.True(t, digestMatches(digest, []byte("hello")), "digest")
+	assert.True(t, digestMatches(strings.ToUpper(digest), []byte("hello")), "upper case digest")
+	assert.False(t, digestMatches(digest, []byte("hello!")), "other tarball")
+	assert.False(t, digestMatches("", []byte("hello")), "missing digest")
+}
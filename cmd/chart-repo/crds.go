@@ -0,0 +1,127 @@
+/*
+Copyright (c) 2018 The Helm Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/ghodss/yaml"
+	log "github.com/sirupsen/logrus"
+)
+
+// crd is a CustomResourceDefinition shipped in the crds directory of a chart,
+// or of one of its subcharts
+type crd struct {
+	// Name is the name of the definition, <plural>.<group>
+	Name     string   `bson:"name"`
+	Group    string   `bson:"group"`
+	Kind     string   `bson:"kind"`
+	Plural   string   `bson:"plural"`
+	Scope    string   `bson:"scope"`
+	Versions []string `bson:"versions"`
+	// Chart and Version identify the chart version shipping the definition,
+	// Path is the file it is defined in
+	Chart   string `bson:"chart"`
+	Version string `bson:"version"`
+	Path    string `bson:"path"`
+}
+
+// crdManifest holds the fields of the apiextensions.k8s.io v1 and v1beta1
+// CustomResourceDefinitions catalogued by chart-repo
+type crdManifest struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	Metadata   struct {
+		Name string `json:"name"`
+	} `json:"metadata"`
+	Spec struct {
+		Group string `json:"group"`
+		Names struct {
+			Kind   string `json:"kind"`
+			Plural string `json:"plural"`
+		} `json:"names"`
+		Scope string `json:"scope"`
+		// Version is only set by v1beta1 definitions
+		Version  string `json:"version"`
+		Versions []struct {
+			Name string `json:"name"`
+		} `json:"versions"`
+	} `json:"spec"`
+}
+
+var yamlDocumentSeparator = regexp.MustCompile(`(?m)^---[ \t]*(#.*)?$`)
+
+// isCRDFile returns true if the file of the tarball is in a crds directory,
+// Helm installs these files before rendering the templates
+func isCRDFile(name string) bool {
+	switch path.Ext(name) {
+	case ".yaml", ".yml", ".json":
+		return strings.Contains("/"+path.Dir(name)+"/", "/crds/")
+	}
+	return false
+}
+
+// chartCRDs returns the definitions of the crds files of the tarball of a chart
+// version, files which cannot be parsed are skipped
+func chartCRDs(chartName, version string, files map[string]string) []crd {
+	var names []string
+	for name := range files {
+		if isCRDFile(name) && strings.HasPrefix(name, chartName+"/") {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	var crds []crd
+	for _, name := range names {
+		p := strings.TrimPrefix(name, chartName+"/")
+		for _, doc := range yamlDocumentSeparator.Split(files[name], -1) {
+			if strings.TrimSpace(doc) == "" {
+				continue
+			}
+			var m crdManifest
+			if err := yaml.Unmarshal([]byte(doc), &m); err != nil {
+				log.WithFields(log.Fields{"name": chartName, "version": version, "path": p}).WithError(err).Warn("could not parse CRD")
+				break
+			}
+			if m.Kind != "CustomResourceDefinition" || !strings.HasPrefix(m.APIVersion, "apiextensions.k8s.io/") {
+				continue
+			}
+			c := crd{
+				Name:    m.Metadata.Name,
+				Group:   m.Spec.Group,
+				Kind:    m.Spec.Names.Kind,
+				Plural:  m.Spec.Names.Plural,
+				Scope:   m.Spec.Scope,
+				Chart:   chartName,
+				Version: version,
+				Path:    p,
+			}
+			for _, v := range m.Spec.Versions {
+				c.Versions = append(c.Versions, v.Name)
+			}
+			if len(c.Versions) == 0 && m.Spec.Version != "" {
+				c.Versions = []string{m.Spec.Version}
+			}
+			crds = append(crds, c)
+		}
+	}
+	return crds
+}
@@ -0,0 +1,136 @@
+/*
+Copyright (c) 2018 The Helm Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/arschles/assert"
+	"github.com/kubeapps/common/datastore/mockstore"
+	"github.com/stretchr/testify/mock"
+)
+
+var testChartCRD = `# cert-manager CRDs
+apiVersion: apiextensions.k8s.io/v1
+kind: CustomResourceDefinition
+metadata:
+  name: certificates.cert-manager.io
+spec:
+  group: cert-manager.io
+  names:
+    kind: Certificate
+    plural: certificates
+  scope: Namespaced
+  versions:
+  - name: v1alpha2
+  - name: v1
+---
+apiVersion: apiextensions.k8s.io/v1beta1
+kind: CustomResourceDefinition
+metadata:
+  name: issuers.cert-manager.io
+spec:
+  group: cert-manager.io
+  version: v1alpha2
+  names:
+    kind: Issuer
+    plural: issuers
+  scope: Namespaced
+--- # not a CRD
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: cert-manager
+`
+
+var testChartCRDs = []crd{
+	{Name: "certificates.cert-manager.io", Group: "cert-manager.io", Kind: "Certificate", Plural: "certificates", Scope: "Namespaced", Versions: []string{"v1alpha2", "v1"}, Chart: "acs-engine-autoscaler", Version: "2.1.1", Path: "crds/certificates.yaml"},
+	{Name: "issuers.cert-manager.io", Group: "cert-manager.io", Kind: "Issuer", Plural: "issuers", Scope: "Namespaced", Versions: []string{"v1alpha2"}, Chart: "acs-engine-autoscaler", Version: "2.1.1", Path: "crds/certificates.yaml"},
+}
+
+// chartCRDsOf returns testChartCRDs as shipped by the chart version
+func chartCRDsOf(chartName, version string) []crd {
+	var crds []crd
+	for _, c := range testChartCRDs {
+		c.Chart, c.Version = chartName, version
+		crds = append(crds, c)
+	}
+	return crds
+}
+
+func Test_isCRDFile(t *testing.T) {
+	tests := []struct {
+		name string
+		want bool
+	}{
+		{"cert-manager/crds/certificates.yaml", true},
+		{"cert-manager/crds/v1/certificates.json", true},
+		{"cert-manager/charts/webhook/crds/webhooks.yml", true},
+		{"cert-manager/crds/README.md", false},
+		{"cert-manager/templates/crds.yaml", false},
+		{"cert-manager/values.yaml", false},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, isCRDFile(tt.name), tt.want, tt.name)
+	}
+}
+
+func Test_chartCRDs(t *testing.T) {
+	files := map[string]string{
+		"acs-engine-autoscaler/README.md":              "# readme",
+		"acs-engine-autoscaler/crds/certificates.yaml": testChartCRD,
+		"acs-engine-autoscaler/crds/invalid.yaml":      "kind: [",
+	}
+	assert.Equal(t, chartCRDs("acs-engine-autoscaler", "2.1.1", files), testChartCRDs, "CRDs")
+	assert.Equal(t, len(chartCRDs("acs-engine-autoscaler", "2.1.1", map[string]string{})), 0, "chart without CRDs")
+}
+
+func Test_fetchAndImportFilesCRDs(t *testing.T) {
+	charts := indexCharts(t, validRepoIndexYAML, repo{Name: "test", URL: "http://testrepo.com"}, new(filters))
+	c := charts[0]
+	cv := c.ChartVersions[0]
+	chartFilesID := fmt.Sprintf("test/%s-%s", c.Name, cv.Version)
+
+	client := &goodTarballClient{c: c, crds: true}
+	netClient = client
+	m := mock.Mock{}
+	m.On("One", mock.Anything).Return(errors.New("not imported"))
+	m.On("UpsertId", chartFilesID, chartFiles{chartFilesID, testChartReadme, testChartValues, testChartSchema, c.Repo, cv.Digest, false, "", nil, "", "", "", "", false, testChartFileListing(c.Name, client.files()), chartCRDsOf(c.Name, cv.Version), nil, "", c.Name, cv.Version, chartFilesVersion})
+	dbSession := mockstore.NewMockSession(&m)
+	assert.NoErr(t, fetchAndImportFiles(dbSession, c.Name, c.Repo, cv))
+	m.AssertExpectations(t)
+}
+
+func Test_fetchAndImportFilesSharedCRDs(t *testing.T) {
+	charts := indexCharts(t, validRepoIndexYAML, repo{Name: "mirror", URL: "http://mirror.example.com"}, new(filters))
+	c := charts[0]
+	cv := c.ChartVersions[0]
+	chartFilesID := fmt.Sprintf("mirror/%s-%s", c.Name, cv.Version)
+
+	netClient = &provOnlyClient{t}
+	m := mock.Mock{}
+	m.On("One", &chartFiles{}).Return(errors.New("not imported from this repository"))
+	m.On("One", &chartContent{}).Return(nil).Run(func(args mock.Arguments) {
+		*args.Get(0).(*chartContent) = chartContent{ID: cv.Digest, CRDs: testChartCRDs}
+	})
+	m.On("UpsertId", chartFilesID, chartFiles{ID: chartFilesID, Repo: c.Repo, Digest: cv.Digest, Shared: true, CRDs: testChartCRDs, Chart: c.Name, Version: cv.Version, FilesVersion: chartFilesVersion})
+	dbSession := mockstore.NewMockSession(&m)
+	assert.NoErr(t, fetchAndImportFiles(dbSession, c.Name, c.Repo, cv))
+	m.AssertExpectations(t)
+}
@@ -0,0 +1,187 @@
+/*
+Copyright (c) 2018 The Helm Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// credentialProvider adds the credentials of a repository to its requests
+type credentialProvider interface {
+	authorize(req *http.Request) error
+}
+
+// authHosts are the hosts, or glob patterns of hosts, other than the host of
+// the repository URL the credentials of the repositories are sent to, unless
+// overridden by the repos file. Icons and tarballs served from other hosts are
+// fetched anonymously.
+var authHosts []string
+
+// authorizes returns true if the credentials of the repository can be sent to
+// the host
+func (r repo) authorizes(host string) bool {
+	if u, err := url.Parse(r.URL); err == nil && strings.EqualFold(u.Hostname(), host) {
+		return true
+	}
+	for _, pattern := range r.AuthHosts {
+		if matched, _ := path.Match(strings.ToLower(pattern), strings.ToLower(host)); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// headerCredentials is a raw Authorization header, e.g. the one given through
+// the AUTHORIZATION_HEADER environment variable
+type headerCredentials string
+
+func (c headerCredentials) authorize(req *http.Request) error {
+	if c != "" {
+		req.Header.Set("Authorization", string(c))
+	}
+	return nil
+}
+
+// basicAuthCredentials authenticate with a username and password
+type basicAuthCredentials struct {
+	username string
+	password string
+}
+
+func (c *basicAuthCredentials) authorize(req *http.Request) error {
+	req.SetBasicAuth(c.username, c.password)
+	return nil
+}
+
+// tokenFileCredentials authenticate with a bearer token read from a file, the
+// file is read again when it changes so rotated tokens are picked up
+type tokenFileCredentials struct {
+	file *watchedFile
+}
+
+func newTokenFileCredentials(path string) *tokenFileCredentials {
+	return &tokenFileCredentials{file: &watchedFile{path: path}}
+}
+
+func (c *tokenFileCredentials) authorize(req *http.Request) error {
+	b, err := c.file.read()
+	if err != nil {
+		return err
+	}
+	token := strings.TrimSpace(string(b))
+	if token == "" {
+		return fmt.Errorf("token file %s is empty", c.file.path)
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	return nil
+}
+
+// dockerConfigCredentials authenticate with the credentials of the host of the
+// request in a Docker config file, i.e. the "auths" of a config.json
+type dockerConfigCredentials struct {
+	file *watchedFile
+}
+
+type dockerConfig struct {
+	Auths map[string]dockerAuth `json:"auths"`
+}
+
+type dockerAuth struct {
+	Auth          string `json:"auth"`
+	Username      string `json:"username"`
+	Password      string `json:"password"`
+	RegistryToken string `json:"registrytoken"`
+}
+
+func newDockerConfigCredentials(path string) *dockerConfigCredentials {
+	return &dockerConfigCredentials{file: &watchedFile{path: path}}
+}
+
+func (c *dockerConfigCredentials) authorize(req *http.Request) error {
+	b, err := c.file.read()
+	if err != nil {
+		return err
+	}
+	var config dockerConfig
+	if err := json.Unmarshal(b, &config); err != nil {
+		return fmt.Errorf("invalid docker config %s: %v", c.file.path, err)
+	}
+	for key, auth := range config.Auths {
+		if dockerConfigHost(key) != req.URL.Host {
+			continue
+		}
+		switch {
+		case auth.RegistryToken != "":
+			req.Header.Set("Authorization", "Bearer "+auth.RegistryToken)
+		case auth.Auth != "":
+			req.Header.Set("Authorization", "Basic "+auth.Auth)
+		case auth.Username != "":
+			req.SetBasicAuth(auth.Username, auth.Password)
+		}
+		return nil
+	}
+	// Hosts without credentials are requested anonymously
+	return nil
+}
+
+// dockerConfigHost returns the host of a key of the auths of a Docker config,
+// which can be a host or a URL
+func dockerConfigHost(key string) string {
+	if strings.Contains(key, "://") {
+		if u, err := url.Parse(key); err == nil {
+			return u.Host
+		}
+	}
+	return strings.SplitN(key, "/", 2)[0]
+}
+
+// watchedFile caches the content of a file, read again when it is modified
+type watchedFile struct {
+	path string
+
+	mu      sync.Mutex
+	modTime time.Time
+	size    int64
+	content []byte
+}
+
+func (f *watchedFile) read() ([]byte, error) {
+	info, err := os.Stat(f.path)
+	if err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.content != nil && info.ModTime().Equal(f.modTime) && info.Size() == f.size {
+		return f.content, nil
+	}
+	content, err := ioutil.ReadFile(f.path)
+	if err != nil {
+		return nil, err
+	}
+	f.content, f.modTime, f.size = content, info.ModTime(), info.Size()
+	return content, nil
+}
@@ -0,0 +1,254 @@
+/*
+Copyright (c) 2018 The Helm Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/arschles/assert"
+)
+
+func authorizationOf(t *testing.T, c credentialProvider, url string) string {
+	req, _ := http.NewRequest("GET", url, nil)
+	assert.NoErr(t, c.authorize(req))
+	return req.Header.Get("Authorization")
+}
+
+func Test_basicAuthCredentials(t *testing.T) {
+	c := &basicAuthCredentials{username: "user", password: "pass"}
+	assert.Equal(t, authorizationOf(t, c, "https://charts.example.com/index.yaml"), "Basic dXNlcjpwYXNz", "authorization")
+}
+
+func Test_tokenFileCredentials(t *testing.T) {
+	dir, err := ioutil.TempDir("", "token")
+	assert.NoErr(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "token")
+
+	c := newTokenFileCredentials(path)
+	req, _ := http.NewRequest("GET", "https://charts.example.com/index.yaml", nil)
+	assert.ExistsErr(t, c.authorize(req), "missing token file")
+
+	assert.NoErr(t, ioutil.WriteFile(path, []byte("first\n"), 0600))
+	assert.Equal(t, authorizationOf(t, c, "https://charts.example.com/index.yaml"), "Bearer first", "authorization")
+
+	// The rotated token is picked up
+	assert.NoErr(t, ioutil.WriteFile(path, []byte("second-token\n"), 0600))
+	later := time.Now().Add(time.Minute)
+	assert.NoErr(t, os.Chtimes(path, later, later))
+	assert.Equal(t, authorizationOf(t, c, "https://charts.example.com/index.yaml"), "Bearer second-token", "authorization")
+}
+
+func Test_dockerConfigCredentials(t *testing.T) {
+	dir, err := ioutil.TempDir("", "docker")
+	assert.NoErr(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "config.json")
+	config := `{"auths": {
+		"https://charts.example.com/v1/": {"auth": "dXNlcjpwYXNz"},
+		"other.example.com": {"username": "other", "password": "secret"},
+		"token.example.com": {"registrytoken": "t0k3n"}
+	}}`
+	assert.NoErr(t, ioutil.WriteFile(path, []byte(config), 0600))
+
+	c := newDockerConfigCredentials(path)
+	tests := []struct {
+		name          string
+		url           string
+		authorization string
+	}{
+		{"auth", "https://charts.example.com/index.yaml", "Basic dXNlcjpwYXNz"},
+		{"username and password", "https://other.example.com/index.yaml", "Basic b3RoZXI6c2VjcmV0"},
+		{"registry token", "https://token.example.com/index.yaml", "Bearer t0k3n"},
+		{"unknown host", "https://public.example.com/index.yaml", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, authorizationOf(t, c, tt.url), tt.authorization, "authorization")
+		})
+	}
+}
+
+func Test_loadReposFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "repos")
+	assert.NoErr(t, err)
+	defer os.RemoveAll(dir)
+	write := func(content string) string {
+		path := filepath.Join(dir, "repos.yaml")
+		assert.NoErr(t, ioutil.WriteFile(path, []byte(content), 0600))
+		return path
+	}
+
+	configs, err := loadReposFile(write(`
+repositories:
+- name: private
+  url: https://charts.example.com
+  auth:
+    username: user
+    password: pass
+- name: public
+  url: https://public.example.com
+`))
+	assert.NoErr(t, err)
+	assert.Equal(t, len(configs), 2, "number of repositories")
+
+	r, err := configuredRepo(configs, "private", "", "Bearer ignored")
+	assert.NoErr(t, err)
+	assert.Equal(t, r.URL, "https://charts.example.com", "url")
+	assert.Equal(t, authorizationOf(t, r.Credentials, r.URL), "Basic dXNlcjpwYXNz", "authorization")
+
+	r, err = configuredRepo(configs, "other", "https://other.example.com", "Bearer t0k3n")
+	assert.NoErr(t, err)
+	assert.Equal(t, authorizationOf(t, r.Credentials, r.URL), "Bearer t0k3n", "authorization")
+
+	t.Run("duplicate repository", func(t *testing.T) {
+		_, err := loadReposFile(write("repositories:\n- name: a\n- name: a\n"))
+		assert.ExistsErr(t, err, "duplicate repository")
+	})
+	t.Run("several credentials", func(t *testing.T) {
+		c := repoConfig{Name: "a", Auth: repoAuthConfig{Username: "user", TokenFile: "/token"}}
+		_, err := c.repo()
+		assert.ExistsErr(t, err, "several credentials")
+	})
+	t.Run("certificate without key", func(t *testing.T) {
+		c := repoConfig{Name: "a", Auth: repoAuthConfig{CertFile: "/tls.crt"}}
+		_, err := c.repo()
+		assert.ExistsErr(t, err, "certificate without key")
+	})
+}
+
+func Test_repoAuthorizes(t *testing.T) {
+	r := repo{URL: "https://charts.example.com/stable", AuthHosts: []string{"*.cdn.example.com", "files.example.org"}}
+	tests := []struct {
+		host       string
+		authorizes bool
+	}{
+		{"charts.example.com", true},
+		{"Charts.Example.com", true},
+		{"eu.cdn.example.com", true},
+		{"files.example.org", true},
+		{"raw.githubusercontent.com", false},
+		{"example.com", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.host, func(t *testing.T) {
+			assert.Equal(t, r.authorizes(tt.host), tt.authorizes, "authorizes")
+		})
+	}
+}
+
+// headerRecorder records the Authorization header of the requests
+type headerRecorder struct {
+	authorization map[string]string
+}
+
+func (h *headerRecorder) Do(req *http.Request) (*http.Response, error) {
+	h.authorization[req.URL.Host] = req.Header.Get("Authorization")
+	w := httptest.NewRecorder()
+	w.WriteHeader(http.StatusNotFound)
+	return w.Result(), nil
+}
+
+func Test_repoDoScopesCredentials(t *testing.T) {
+	recorder := &headerRecorder{authorization: map[string]string{}}
+	netClient = recorder
+	r := repo{URL: "https://charts.example.com", Credentials: headerCredentials("Bearer s3cr3t")}
+	for _, u := range []string{"https://charts.example.com/index.yaml", "https://raw.githubusercontent.com/icon.png"} {
+		req, _ := http.NewRequest("GET", u, nil)
+		res, err := r.do(req)
+		assert.NoErr(t, err)
+		res.Body.Close()
+	}
+	assert.Equal(t, recorder.authorization["charts.example.com"], "Bearer s3cr3t", "repository host")
+	assert.Equal(t, recorder.authorization["raw.githubusercontent.com"], "", "icon host")
+
+	t.Run("repository client", func(t *testing.T) {
+		recorder := &headerRecorder{authorization: map[string]string{}}
+		netClient = recorder
+		repoClient := &headerRecorder{authorization: map[string]string{}}
+		r := repo{URL: "https://charts.example.com", Client: repoClient}
+		for _, u := range []string{"https://charts.example.com/index.yaml", "https://raw.githubusercontent.com/icon.png"} {
+			req, _ := http.NewRequest("GET", u, nil)
+			res, err := r.do(req)
+			assert.NoErr(t, err)
+			res.Body.Close()
+		}
+		_, ok := repoClient.authorization["charts.example.com"]
+		assert.True(t, ok, "the repository host is requested with the repository client")
+		_, ok = repoClient.authorization["raw.githubusercontent.com"]
+		assert.False(t, ok, "other hosts are not requested with the repository client")
+		_, ok = recorder.authorization["raw.githubusercontent.com"]
+		assert.True(t, ok, "other hosts are requested with the default client")
+	})
+}
+
+func Test_repoConfigClient(t *testing.T) {
+	defer func(n int) { maxRetries = n }(maxRetries)
+	maxRetries = 0
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "tls")
+	assert.NoErr(t, err)
+	defer os.RemoveAll(dir)
+	caFile := filepath.Join(dir, "ca.crt")
+	ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	assert.NoErr(t, ioutil.WriteFile(caFile, ca, 0600))
+
+	tests := []struct {
+		name   string
+		config repoConfig
+		valid  bool
+	}{
+		{"untrusted certificate", repoConfig{NoProxy: true}, false},
+		{"CA bundle", repoConfig{TLS: repoTLSConfig{CAFile: caFile}, NoProxy: true}, true},
+		{"insecure", repoConfig{TLS: repoTLSConfig{InsecureSkipVerify: true}, NoProxy: true}, true},
+		// Nothing listens on the proxy
+		{"proxy", repoConfig{TLS: repoTLSConfig{CAFile: caFile}, Proxy: "http://127.0.0.1:1"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.config.URL = server.URL
+			r, err := tt.config.repo()
+			assert.NoErr(t, err)
+			req, _ := http.NewRequest("GET", server.URL+"/index.yaml", nil)
+			res, err := r.do(req)
+			if res != nil {
+				res.Body.Close()
+			}
+			assert.Equal(t, err == nil, tt.valid, "request succeeds")
+		})
+	}
+
+	t.Run("invalid CA bundle", func(t *testing.T) {
+		_, err := repoConfig{Name: "a", TLS: repoTLSConfig{CAFile: filepath.Join(dir, "missing.crt")}}.repo()
+		assert.ExistsErr(t, err, "missing CA bundle")
+	})
+	t.Run("proxy and no proxy", func(t *testing.T) {
+		_, err := repoConfig{Name: "a", Proxy: "http://proxy:3128", NoProxy: true}.repo()
+		assert.ExistsErr(t, err, "proxy and no proxy")
+	})
+}
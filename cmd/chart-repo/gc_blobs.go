@@ -0,0 +1,56 @@
+/*
+Copyright (c) 2018 The Helm Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var gcBlobsCmd = &cobra.Command{
+	Use:   "gc-blobs",
+	Short: "delete the blobs no longer referenced by any chart",
+	Long: `Delete the icons and files of the blob store no longer referenced by any
+chart, such as replaced icons and the files of deleted repositories. Blobs are
+shared between repositories and are not deleted with them.
+
+A blob stored again by a sync while the command runs may be deleted, run it
+when no sync is running.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		minAge, err := cmd.Flags().GetDuration("min-age")
+		if err != nil {
+			logrus.Fatal(err)
+		}
+		if err := openBlobStore(cmd); err != nil {
+			logrus.Fatal(err)
+		}
+		if blobs == nil {
+			logrus.Fatal("no --blob-store given")
+		}
+		dbSession, err := dbSessionFromFlags(cmd)
+		if err != nil {
+			logrus.Fatalf("Can't connect to mongoDB: %v", err)
+		}
+		deleted, err := collectBlobGarbage(dbSession, minAge, time.Now())
+		if err != nil {
+			logrus.Fatalf("Can't delete unreferenced blobs: %v", err)
+		}
+		logrus.Infof("Successfully deleted %d unreferenced blobs", deleted)
+	},
+}
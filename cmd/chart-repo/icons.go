@@ -0,0 +1,274 @@
+/*
+Copyright (c) 2018 The Helm Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"strings"
+
+	"github.com/disintegration/imaging"
+	"github.com/helm/monocular/pkg/webp"
+)
+
+var (
+	// iconSizes are the sizes raster icons are resized to, the icon of the
+	// first size in PNG is the default one
+	iconSizes = []int{160}
+	// iconFormats are the formats each size is encoded in
+	iconFormats = []string{"png", "webp"}
+)
+
+var iconContentTypes = map[string]string{
+	"png":  "image/png",
+	"webp": "image/webp",
+}
+
+// validateIconSettings checks the icon sizes and formats given with the
+// --icon-size and --icon-format flags, so they do not fail every icon import
+func validateIconSettings() error {
+	if len(iconSizes) == 0 {
+		return errors.New("no icon sizes")
+	}
+	for _, size := range iconSizes {
+		if size < 1 {
+			return fmt.Errorf("invalid icon size %d", size)
+		}
+	}
+	for _, format := range iconFormats {
+		if _, ok := iconContentTypes[format]; !ok {
+			return fmt.Errorf("unsupported icon format %q", format)
+		}
+	}
+	return nil
+}
+
+// iconVariants resizes the icon to each size and encodes it in each format
+func iconVariants(orig image.Image) ([]iconVariant, error) {
+	if len(iconSizes) == 0 {
+		return nil, errors.New("no icon sizes")
+	}
+	var variants []iconVariant
+	for _, size := range iconSizes {
+		if size < 1 {
+			return nil, fmt.Errorf("invalid icon size %d", size)
+		}
+		icon := imaging.Fit(orig, size, size, imaging.Lanczos)
+		for _, format := range iconFormats {
+			var buf bytes.Buffer
+			if err := encodeIcon(&buf, icon, format); err != nil {
+				return nil, err
+			}
+			variants = append(variants, iconVariant{Size: size, Format: format, ContentType: iconContentTypes[format], Data: buf.Bytes()})
+		}
+	}
+	return variants, nil
+}
+
+// defaultIcon returns the PNG icon of the first size, served when no size or
+// format is requested
+func defaultIcon(orig image.Image, variants []iconVariant) ([]byte, error) {
+	for _, v := range variants {
+		if v.Size == iconSizes[0] && v.Format == "png" {
+			return v.Data, nil
+		}
+	}
+	var buf bytes.Buffer
+	err := encodeIcon(&buf, imaging.Fit(orig, iconSizes[0], iconSizes[0], imaging.Lanczos), "png")
+	return buf.Bytes(), err
+}
+
+func encodeIcon(w io.Writer, icon image.Image, format string) error {
+	switch format {
+	case "png":
+		return imaging.Encode(w, icon, imaging.PNG)
+	case "webp":
+		return webp.Encode(w, icon)
+	default:
+		return fmt.Errorf("unsupported icon format %q", format)
+	}
+}
+
+// svgUnsafeElements are removed from SVG icons along with their content
+var svgUnsafeElements = map[string]bool{
+	"script":        true,
+	"foreignobject": true,
+	"iframe":        true,
+	"object":        true,
+	"embed":         true,
+	"audio":         true,
+	"video":         true,
+	"handler":       true,
+	"listener":      true,
+}
+
+// sanitizeSVG removes scripts, event handlers and references to external
+// resources from an SVG icon, since it is served from our own domain
+func sanitizeSVG(r io.Reader) ([]byte, error) {
+	d := xml.NewDecoder(r)
+	d.Strict = true
+	var out bytes.Buffer
+	// skip is the depth of the unsafe element being removed, if any
+	skip := 0
+	root := true
+	// elements holds the names of the open elements
+	var elements []string
+	for {
+		tok, err := d.RawToken()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			elements = append(elements, qualifiedName(t.Name))
+			if root {
+				if strings.ToLower(t.Name.Local) != "svg" {
+					return nil, errors.New("icon is not an SVG image")
+				}
+				root = false
+			}
+			if skip > 0 {
+				continue
+			}
+			if svgUnsafeElements[strings.ToLower(t.Name.Local)] || animatesHref(t) {
+				skip = len(elements)
+				continue
+			}
+			writeSVGStart(&out, t)
+		case xml.EndElement:
+			// The raw tokens are not checked by the decoder
+			if len(elements) == 0 || elements[len(elements)-1] != qualifiedName(t.Name) {
+				return nil, fmt.Errorf("unexpected end element %s", qualifiedName(t.Name))
+			}
+			elements = elements[:len(elements)-1]
+			if skip > 0 {
+				if len(elements) < skip {
+					skip = 0
+				}
+				continue
+			}
+			out.WriteString("</" + qualifiedName(t.Name) + ">")
+		case xml.CharData:
+			if skip > 0 || len(elements) == 0 {
+				continue
+			}
+			if strings.EqualFold(elements[len(elements)-1], "style") && !safeCSS(string(t)) {
+				continue
+			}
+			xml.EscapeText(&out, t)
+		case xml.ProcInst:
+			// Only the XML declaration is kept
+			if t.Target == "xml" && out.Len() == 0 {
+				out.WriteString("<?xml " + string(t.Inst) + "?>")
+			}
+		}
+		// Comments and directives, e.g. DOCTYPEs declaring entities, are dropped
+	}
+	if root {
+		return nil, errors.New("icon is not an SVG image")
+	}
+	if len(elements) > 0 {
+		return nil, io.ErrUnexpectedEOF
+	}
+	return out.Bytes(), nil
+}
+
+// animatesHref returns true for animations setting references, which could
+// point to external resources
+func animatesHref(t xml.StartElement) bool {
+	for _, a := range t.Attr {
+		if strings.EqualFold(a.Name.Local, "attributeName") && strings.HasSuffix(strings.ToLower(strings.TrimSpace(a.Value)), "href") {
+			return true
+		}
+	}
+	return false
+}
+
+func writeSVGStart(out *bytes.Buffer, t xml.StartElement) {
+	out.WriteString("<" + qualifiedName(t.Name))
+	for _, a := range t.Attr {
+		if !safeSVGAttr(a) {
+			continue
+		}
+		out.WriteString(" " + qualifiedName(a.Name) + `="`)
+		xml.EscapeText(out, []byte(a.Value))
+		out.WriteString(`"`)
+	}
+	out.WriteString(">")
+}
+
+// safeSVGAttr returns false for event handlers and attributes referencing
+// external resources
+func safeSVGAttr(a xml.Attr) bool {
+	name := strings.ToLower(a.Name.Local)
+	value := strings.ToLower(strings.TrimSpace(a.Value))
+	switch {
+	case strings.HasPrefix(name, "on") || strings.Contains(value, "javascript:"):
+		return false
+	case name == "attributename" && strings.HasSuffix(value, "href"):
+		// Animations could set references to external resources
+		return false
+	case name == "href" || name == "src" || name == "action" || name == "formaction":
+		// Only references within the icon and embedded raster images
+		return strings.HasPrefix(value, "#") || safeDataURL(value)
+	case name == "style" || strings.Contains(value, "url("):
+		return safeCSS(value)
+	}
+	return true
+}
+
+func safeDataURL(value string) bool {
+	for _, prefix := range []string{"data:image/png", "data:image/jpeg", "data:image/gif", "data:image/webp"} {
+		if strings.HasPrefix(value, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// safeCSS returns false if the CSS imports or references anything outside the
+// icon
+func safeCSS(css string) bool {
+	css = strings.ToLower(css)
+	if strings.Contains(css, "@import") || strings.Contains(css, "expression(") || strings.Contains(css, "javascript:") {
+		return false
+	}
+	for {
+		i := strings.Index(css, "url(")
+		if i < 0 {
+			return true
+		}
+		css = strings.TrimLeft(css[i+len("url("):], " \t\n\"'")
+		if !strings.HasPrefix(css, "#") && !safeDataURL(css) {
+			return false
+		}
+	}
+}
+
+func qualifiedName(n xml.Name) string {
+	if n.Space == "" {
+		return n.Local
+	}
+	return n.Space + ":" + n.Local
+}
@@ -0,0 +1,163 @@
+/*
+Copyright (c) 2018 The Helm Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"strings"
+	"testing"
+
+	"github.com/arschles/assert"
+	"github.com/disintegration/imaging"
+	"golang.org/x/image/webp"
+)
+
+func Test_iconVariants(t *testing.T) {
+	defer func(sizes []int, formats []string) { iconSizes, iconFormats = sizes, formats }(iconSizes, iconFormats)
+	iconSizes = []int{64, 160}
+	iconFormats = []string{"png", "webp"}
+
+	orig := imaging.New(320, 160, color.White)
+	variants, err := iconVariants(orig)
+	assert.NoErr(t, err)
+	assert.Equal(t, len(variants), 4, "number of variants")
+	for _, v := range variants {
+		var icon image.Image
+		switch v.Format {
+		case "png":
+			icon, err = imaging.Decode(bytes.NewReader(v.Data))
+		case "webp":
+			icon, err = webp.Decode(bytes.NewReader(v.Data))
+		}
+		assert.NoErr(t, err)
+		assert.Equal(t, v.ContentType, iconContentTypes[v.Format], "content type")
+		// The aspect ratio is kept
+		assert.Equal(t, icon.Bounds().Size(), image.Pt(v.Size, v.Size/2), "size")
+	}
+
+	b, err := defaultIcon(orig, variants)
+	assert.NoErr(t, err)
+	assert.Equal(t, b, variants[0].Data, "default icon")
+
+	t.Run("unsupported format", func(t *testing.T) {
+		iconFormats = []string{"gif"}
+		_, err := iconVariants(orig)
+		assert.ExistsErr(t, err, "unsupported format")
+	})
+	t.Run("no sizes", func(t *testing.T) {
+		iconSizes = nil
+		_, err := iconVariants(orig)
+		assert.ExistsErr(t, err, "no sizes")
+	})
+}
+
+func Test_validateIconSettings(t *testing.T) {
+	defer func(sizes []int, formats []string) { iconSizes, iconFormats = sizes, formats }(iconSizes, iconFormats)
+	tests := []struct {
+		name    string
+		sizes   []int
+		formats []string
+		wantErr bool
+	}{
+		{"default settings", []int{160}, []string{"png", "webp"}, false},
+		{"unsupported format", []int{160}, []string{"png", "gif"}, true},
+		{"invalid size", []int{0}, []string{"png"}, true},
+		{"no sizes", nil, []string{"png"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			iconSizes, iconFormats = tt.sizes, tt.formats
+			err := validateIconSettings()
+			assert.Equal(t, err != nil, tt.wantErr, "error")
+		})
+	}
+}
+
+func Test_sanitizeSVG(t *testing.T) {
+	tests := []struct {
+		name      string
+		svg       string
+		sanitized string
+	}{
+		{
+			"safe icon",
+			`<?xml version="1.0"?><svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 10 10"><path d="M0 0h10v10z" fill="#fff"/></svg>`,
+			`<?xml version="1.0"?><svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 10 10"><path d="M0 0h10v10z" fill="#fff"></path></svg>`,
+		},
+		{
+			"scripts",
+			`<svg><script>alert(1)</script><g><script><![CDATA[alert(2)]]></script><rect/></g></svg>`,
+			`<svg><g><rect></rect></g></svg>`,
+		},
+		{
+			"event handlers",
+			`<svg onload="alert(1)"><rect OnClick="alert(2)" width="1"/></svg>`,
+			`<svg><rect width="1"></rect></svg>`,
+		},
+		{
+			"foreign objects",
+			`<svg><foreignObject><iframe src="https://example.com"></iframe></foreignObject></svg>`,
+			`<svg></svg>`,
+		},
+		{
+			"external references",
+			`<svg xmlns:xlink="http://www.w3.org/1999/xlink"><use xlink:href="https://example.com/a.svg#x"/><use href="#local"/><a href="javascript:alert(1)"></a></svg>`,
+			`<svg xmlns:xlink="http://www.w3.org/1999/xlink"><use></use><use href="#local"></use><a></a></svg>`,
+		},
+		{
+			"embedded images",
+			`<svg><image href="data:image/png;base64,AAAA"/><image href="data:image/svg+xml;base64,AAAA"/></svg>`,
+			`<svg><image href="data:image/png;base64,AAAA"></image><image></image></svg>`,
+		},
+		{
+			"styles",
+			`<svg><style>@import url(https://example.com/a.css);</style><style>rect { fill: url(#g) }</style><rect style="fill: url('https://example.com/p')" fill="url(#g)"/></svg>`,
+			`<svg><style></style><style>rect { fill: url(#g) }</style><rect fill="url(#g)"></rect></svg>`,
+		},
+		{
+			"animated references",
+			`<svg><a><set attributeName="href" to="javascript:alert(1)"/><animate attributeName="xlink:href" values="https://example.com"/></a></svg>`,
+			`<svg><a></a></svg>`,
+		},
+		{
+			"comments and doctype",
+			`<!DOCTYPE svg [<!ENTITY x "y">]><!-- comment --><svg><text>a &lt; b</text></svg>`,
+			`<svg><text>a &lt; b</text></svg>`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, err := sanitizeSVG(strings.NewReader(tt.svg))
+			assert.NoErr(t, err)
+			assert.Equal(t, string(b), tt.sanitized, "sanitized icon")
+		})
+	}
+
+	for _, invalid := range []string{
+		`<html><script>alert(1)</script></html>`,
+		`<svg><g></svg>`,
+		`<svg><script>`,
+		`not an icon`,
+	} {
+		t.Run(invalid, func(t *testing.T) {
+			_, err := sanitizeSVG(strings.NewReader(invalid))
+			assert.ExistsErr(t, err, "invalid icon")
+		})
+	}
+}
@@ -0,0 +1,146 @@
+/*
+Copyright (c) 2018 The Helm Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/ghodss/yaml"
+	"github.com/helm/monocular/pkg/imageref"
+	"k8s.io/helm/pkg/chartutil"
+	"k8s.io/helm/pkg/engine"
+	hapichart "k8s.io/helm/pkg/proto/hapi/chart"
+	helmversion "k8s.io/helm/pkg/version"
+)
+
+// containerImage is a container image pulled by the default installation of a chart
+// version
+type containerImage struct {
+	// Ref is the reference as rendered in the templates, Name, Tag and Digest
+	// are parsed from it, see the imageref package
+	Ref    string `bson:"ref"`
+	Name   string `bson:"name"`
+	Tag    string `bson:"tag,omitempty"`
+	Digest string `bson:"digest,omitempty"`
+}
+
+// containerFields are the fields of pod specs holding containers
+var containerFields = []string{"containers", "initContainers", "ephemeralContainers"}
+
+// renderImages renders the templates of the tarball of a chart version with
+// its default values, as helm template would, and returns the images of the
+// containers of the rendered manifests. It is run by the render-images command,
+// see render.go.
+func renderImages(tarball []byte) ([]containerImage, error) {
+	rendered, err := renderChart(tarball)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for name := range rendered {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	refs := map[string]bool{}
+	for _, name := range names {
+		if ext := path.Ext(name); ext != ".yaml" && ext != ".yml" && ext != ".json" {
+			continue
+		}
+		for _, doc := range yamlDocumentSeparator.Split(rendered[name], -1) {
+			var manifest interface{}
+			if err := yaml.Unmarshal([]byte(doc), &manifest); err != nil {
+				// rendered manifests are only checked by the API server, so they
+				// are not required to be valid
+				continue
+			}
+			findImages(manifest, refs)
+		}
+	}
+
+	var images []containerImage
+	for ref := range refs {
+		r, err := imageref.Parse(ref)
+		if err != nil {
+			continue
+		}
+		images = append(images, containerImage{Ref: ref, Name: r.Name, Tag: r.Tag, Digest: r.Digest})
+	}
+	sort.Slice(images, func(i, j int) bool { return images[i].Ref < images[j].Ref })
+	return images, nil
+}
+
+// renderChart renders the templates of the chart offline
+func renderChart(tarball []byte) (map[string]string, error) {
+	c, err := chartutil.LoadArchive(bytes.NewReader(tarball))
+	if err != nil {
+		return nil, err
+	}
+	config := &hapichart.Config{Raw: "{}"}
+	if err := chartutil.ProcessRequirementsEnabled(c, config); err != nil {
+		return nil, err
+	}
+	if err := chartutil.ProcessRequirementsImportValues(c); err != nil {
+		return nil, err
+	}
+	options := chartutil.ReleaseOptions{Name: "release-name", Namespace: "default", IsInstall: true, Revision: 1}
+	// the capabilities are those of helm template, templates commonly check
+	// the Kubernetes version to pick the API versions of their resources
+	kubeVersion := *chartutil.DefaultKubeVersion
+	caps := &chartutil.Capabilities{
+		APIVersions:   chartutil.DefaultVersionSet,
+		KubeVersion:   &kubeVersion,
+		TillerVersion: helmversion.GetVersionProto(),
+	}
+	values, err := chartutil.ToRenderValuesCaps(c, config, options, caps)
+	if err != nil {
+		return nil, err
+	}
+
+	e := engine.New()
+	// required values are not given since the default values are used
+	e.LintMode = true
+	return e.Render(c, values)
+}
+
+// findImages adds the images of the containers found in the manifest, at any
+// depth so that the pod templates of every workload are covered
+func findImages(v interface{}, refs map[string]bool) {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for _, field := range containerFields {
+			containers, _ := v[field].([]interface{})
+			for _, c := range containers {
+				if c, ok := c.(map[string]interface{}); ok {
+					if ref, ok := c["image"].(string); ok && strings.TrimSpace(ref) != "" {
+						refs[strings.TrimSpace(ref)] = true
+					}
+				}
+			}
+		}
+		for _, child := range v {
+			findImages(child, refs)
+		}
+	case []interface{}:
+		for _, child := range v {
+			findImages(child, refs)
+		}
+	}
+}
@@ -0,0 +1,128 @@
+/*
+Copyright (c) 2018 The Helm Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/arschles/assert"
+	"github.com/kubeapps/common/datastore/mockstore"
+	"github.com/stretchr/testify/mock"
+)
+
+var testChartDeployment = `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: {{ .Release.Name }}
+spec:
+  template:
+    spec:
+      initContainers:
+      - name: init
+        image: "busybox:1.31"
+      containers:
+      - name: app
+        image: "{{ .Values.image }}"
+`
+
+// testTarball returns a gzipped tarball of the files
+func testTarball(files []tarballFile) []byte {
+	var b bytes.Buffer
+	gzw := gzip.NewWriter(&b)
+	createTestTarball(gzw, files)
+	gzw.Close()
+	return b.Bytes()
+}
+
+func Test_chartImages(t *testing.T) {
+	tarball := testTarball([]tarballFile{
+		{"my-chart/Chart.yaml", "name: my-chart\nversion: 1.0.0"},
+		{"my-chart/values.yaml", "image: bitnami/nginx:1.19.2\nsidecar:\n  enabled: false\nregistry: quay.io"},
+		{"my-chart/templates/deployment.yaml", testChartDeployment},
+		{"my-chart/templates/cronjob.yaml", `apiVersion: batch/v1beta1
+kind: CronJob
+spec:
+  jobTemplate:
+    spec:
+      template:
+        spec:
+          containers:
+          - image: {{ .Values.registry }}/prometheus/node-exporter@sha256:e4ab1b2fb1d29b1e0d1eb2bbcdc5c5a06c3ecbe2b4bfb6d44ac2a5e7d4b8e9d0
+{{- if semverCompare ">=1.9-0" .Capabilities.KubeVersion.GitVersion }}
+          - image: "busybox:{{ .Capabilities.KubeVersion.Major }}.{{ .Capabilities.KubeVersion.Minor }}"
+{{- end }}
+          - image: {{ required "a tag is required" .Values.tag | printf "nginx:%s" | quote }}
+{{- if .Values.sidecar.enabled }}
+          - image: envoyproxy/envoy:v1.15.0
+{{- end }}
+---
+apiVersion: v1
+kind: ConfigMap
+data:
+  containers: not a list
+`},
+		{"my-chart/templates/NOTES.txt", "image: ignored:1.0"},
+		{"my-chart/templates/_helpers.tpl", `{{- define "my-chart.name" }}{{ .Chart.Name }}{{ end }}`},
+	})
+
+	images, err := chartImages(tarball)
+	assert.NoErr(t, err)
+	assert.Equal(t, images, []containerImage{
+		{Ref: "bitnami/nginx:1.19.2", Name: "docker.io/bitnami/nginx", Tag: "1.19.2"},
+		{Ref: "busybox:1.31", Name: "docker.io/library/busybox", Tag: "1.31"},
+		{Ref: "busybox:1.9", Name: "docker.io/library/busybox", Tag: "1.9"},
+		{Ref: "quay.io/prometheus/node-exporter@sha256:e4ab1b2fb1d29b1e0d1eb2bbcdc5c5a06c3ecbe2b4bfb6d44ac2a5e7d4b8e9d0", Name: "quay.io/prometheus/node-exporter", Digest: "sha256:e4ab1b2fb1d29b1e0d1eb2bbcdc5c5a06c3ecbe2b4bfb6d44ac2a5e7d4b8e9d0"},
+	}, "images")
+
+	t.Run("invalid chart", func(t *testing.T) {
+		_, err := chartImages(testTarball([]tarballFile{{"my-chart/values.yaml", "image: nginx"}}))
+		assert.True(t, err != nil, "error")
+	})
+
+	t.Run("invalid template", func(t *testing.T) {
+		_, err := chartImages(testTarball([]tarballFile{
+			{"my-chart/Chart.yaml", "name: my-chart\nversion: 1.0.0"},
+			{"my-chart/templates/deployment.yaml", "{{ .Values.image"},
+		}))
+		assert.True(t, err != nil, "error")
+	})
+
+}
+
+func Test_fetchAndImportFilesImages(t *testing.T) {
+	charts := indexCharts(t, validRepoIndexYAML, repo{Name: "test", URL: "http://testrepo.com"}, new(filters))
+	c := charts[0]
+	cv := c.ChartVersions[0]
+	chartFilesID := fmt.Sprintf("test/%s-%s", c.Name, cv.Version)
+
+	client := &goodTarballClient{c: c, templates: true}
+	netClient = client
+	m := mock.Mock{}
+	m.On("One", mock.Anything).Return(errors.New("not imported"))
+	images := []containerImage{
+		{Ref: "busybox:1.31", Name: "docker.io/library/busybox", Tag: "1.31"},
+		{Ref: "test", Name: "docker.io/library/test", Tag: "latest"},
+	}
+	m.On("UpsertId", chartFilesID, chartFiles{chartFilesID, testChartReadme, testChartValues, testChartSchema, c.Repo, cv.Digest, false, "", nil, "", "", "", "", false, testChartFileListing(c.Name, client.files()), nil, images, "", c.Name, cv.Version, chartFilesVersion})
+	dbSession := mockstore.NewMockSession(&m)
+	assert.NoErr(t, fetchAndImportFiles(dbSession, c.Name, c.Repo, cv))
+	m.AssertExpectations(t)
+}
@@ -0,0 +1,204 @@
+/*
+Copyright (c) 2018 The Helm Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/ghodss/yaml"
+	helmrepo "k8s.io/helm/pkg/repo"
+)
+
+// maxIndexSize is the maximum size in bytes of a repository index
+var maxIndexSize int64 = 256 << 20
+
+var errIndexTooLarge = errors.New("repo index is too large")
+
+// limitedReader fails with errIndexTooLarge once more than n bytes are read
+type limitedReader struct {
+	r io.Reader
+	n int64
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	if l.n < 0 {
+		return 0, errIndexTooLarge
+	}
+	if int64(len(p)) > l.n+1 {
+		p = p[:l.n+1]
+	}
+	n, err := l.r.Read(p)
+	l.n -= int64(n)
+	if l.n < 0 {
+		return n, errIndexTooLarge
+	}
+	return n, err
+}
+
+// spooledIndex is a repository index copied to a temporary file, which is
+// removed when it is closed
+type spooledIndex struct {
+	*os.File
+}
+
+func (f spooledIndex) Close() error {
+	err := f.File.Close()
+	os.Remove(f.Name())
+	return err
+}
+
+// spoolRepoIndex copies the index read from r to a temporary file and returns
+// it along with its checksum, so the checksum can be compared with the one of
+// the last sync before the index is parsed. Reading more than maxSize bytes
+// fails.
+func spoolRepoIndex(r io.Reader, maxSize int64) (io.ReadCloser, string, error) {
+	f, err := ioutil.TempFile("", "index")
+	if err != nil {
+		return nil, "", err
+	}
+	index := spooledIndex{f}
+	h := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(f, h), &limitedReader{r: r, n: maxSize}); err != nil {
+		index.Close()
+		return nil, "", err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		index.Close()
+		return nil, "", err
+	}
+	return index, fmt.Sprintf("%x", h.Sum(nil)), nil
+}
+
+// readRepoIndex reads the index from r and calls fn for each chart entry, with
+// its versions sorted from newest to oldest. It returns the checksum of the
+// index.
+//
+// Indexes written by Helm are not parsed at once: the block of each chart
+// under entries is parsed on its own, so only one entry is held in memory
+// besides the charts built by fn. Other indexes (e.g. in JSON) are parsed as a
+// whole. In both cases reading more than maxSize bytes fails.
+func readRepoIndex(r io.Reader, maxSize int64, fn func(entry helmrepo.ChartVersions)) (string, error) {
+	h := sha256.New()
+	br := bufio.NewReader(io.TeeReader(&limitedReader{r: r, n: maxSize}, h))
+
+	var block bytes.Buffer
+	inEntries := false
+	// indent is the indentation of the chart names under entries
+	indent := -1
+	flush := func() error {
+		if block.Len() == 0 {
+			return nil
+		}
+		var entries map[string]helmrepo.ChartVersions
+		if err := yaml.Unmarshal(block.Bytes(), &entries); err != nil {
+			return err
+		}
+		block.Reset()
+		for _, entry := range entries {
+			if len(entry) == 0 {
+				continue
+			}
+			sort.Sort(sort.Reverse(entry))
+			fn(entry)
+		}
+		return nil
+	}
+
+	first := true
+	for {
+		line, err := br.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return "", err
+		}
+		content := strings.TrimSpace(line)
+		lineIndent := len(line) - len(strings.TrimLeft(line, " "))
+
+		switch {
+		case content == "" || strings.HasPrefix(content, "#"):
+			if inEntries {
+				block.WriteString(line)
+			}
+		case first && (strings.HasPrefix(content, "{") || strings.HasPrefix(content, "---")):
+			// Not written by Helm, fall back to parsing the whole index
+			return readWholeRepoIndex(h, io.MultiReader(strings.NewReader(line), br), fn)
+		case lineIndent == 0:
+			if err := flush(); err != nil {
+				return "", err
+			}
+			inEntries = false
+			if strings.HasPrefix(content, "entries:") {
+				switch rest := strings.TrimSpace(strings.TrimPrefix(content, "entries:")); rest {
+				case "", "{}":
+					inEntries = rest == ""
+				default:
+					return "", fmt.Errorf("unsupported repo index entries: %q", rest)
+				}
+			}
+		case inEntries:
+			if indent == -1 {
+				indent = lineIndent
+			}
+			// A new chart starts at the indentation of the first one, the list of
+			// versions may be at the same indentation
+			if lineIndent <= indent && !strings.HasPrefix(content, "-") {
+				if err := flush(); err != nil {
+					return "", err
+				}
+			}
+			block.WriteString(line)
+		}
+		if content != "" && !strings.HasPrefix(content, "#") {
+			first = false
+		}
+
+		if err == io.EOF {
+			break
+		}
+	}
+	if err := flush(); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%x", h.Sum(nil)), nil
+}
+
+// readWholeRepoIndex parses the rest of the index at once
+func readWholeRepoIndex(h hash.Hash, r io.Reader, fn func(entry helmrepo.ChartVersions)) (string, error) {
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return "", err
+	}
+	index, err := parseRepoIndex(b)
+	if err != nil {
+		return "", err
+	}
+	for _, entry := range index.Entries {
+		if len(entry) > 0 {
+			fn(entry)
+		}
+	}
+	return fmt.Sprintf("%x", h.Sum(nil)), nil
+}
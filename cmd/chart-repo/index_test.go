@@ -0,0 +1,132 @@
+/*
+Copyright (c) 2018 The Helm Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/arschles/assert"
+	"github.com/ghodss/yaml"
+	helmrepo "k8s.io/helm/pkg/repo"
+)
+
+// readEntries returns the entries read from the index by name
+func readEntries(t *testing.T, index string, maxSize int64) (map[string]helmrepo.ChartVersions, string, error) {
+	entries := map[string]helmrepo.ChartVersions{}
+	checksum, err := readRepoIndex(strings.NewReader(index), maxSize, func(entry helmrepo.ChartVersions) {
+		if _, ok := entries[entry[0].Name]; ok {
+			t.Errorf("entry %s read twice", entry[0].Name)
+		}
+		entries[entry[0].Name] = entry
+	})
+	return entries, checksum, err
+}
+
+func Test_readRepoIndex(t *testing.T) {
+	index, err := parseRepoIndex([]byte(validRepoIndexYAML))
+	assert.NoErr(t, err)
+	jsonIndex, err := yaml.YAMLToJSON([]byte(validRepoIndexYAML))
+	assert.NoErr(t, err)
+
+	tests := []struct {
+		name  string
+		index string
+	}{
+		{"index written by helm", validRepoIndexYAML},
+		{"json index", string(jsonIndex)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entries, checksum, err := readEntries(t, tt.index, maxIndexSize)
+			assert.NoErr(t, err)
+			assert.Equal(t, checksum, fmt.Sprintf("%x", sha256.Sum256([]byte(tt.index))), "checksum")
+			assert.Equal(t, len(entries), len(index.Entries), "number of entries")
+			for name, entry := range index.Entries {
+				assert.Equal(t, len(entries[name]), len(entry), "number of versions of "+name)
+				for i := range entry {
+					assert.Equal(t, entries[name][i].Version, entry[i].Version, "version of "+name)
+					assert.Equal(t, entries[name][i].Digest, entry[i].Digest, "digest of "+name)
+				}
+			}
+		})
+	}
+}
+
+func Test_readRepoIndexIndentation(t *testing.T) {
+	entries, _, err := readEntries(t, `apiVersion: v1
+entries:
+    # comments are ignored
+    foo:
+        - name: foo
+          version: 1.0.0
+          description: |
+            multi-line
+            description
+        - name: foo
+          version: 1.1.0
+
+    bar:
+        - name: bar
+          version: 0.1.0
+generated: 2018-10-01T00:00:00Z
+`, maxIndexSize)
+	assert.NoErr(t, err)
+	assert.Equal(t, len(entries), 2, "number of entries")
+	assert.Equal(t, entries["foo"][0].Version, "1.1.0", "latest version first")
+	assert.Equal(t, entries["foo"][1].Description, "multi-line\ndescription\n", "description")
+	assert.Equal(t, entries["bar"][0].Version, "0.1.0", "version")
+}
+
+func Test_readRepoIndexEmpty(t *testing.T) {
+	entries, _, err := readEntries(t, "apiVersion: v1\nentries: {}\ngenerated: 2018-10-01T00:00:00Z\n", maxIndexSize)
+	assert.NoErr(t, err)
+	assert.Equal(t, len(entries), 0, "number of entries")
+}
+
+func Test_readRepoIndexTooLarge(t *testing.T) {
+	_, _, err := readEntries(t, validRepoIndexYAML, int64(len(validRepoIndexYAML)-1))
+	assert.Err(t, errIndexTooLarge, err)
+
+	_, _, err = readEntries(t, validRepoIndexYAML, int64(len(validRepoIndexYAML)))
+	assert.NoErr(t, err)
+}
+
+func Test_readRepoIndexInvalid(t *testing.T) {
+	_, _, err := readEntries(t, "apiVersion: v1\nentries:\n  foo:\n  - name: [\n", maxIndexSize)
+	assert.ExistsErr(t, err, "invalid entry")
+}
+
+func Test_spoolRepoIndex(t *testing.T) {
+	index, checksum, err := spoolRepoIndex(strings.NewReader(validRepoIndexYAML), maxIndexSize)
+	assert.NoErr(t, err)
+	assert.Equal(t, checksum, fmt.Sprintf("%x", sha256.Sum256([]byte(validRepoIndexYAML))), "checksum")
+	b, err := ioutil.ReadAll(index)
+	assert.NoErr(t, err)
+	assert.Equal(t, string(b), validRepoIndexYAML, "spooled index")
+	name := index.(spooledIndex).Name()
+	assert.NoErr(t, index.Close())
+	_, err = os.Stat(name)
+	assert.True(t, os.IsNotExist(err), "the file is removed once closed")
+
+	_, _, err = spoolRepoIndex(strings.NewReader(validRepoIndexYAML), int64(len(validRepoIndexYAML)-1))
+	assert.Err(t, errIndexTooLarge, err)
+}
@@ -0,0 +1,147 @@
+/*
+Copyright (c) 2018 The Helm Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+var (
+	// iconWorkers and filesWorkers are the number of icons and chart files
+	// imported concurrently by a sync
+	iconWorkers  = 10
+	filesWorkers = 10
+	// maxHostConcurrency caps the requests in flight to a single host, 0 means
+	// no limit
+	maxHostConcurrency = 0
+	// maxRequestsPerSecond caps the rate of outbound requests, 0 means no limit
+	maxRequestsPerSecond float64
+)
+
+// validateWorkerSettings checks the number of workers given with the flags, a
+// sync would otherwise wait forever for the workers to read its jobs
+func validateWorkerSettings() error {
+	if iconWorkers < 1 {
+		return fmt.Errorf("invalid number of icon workers %d", iconWorkers)
+	}
+	if filesWorkers < 1 {
+		return fmt.Errorf("invalid number of files workers %d", filesWorkers)
+	}
+	return nil
+}
+
+// requestLimits caps the number of requests in flight to each host and the
+// rate of requests, it is shared by the clients of all the repositories
+type requestLimits struct {
+	// perHost is the maximum number of requests in flight to a host
+	perHost int
+	// interval is the minimum time between two requests
+	interval time.Duration
+
+	mu    sync.Mutex
+	hosts map[string]chan struct{}
+	next  time.Time
+}
+
+// limits are the limits applied to all outbound requests, set up once the
+// flags have been parsed
+var limits = newRequestLimits(0, 0)
+
+func newRequestLimits(perHost int, requestsPerSecond float64) *requestLimits {
+	l := &requestLimits{perHost: perHost, hosts: map[string]chan struct{}{}}
+	if requestsPerSecond > 0 {
+		l.interval = time.Duration(float64(time.Second) / requestsPerSecond)
+	}
+	return l
+}
+
+// limitedClient sends requests within the limits. A request holds its host
+// slot until the response body is closed.
+type limitedClient struct {
+	client httpClient
+	limits *requestLimits
+}
+
+func (c *limitedClient) Do(req *http.Request) (*http.Response, error) {
+	release := c.limits.acquire(req.URL.Host)
+	c.limits.wait()
+	res, err := c.client.Do(req)
+	if err != nil || res == nil || res.Body == nil {
+		release()
+		return res, err
+	}
+	res.Body = &releaseBody{ReadCloser: res.Body, release: release}
+	return res, err
+}
+
+// acquire takes a slot for the host, waiting for one to be free, and returns
+// the function that frees it
+func (l *requestLimits) acquire(host string) func() {
+	if l.perHost <= 0 {
+		return func() {}
+	}
+	l.mu.Lock()
+	slots, ok := l.hosts[host]
+	if !ok {
+		slots = make(chan struct{}, l.perHost)
+		l.hosts[host] = slots
+	}
+	l.mu.Unlock()
+	slots <- struct{}{}
+	var once sync.Once
+	return func() {
+		once.Do(func() { <-slots })
+	}
+}
+
+// wait blocks until the next request can be sent without exceeding the rate
+func (l *requestLimits) wait() {
+	if l.interval <= 0 {
+		return
+	}
+	l.mu.Lock()
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	delay := l.next.Sub(now)
+	l.next = l.next.Add(l.interval)
+	l.mu.Unlock()
+	time.Sleep(delay)
+}
+
+// outboundClient wraps a client so its requests are retried and sent within
+// the limits
+func outboundClient(client httpClient) httpClient {
+	return &retryClient{client: &limitedClient{client: client, limits: limits}}
+}
+
+// releaseBody frees the host slot of a request once its response is closed
+type releaseBody struct {
+	io.ReadCloser
+	release func()
+}
+
+func (b *releaseBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.release()
+	return err
+}
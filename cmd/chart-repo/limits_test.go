@@ -0,0 +1,154 @@
+/*
+Copyright (c) 2018 The Helm Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/arschles/assert"
+	"github.com/kubeapps/common/datastore/mockstore"
+	"github.com/stretchr/testify/mock"
+)
+
+// countingClient records the maximum number of requests in flight per host,
+// a request being in flight until its response body is closed
+type countingClient struct {
+	sync.Mutex
+	inFlight map[string]int
+	max      map[string]int
+}
+
+type countingBody struct {
+	client *countingClient
+	host   string
+}
+
+func (b *countingBody) Read(p []byte) (int, error) { return 0, nil }
+
+func (b *countingBody) Close() error {
+	b.client.Lock()
+	defer b.client.Unlock()
+	b.client.inFlight[b.host]--
+	return nil
+}
+
+func (h *countingClient) Do(req *http.Request) (*http.Response, error) {
+	h.Lock()
+	defer h.Unlock()
+	host := req.URL.Host
+	h.inFlight[host]++
+	if h.inFlight[host] > h.max[host] {
+		h.max[host] = h.inFlight[host]
+	}
+	res := httptest.NewRecorder().Result()
+	res.Body = &countingBody{client: h, host: host}
+	return res, nil
+}
+
+func Test_validateWorkerSettings(t *testing.T) {
+	defer func(icons, files int) { iconWorkers, filesWorkers = icons, files }(iconWorkers, filesWorkers)
+	tests := []struct {
+		name    string
+		icons   int
+		files   int
+		wantErr bool
+	}{
+		{"default settings", 10, 10, false},
+		{"single workers", 1, 1, false},
+		{"no icon workers", 0, 10, true},
+		{"no files workers", 10, 0, true},
+		{"negative workers", -1, 10, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			iconWorkers, filesWorkers = tt.icons, tt.files
+			err := validateWorkerSettings()
+			assert.Equal(t, err != nil, tt.wantErr, "error")
+		})
+	}
+}
+
+func Test_limitedClientPerHost(t *testing.T) {
+	counter := &countingClient{inFlight: map[string]int{}, max: map[string]int{}}
+	client := &limitedClient{client: counter, limits: newRequestLimits(2, 0)}
+
+	var wg sync.WaitGroup
+	for _, host := range []string{"a.example.com", "b.example.com"} {
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func(host string) {
+				defer wg.Done()
+				req, _ := http.NewRequest("GET", "https://"+host+"/index.yaml", nil)
+				res, err := client.Do(req)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				time.Sleep(time.Millisecond)
+				res.Body.Close()
+			}(host)
+		}
+	}
+	wg.Wait()
+	assert.Equal(t, counter.max["a.example.com"], 2, "requests in flight to a.example.com")
+	assert.Equal(t, counter.max["b.example.com"], 2, "requests in flight to b.example.com")
+}
+
+func Test_limitedClientRate(t *testing.T) {
+	counter := &countingClient{inFlight: map[string]int{}, max: map[string]int{}}
+	client := &limitedClient{client: counter, limits: newRequestLimits(0, 100)}
+
+	start := time.Now()
+	for i := 0; i < 5; i++ {
+		req, _ := http.NewRequest("GET", "https://a.example.com/index.yaml", nil)
+		res, err := client.Do(req)
+		assert.NoErr(t, err)
+		res.Body.Close()
+	}
+	// The first request is sent right away, the others 10ms apart
+	if elapsed := time.Since(start); elapsed < 40*time.Millisecond {
+		t.Errorf("expected the requests to take at least 40ms, took %v", elapsed)
+	}
+}
+
+func Test_fetchAndImportFilesHostSlot(t *testing.T) {
+	charts := indexCharts(t, validRepoIndexYAML, repo{Name: "test", URL: "http://testrepo.com"}, new(filters))
+	c := charts[0]
+	cv := c.ChartVersions[0]
+
+	// The provenance file is requested from the host of the tarball, with a
+	// single slot per host
+	netClient = &limitedClient{client: &goodTarballClient{c: c, signed: true}, limits: newRequestLimits(1, 0)}
+	m := mock.Mock{}
+	m.On("One", mock.Anything).Return(errors.New("not imported"))
+	m.On("UpsertId", mock.Anything, mock.Anything)
+	dbSession := mockstore.NewMockSession(&m)
+
+	done := make(chan error, 1)
+	go func() { done <- fetchAndImportFiles(dbSession, c.Name, c.Repo, cv) }()
+	select {
+	case err := <-done:
+		assert.NoErr(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("importing the files of a signed chart version did not free the slot of the host")
+	}
+}
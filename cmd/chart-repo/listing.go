@@ -0,0 +1,96 @@
+/*
+Copyright (c) 2018 The Helm Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"path"
+	"strings"
+	"unicode/utf8"
+)
+
+var (
+	// maxStoredFileSize is the size of the largest text file stored with the
+	// file listing of a chart version, 0 to only list the files
+	maxStoredFileSize int64 = 64 * 1024
+	// maxStoredFilesSize is the total size of the text files stored in the
+	// document of a chart version without a blob store, documents are limited to
+	// 16MB
+	maxStoredFilesSize int64 = 1024 * 1024
+	// storeFileContents stores the text files in the documents of the chart
+	// versions when no blob store is configured
+	storeFileContents = false
+)
+
+// storesFile returns true if the content of the file could be stored with the
+// listing, depending on its size and on where it would be stored
+func storesFile(header *tar.Header) bool {
+	return (blobs != nil || storeFileContents) && maxStoredFileSize > 0 && header.Size <= maxStoredFileSize
+}
+
+// listChartFile returns the entry of the listing for the file of the tarball,
+// with its content if it is small text file
+func listChartFile(header *tar.Header, content []byte) chartFile {
+	f := chartFile{Path: header.Name, Size: header.Size, Mode: int64(header.FileInfo().Mode().Perm())}
+	if storesFile(header) && isText(content) {
+		f.Content = string(content)
+	}
+	return f
+}
+
+// isText returns true if the content is UTF-8 text, binary files usually have
+// NUL bytes or invalid UTF-8 sequences
+func isText(content []byte) bool {
+	return utf8.Valid(content) && bytes.IndexByte(content, 0) < 0
+}
+
+// chartFileListing returns the files of the tarball in the directory of the
+// chart with their path relative to it, other files are not part of the chart
+func chartFileListing(chartName string, listing []chartFile) []chartFile {
+	var files []chartFile
+	for _, f := range listing {
+		p := path.Clean("/" + f.Path)
+		if !strings.HasPrefix(p, "/"+chartName+"/") {
+			continue
+		}
+		f.Path = strings.TrimPrefix(p, "/"+chartName+"/")
+		files = append(files, f)
+	}
+	return files
+}
+
+// budgetFileContents drops the contents of the files which would take the
+// document of the chart version beyond maxStoredFilesSize, unless they go to
+// the blob store
+func budgetFileContents(files []chartFile) []chartFile {
+	if blobs != nil {
+		return files
+	}
+	var total int64
+	for i, f := range files {
+		if f.Content == "" {
+			continue
+		}
+		if total+int64(len(f.Content)) > maxStoredFilesSize {
+			files[i].Content = ""
+			continue
+		}
+		total += int64(len(f.Content))
+	}
+	return files
+}
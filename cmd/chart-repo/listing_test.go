@@ -0,0 +1,98 @@
+/*
+Copyright (c) 2018 The Helm Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/arschles/assert"
+)
+
+// testChartFileListing returns the listing of the files of a test tarball,
+// their contents are not stored without a blob store
+func testChartFileListing(chartName string, files []tarballFile) []chartFile {
+	var listing []chartFile
+	for _, f := range files {
+		listing = append(listing, chartFile{Path: strings.TrimPrefix(f.Name, chartName+"/"), Size: int64(len(f.Body)), Mode: 0600})
+	}
+	return listing
+}
+
+func Test_extractFileListing(t *testing.T) {
+	var b bytes.Buffer
+	createTestTarball(&b, []tarballFile{
+		{"my-chart/Chart.yaml", "name: my-chart"},
+		{"my-chart/templates/deployment.yaml", "kind: Deployment"},
+		{"my-chart/files/logo.png", "\x89PNG\x00\x00"},
+		{"my-chart/files/large.txt", strings.Repeat("a", 16)},
+		{"my-chart/../escape.txt", "escaped"},
+		{"other/file.txt", "not in the chart"},
+	})
+	defer func(size int64) { maxStoredFileSize = size }(maxStoredFileSize)
+	maxStoredFileSize = 15
+	storeFileContents = true
+	defer func() { storeFileContents = false }()
+
+	_, listing, err := extractFilesFromTarball(nil, tar.NewReader(&b))
+	assert.NoErr(t, err)
+	assert.Equal(t, chartFileListing("my-chart", listing), []chartFile{
+		{Path: "Chart.yaml", Size: 14, Mode: 0600, Content: "name: my-chart"},
+		{Path: "templates/deployment.yaml", Size: 16, Mode: 0600},
+		{Path: "files/logo.png", Size: 6, Mode: 0600},
+		{Path: "files/large.txt", Size: 16, Mode: 0600},
+	}, "listing")
+}
+
+func Test_extractFileListingWithoutStore(t *testing.T) {
+	var b bytes.Buffer
+	createTestTarball(&b, []tarballFile{{"my-chart/Chart.yaml", "name: my-chart"}})
+
+	_, listing, err := extractFilesFromTarball(nil, tar.NewReader(&b))
+	assert.NoErr(t, err)
+	assert.Equal(t, chartFileListing("my-chart", listing), []chartFile{{Path: "Chart.yaml", Size: 14, Mode: 0600}}, "listing")
+}
+
+func Test_budgetFileContents(t *testing.T) {
+	defer func(size int64) { maxStoredFilesSize = size }(maxStoredFilesSize)
+	maxStoredFilesSize = 10
+	files := []chartFile{
+		{Path: "a.txt", Size: 6, Content: "aaaaaa"},
+		{Path: "b.txt", Size: 6, Content: "bbbbbb"},
+		{Path: "c.txt", Size: 4, Content: "cccc"},
+	}
+	assert.Equal(t, budgetFileContents(files), []chartFile{
+		{Path: "a.txt", Size: 6, Content: "aaaaaa"},
+		{Path: "b.txt", Size: 6},
+		{Path: "c.txt", Size: 4, Content: "cccc"},
+	}, "files within the budget")
+
+	defer withBlobStore(t)()
+	files = []chartFile{{Path: "a.txt", Size: 6, Content: "aaaaaa"}, {Path: "b.txt", Size: 6, Content: "bbbbbb"}}
+	assert.Equal(t, budgetFileContents(files), []chartFile{{Path: "a.txt", Size: 6, Content: "aaaaaa"}, {Path: "b.txt", Size: 6, Content: "bbbbbb"}}, "files going to the blob store")
+}
+
+func Test_storeFileBlobsListing(t *testing.T) {
+	defer withBlobStore(t)()
+	files := chartFiles{Files: []chartFile{{Path: "Chart.yaml", Size: 14, Content: "name: my-chart"}, {Path: "logo.png", Size: 6}}}
+	assert.NoErr(t, storeFileBlobs(&files, []byte("tarball")))
+	assert.Equal(t, files.Files[0].Content, "", "content")
+	assert.Equal(t, blobContent(t, files.Files[0].Key), "name: my-chart", "stored content")
+	assert.Equal(t, files.Files[1].Key, "", "file without content")
+}
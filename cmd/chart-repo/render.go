@@ -0,0 +1,115 @@
+/*
+Copyright (c) 2018 The Helm Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	// renderTimeout bounds the time spent rendering the templates of a chart
+	// version, templates are untrusted and could take arbitrarily long
+	renderTimeout = 10 * time.Second
+	// renderMemoryLimit caps the memory of the process rendering the templates
+	// of a chart version, 0 for no limit
+	renderMemoryLimit int64 = 512 << 20
+)
+
+// renderCommand returns the command rendering the templates of a chart
+// version, chart-repo itself
+var renderCommand = func() []string {
+	exe, err := os.Executable()
+	if err != nil {
+		exe = os.Args[0]
+	}
+	return []string{exe, "render-images"}
+}
+
+// renderImagesCmd renders the templates of the chart tarball read from stdin
+// and writes its images to stdout. The templates are rendered in their own
+// process so that it can be killed when it exceeds the limits.
+var renderImagesCmd = &cobra.Command{
+	Use:    "render-images",
+	Short:  "render the templates of a chart read from stdin and list its images",
+	Hidden: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runRenderImages(os.Stdin, os.Stdout, renderMemoryLimit)
+	},
+}
+
+// renderResult is written by the render-images command
+type renderResult struct {
+	Images []containerImage `json:"images"`
+	Error  string           `json:"error,omitempty"`
+}
+
+// runRenderImages renders the templates of the tarball read from in within the
+// memory limit, and writes the result to out
+func runRenderImages(in io.Reader, out io.Writer, memoryLimit int64) error {
+	if err := limitMemory(memoryLimit); err != nil {
+		return err
+	}
+	tarball, err := ioutil.ReadAll(in)
+	if err != nil {
+		return err
+	}
+	var r renderResult
+	if r.Images, err = renderImages(tarball); err != nil {
+		r.Error = err.Error()
+	}
+	return json.NewEncoder(out).Encode(r)
+}
+
+// chartImages returns the images of the tarball of a chart version, rendering
+// its templates with the render-images command. The command is killed after
+// renderTimeout.
+func chartImages(tarball []byte) ([]containerImage, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), renderTimeout)
+	defer cancel()
+	args := append(renderCommand(), fmt.Sprintf("--memory-limit=%d", renderMemoryLimit))
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Stdin = bytes.NewReader(tarball)
+	var stdout bytes.Buffer
+	cmd.Stdout = &stdout
+	err := cmd.Run()
+	if ctx.Err() == context.DeadlineExceeded {
+		return nil, fmt.Errorf("rendering timed out after %s", renderTimeout)
+	}
+	if err != nil {
+		// the process exceeded its memory limit or crashed
+		return nil, fmt.Errorf("rendering failed: %v", err)
+	}
+	var r renderResult
+	if err := json.Unmarshal(stdout.Bytes(), &r); err != nil {
+		return nil, fmt.Errorf("rendering failed: %v", err)
+	}
+	if r.Error != "" {
+		return nil, errors.New(r.Error)
+	}
+	return r.Images, nil
+}
@@ -0,0 +1,43 @@
+/*
+Copyright (c) 2018 The Helm Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"syscall"
+)
+
+// limitMemory caps the address space the process can map on top of what it
+// already has, allocations beyond it make the process exit. The Go runtime
+// reserves address space up front, so the limit is relative to it.
+func limitMemory(limit int64) error {
+	if limit <= 0 {
+		return nil
+	}
+	statm, err := ioutil.ReadFile("/proc/self/statm")
+	if err != nil {
+		return err
+	}
+	var pages int64
+	if _, err := fmt.Sscan(string(statm), &pages); err != nil {
+		return err
+	}
+	max := uint64(pages*int64(os.Getpagesize()) + limit)
+	return syscall.Setrlimit(syscall.RLIMIT_AS, &syscall.Rlimit{Cur: max, Max: max})
+}
@@ -0,0 +1,77 @@
+/*
+Copyright (c) 2018 The Helm Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/arschles/assert"
+)
+
+// The templates are rendered by the test binary itself, running
+// Test_renderImagesProcess
+func init() {
+	renderCommand = func() []string {
+		return []string{os.Args[0], "-test.run=^Test_renderImagesProcess$", "--", "render-images"}
+	}
+}
+
+// Test_renderImagesProcess runs the render-images command when the test binary
+// is run by chartImages
+func Test_renderImagesProcess(t *testing.T) {
+	args := os.Args
+	for len(args) > 0 && args[0] != "--" {
+		args = args[1:]
+	}
+	if len(args) < 2 || args[1] != "render-images" {
+		return
+	}
+	flags := flag.NewFlagSet("render-images", flag.ExitOnError)
+	memoryLimit := flags.Int64("memory-limit", 0, "")
+	flags.Parse(args[2:])
+	if err := runRenderImages(os.Stdin, os.Stdout, *memoryLimit); err != nil {
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
+
+func Test_chartImagesLimits(t *testing.T) {
+	t.Run("timeout", func(t *testing.T) {
+		defer func(d time.Duration) { renderTimeout = d }(renderTimeout)
+		renderTimeout = 200 * time.Millisecond
+		_, err := chartImages(testTarball([]tarballFile{
+			{"my-chart/Chart.yaml", "name: my-chart\nversion: 1.0.0"},
+			{"my-chart/templates/loop.yaml", "{{ range until 100000 }}{{ range until 100000 }}{{ end }}{{ end }}"},
+		}))
+		assert.Err(t, errors.New("rendering timed out after 200ms"), err)
+	})
+
+	t.Run("memory limit", func(t *testing.T) {
+		defer func(limit int64) { renderMemoryLimit = limit }(renderMemoryLimit)
+		renderMemoryLimit = 256 << 20
+		_, err := chartImages(testTarball([]tarballFile{
+			{"my-chart/Chart.yaml", "name: my-chart\nversion: 1.0.0"},
+			{"my-chart/templates/alloc.yaml", "{{ $x := until 100000000 }}"},
+		}))
+		assert.True(t, err != nil && strings.HasPrefix(err.Error(), "rendering failed"), "rendering should fail")
+	})
+}
@@ -0,0 +1,223 @@
+/*
+Copyright (c) 2018 The Helm Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+
+	"github.com/ghodss/yaml"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+// reposFile is the file given with --repos-file, which configures how each
+// repository is accessed, e.g.
+//
+//	repositories:
+//	- name: private
+//	  url: https://charts.example.com
+//	  auth:
+//	    tokenFile: /var/run/secrets/charts/token
+//	  tls:
+//	    caFile: /var/run/secrets/charts/ca.crt
+type reposFile struct {
+	Repositories []repoConfig `json:"repositories"`
+}
+
+type repoConfig struct {
+	Name string         `json:"name"`
+	URL  string         `json:"url"`
+	Auth repoAuthConfig `json:"auth"`
+	TLS  repoTLSConfig  `json:"tls"`
+	// Proxy is the URL of the proxy used for the repository, instead of the
+	// one of the environment. NoProxy disables the proxy for the repository.
+	Proxy   string `json:"proxy"`
+	NoProxy bool   `json:"noProxy"`
+}
+
+// repoTLSConfig holds the TLS settings of a repository, the client certificate
+// is part of its auth
+type repoTLSConfig struct {
+	// CAFile is a bundle of CAs trusted for the repository in addition to the
+	// system ones
+	CAFile string `json:"caFile"`
+	// InsecureSkipVerify disables the verification of the certificate of the
+	// repository, e.g. for lab repositories
+	InsecureSkipVerify bool `json:"insecureSkipVerify"`
+}
+
+// repoAuthConfig holds the credentials of a repository, at most one of basic
+// auth, token file and Docker config can be set. The client certificate can
+// be combined with any of them.
+type repoAuthConfig struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+	// TokenFile contains a bearer token, read again when it changes
+	TokenFile string `json:"tokenFile"`
+	// DockerConfigFile is a Docker config.json whose "auths" hold the
+	// credentials of each host
+	DockerConfigFile string `json:"dockerConfigFile"`
+	// CertFile and KeyFile are the client certificate presented to the
+	// repository
+	CertFile string `json:"certFile"`
+	KeyFile  string `json:"keyFile"`
+	// Hosts overrides --auth-host for the repository
+	Hosts []string `json:"hosts"`
+}
+
+// loadReposFile returns the configuration of the repositories in the file, by
+// name
+func loadReposFile(path string) (map[string]repoConfig, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var f reposFile
+	if err := yaml.Unmarshal(b, &f); err != nil {
+		return nil, fmt.Errorf("invalid repos file %s: %v", path, err)
+	}
+	configs := map[string]repoConfig{}
+	for _, c := range f.Repositories {
+		if c.Name == "" {
+			return nil, fmt.Errorf("invalid repos file %s: repository without a name", path)
+		}
+		if _, ok := configs[c.Name]; ok {
+			return nil, fmt.Errorf("invalid repos file %s: duplicate repository %q", path, c.Name)
+		}
+		configs[c.Name] = c
+	}
+	return configs, nil
+}
+
+// repo returns the repository with its credentials and client
+func (c repoConfig) repo() (repo, error) {
+	r := repo{Name: c.Name, URL: c.URL, AuthHosts: authHosts}
+	if c.Auth.Hosts != nil {
+		r.AuthHosts = c.Auth.Hosts
+	}
+	creds, err := c.Auth.credentials()
+	if err != nil {
+		return repo{}, fmt.Errorf("repository %q: %v", c.Name, err)
+	}
+	r.Credentials = creds
+
+	// The repository only needs its own client if it has specific TLS or
+	// proxy settings
+	if c.Auth.CertFile != "" || c.Auth.KeyFile != "" || c.TLS != (repoTLSConfig{}) || c.Proxy != "" || c.NoProxy {
+		if r.Client, err = c.client(); err != nil {
+			return repo{}, fmt.Errorf("repository %q: %v", c.Name, err)
+		}
+	}
+	return r, nil
+}
+
+// credentials returns the provider of the configured credentials, or nil if
+// there are none
+func (a repoAuthConfig) credentials() (credentialProvider, error) {
+	var providers []credentialProvider
+	if a.Username != "" {
+		providers = append(providers, &basicAuthCredentials{username: a.Username, password: a.Password})
+	}
+	if a.TokenFile != "" {
+		providers = append(providers, newTokenFileCredentials(a.TokenFile))
+	}
+	if a.DockerConfigFile != "" {
+		providers = append(providers, newDockerConfigCredentials(a.DockerConfigFile))
+	}
+	switch len(providers) {
+	case 0:
+		return nil, nil
+	case 1:
+		return providers[0], nil
+	default:
+		return nil, errors.New("only one of username, tokenFile and dockerConfigFile can be set")
+	}
+}
+
+// client returns a client with the TLS and proxy settings of the repository
+func (c repoConfig) client() (httpClient, error) {
+	client, err := initNetClient(additionalCAFile)
+	if err != nil {
+		return nil, err
+	}
+	transport := client.Transport.(*http.Transport)
+
+	if c.Auth.CertFile != "" || c.Auth.KeyFile != "" {
+		if c.Auth.CertFile == "" || c.Auth.KeyFile == "" {
+			return nil, errors.New("certFile and keyFile must be set together")
+		}
+		cert, err := tls.LoadX509KeyPair(c.Auth.CertFile, c.Auth.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		transport.TLSClientConfig.Certificates = []tls.Certificate{cert}
+	}
+	if c.TLS.CAFile != "" {
+		certs, err := ioutil.ReadFile(c.TLS.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		if ok := transport.TLSClientConfig.RootCAs.AppendCertsFromPEM(certs); !ok {
+			return nil, fmt.Errorf("no certificates in %s", c.TLS.CAFile)
+		}
+	}
+	if c.TLS.InsecureSkipVerify {
+		log.WithFields(log.Fields{"repo": c.Name}).Warn("The certificate of the repository is not verified")
+		transport.TLSClientConfig.InsecureSkipVerify = true
+	}
+
+	switch {
+	case c.NoProxy && c.Proxy != "":
+		return nil, errors.New("only one of proxy and noProxy can be set")
+	case c.NoProxy:
+		transport.Proxy = nil
+	case c.Proxy != "":
+		proxyURL, err := url.Parse(c.Proxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy: %v", err)
+		}
+		transport.Proxy = http.ProxyURL(proxyURL)
+	}
+	return outboundClient(client), nil
+}
+
+// repoConfigs loads the repos file given to the command, if any
+func repoConfigs(cmd *cobra.Command) (map[string]repoConfig, error) {
+	path, err := cmd.Flags().GetString("repos-file")
+	if err != nil || path == "" {
+		return map[string]repoConfig{}, err
+	}
+	return loadReposFile(path)
+}
+
+// configuredRepo returns the repository with the credentials of its entry in
+// the repos file if any, or else the given Authorization header
+func configuredRepo(configs map[string]repoConfig, name, url, authorizationHeader string) (repo, error) {
+	c, ok := configs[name]
+	if !ok {
+		return repo{Name: name, URL: url, Credentials: headerCredentials(authorizationHeader), AuthHosts: authHosts}, nil
+	}
+	if url != "" {
+		c.URL = url
+	}
+	return c.repo()
+}
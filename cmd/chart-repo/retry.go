@@ -0,0 +1,228 @@
+/*
+Copyright (c) 2018 The Helm Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/globalsign/mgo/bson"
+	"github.com/kubeapps/common/datastore"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	failedItemsCollection = "failed_items"
+	// Kinds of the items that are retried by the next sync
+	iconItem  = "icon"
+	filesItem = "files"
+)
+
+var (
+	// maxRetries is the number of times a failed request is retried
+	maxRetries = 3
+	// retryDelay is the delay before the first retry of a request, it doubles
+	// with each attempt
+	retryDelay = time.Second
+	// maxRetryDelay caps the delay between attempts, including the one asked
+	// by the server through Retry-After
+	maxRetryDelay = 30 * time.Second
+	// maxItemAttempts is the number of syncs that attempt to import a failed
+	// item, the ones failing every time are kept but no longer retried
+	maxItemAttempts = 5
+)
+
+// retryClient retries the requests that fail with a network error, a 5xx or
+// a 429 response. Requests with a body are sent once since it can't be read
+// again.
+type retryClient struct {
+	client httpClient
+}
+
+func (c *retryClient) Do(req *http.Request) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		res, err := c.client.Do(req)
+		if attempt >= maxRetries || req.Body != nil || !retryable(res, err) {
+			return res, err
+		}
+		delay := retryBackoff(attempt, res, time.Now())
+		if res != nil {
+			io.Copy(ioutil.Discard, io.LimitReader(res.Body, 4096))
+			res.Body.Close()
+		}
+		log.WithFields(log.Fields{"url": req.URL.String(), "attempt": attempt + 1, "delay": delay}).Debug("retrying request")
+		time.Sleep(delay)
+	}
+}
+
+// retryable returns true if the request may succeed if sent again
+func retryable(res *http.Response, err error) bool {
+	if err != nil {
+		return true
+	}
+	switch {
+	case res.StatusCode == http.StatusTooManyRequests:
+		return true
+	case res.StatusCode == http.StatusNotImplemented:
+		return false
+	default:
+		return res.StatusCode >= 500
+	}
+}
+
+// retryBackoff returns the delay before the next attempt, the exponential
+// backoff unless the server asked to wait longer
+func retryBackoff(attempt int, res *http.Response, now time.Time) time.Duration {
+	delay := retryDelay << uint(attempt)
+	if res != nil {
+		if after, ok := parseRetryAfter(res.Header.Get("Retry-After"), now); ok && after > delay {
+			delay = after
+		}
+	}
+	if delay > maxRetryDelay || delay < 0 {
+		delay = maxRetryDelay
+	}
+	return delay
+}
+
+// parseRetryAfter parses a Retry-After header, given either in seconds or as
+// an HTTP date
+func parseRetryAfter(value string, now time.Time) (time.Duration, bool) {
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0, false
+		}
+		return time.Duration(seconds) * time.Second, true
+	}
+	t, err := http.ParseTime(value)
+	if err != nil {
+		return 0, false
+	}
+	if t.Before(now) {
+		return 0, true
+	}
+	return t.Sub(now), true
+}
+
+func iconItemID(c chart) string {
+	return iconItem + ":" + c.ID
+}
+
+func filesItemID(chartID, version string) string {
+	return filesItem + ":" + chartID + "-" + version
+}
+
+// loadFailedItems returns the items of the repository that failed to be
+// imported by a previous sync, by ID
+func loadFailedItems(dbSession datastore.Session, repoName string) (map[string]failedItem, error) {
+	db, closer := dbSession.DB()
+	defer closer()
+	var items []failedItem
+	if err := db.C(failedItemsCollection).Find(bson.M{"repo": repoName}).All(&items); err != nil {
+		return nil, err
+	}
+	failed := map[string]failedItem{}
+	for _, item := range items {
+		failed[item.ID] = item
+	}
+	return failed, nil
+}
+
+// retried returns true if the failed item is attempted again by the next sync
+func (item failedItem) retried() bool {
+	return item.Attempts < maxItemAttempts
+}
+
+// hasRetriedItems returns true if any of the failed items is attempted again,
+// the index is then needed even if it has not been modified
+func hasRetriedItems(failed map[string]failedItem) bool {
+	for _, item := range failed {
+		if item.retried() {
+			return true
+		}
+	}
+	return false
+}
+
+// failedJobs returns the jobs that retry the failed items, and the IDs of the
+// items whose chart or version is no longer in the index
+func failedJobs(charts []chart, failed map[string]failedItem) ([]chart, []importChartFilesJob, []string) {
+	byID := map[string]chart{}
+	for _, c := range charts {
+		byID[c.ID] = c
+	}
+	var icons []chart
+	var files []importChartFilesJob
+	var stale []string
+	for id, item := range failed {
+		c, ok := byID[item.Chart]
+		if !ok {
+			stale = append(stale, id)
+			continue
+		}
+		switch {
+		case !item.retried():
+			continue
+		case item.Kind == iconItem:
+			icons = append(icons, c)
+			continue
+		case item.Kind == filesItem:
+			found := false
+			for _, cv := range c.ChartVersions {
+				if cv.Version == item.Version {
+					files = append(files, importChartFilesJob{c.Name, c.Repo, cv})
+					found = true
+					break
+				}
+			}
+			if found {
+				continue
+			}
+		}
+		stale = append(stale, id)
+	}
+	return icons, files, stale
+}
+
+// recordFailedItem stores an item that failed to be imported, counting the
+// attempts of the previous syncs
+func recordFailedItem(dbSession datastore.Session, item failedItem, failed map[string]failedItem) {
+	item.Attempts = failed[item.ID].Attempts + 1
+	db, closer := dbSession.DB()
+	defer closer()
+	if _, err := db.C(failedItemsCollection).UpsertId(item.ID, item); err != nil {
+		log.WithFields(log.Fields{"id": item.ID}).WithError(err).Error("failed to record failed item")
+	}
+}
+
+// clearFailedItems removes items that have been imported or are no longer in
+// the index
+func clearFailedItems(dbSession datastore.Session, ids []string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	db, closer := dbSession.DB()
+	defer closer()
+	_, err := db.C(failedItemsCollection).RemoveAll(bson.M{"_id": bson.M{"$in": ids}})
+	return err
+}
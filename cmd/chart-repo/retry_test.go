@@ -0,0 +1,181 @@
+/*
+Copyright (c) 2018 The Helm Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/arschles/assert"
+	"github.com/globalsign/mgo/bson"
+	"github.com/kubeapps/common/datastore/mockstore"
+	"github.com/stretchr/testify/mock"
+)
+
+// flakyClient replies with the given status codes in order, a status code of
+// 0 being a network error
+type flakyClient struct {
+	statusCodes []int
+	retryAfter  string
+	attempts    int
+}
+
+func (h *flakyClient) Do(req *http.Request) (*http.Response, error) {
+	code := h.statusCodes[h.attempts]
+	h.attempts++
+	if code == 0 {
+		return nil, errors.New("connection reset by peer")
+	}
+	w := httptest.NewRecorder()
+	if h.retryAfter != "" {
+		w.Header().Set("Retry-After", h.retryAfter)
+	}
+	w.WriteHeader(code)
+	return w.Result(), nil
+}
+
+func Test_retryClient(t *testing.T) {
+	defer func(d time.Duration) { retryDelay = d }(retryDelay)
+	retryDelay = time.Millisecond
+
+	tests := []struct {
+		name        string
+		statusCodes []int
+		body        bool
+		attempts    int
+		statusCode  int
+	}{
+		{"success", []int{200}, false, 1, 200},
+		{"server error", []int{503, 502, 200}, false, 3, 200},
+		{"network error", []int{0, 200}, false, 2, 200},
+		{"rate limited", []int{429, 200}, false, 2, 200},
+		{"client error", []int{404, 200}, false, 1, 404},
+		{"not implemented", []int{501, 200}, false, 1, 501},
+		{"too many failures", []int{500, 500, 500, 500, 200}, false, 4, 500},
+		{"request with a body", []int{500, 200}, true, 1, 500},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := &flakyClient{statusCodes: tt.statusCodes}
+			req, _ := http.NewRequest("GET", "https://my.examplerepo.com/index.yaml", nil)
+			if tt.body {
+				req, _ = http.NewRequest("POST", "https://my.examplerepo.com/hook", strings.NewReader("{}"))
+			}
+			res, err := (&retryClient{client: client}).Do(req)
+			assert.NoErr(t, err)
+			assert.Equal(t, res.StatusCode, tt.statusCode, "status code")
+			assert.Equal(t, client.attempts, tt.attempts, "attempts")
+		})
+	}
+
+	t.Run("network error after retries", func(t *testing.T) {
+		client := &flakyClient{statusCodes: []int{0, 0, 0, 0}}
+		req, _ := http.NewRequest("GET", "https://my.examplerepo.com/index.yaml", nil)
+		_, err := (&retryClient{client: client}).Do(req)
+		assert.ExistsErr(t, err, "network error")
+		assert.Equal(t, client.attempts, 4, "attempts")
+	})
+}
+
+func Test_retryBackoff(t *testing.T) {
+	now := time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
+	withRetryAfter := func(v string) *http.Response {
+		return &http.Response{Header: http.Header{"Retry-After": []string{v}}}
+	}
+	tests := []struct {
+		name    string
+		attempt int
+		res     *http.Response
+		delay   time.Duration
+	}{
+		{"first retry", 0, nil, time.Second},
+		{"third retry", 2, nil, 4 * time.Second},
+		{"capped", 10, nil, 30 * time.Second},
+		{"retry after seconds", 0, withRetryAfter("5"), 5 * time.Second},
+		{"retry after shorter than backoff", 2, withRetryAfter("1"), 4 * time.Second},
+		{"retry after date", 0, withRetryAfter(now.Add(10 * time.Second).Format(http.TimeFormat)), 10 * time.Second},
+		{"retry after capped", 0, withRetryAfter("3600"), 30 * time.Second},
+		{"invalid retry after", 0, withRetryAfter("soon"), time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, retryBackoff(tt.attempt, tt.res, now), tt.delay, "delay")
+		})
+	}
+}
+
+func Test_failedJobs(t *testing.T) {
+	r := repo{Name: "test"}
+	charts := []chart{
+		{ID: "test/foo", Name: "foo", Repo: r, ChartVersions: []chartVersion{{Version: "2.0.0"}, {Version: "1.0.0"}}},
+	}
+	failed := map[string]failedItem{
+		"icon:test/foo":        {ID: "icon:test/foo", Kind: iconItem, Chart: "test/foo"},
+		"files:test/foo-1.0.0": {ID: "files:test/foo-1.0.0", Kind: filesItem, Chart: "test/foo", Version: "1.0.0"},
+		"files:test/foo-0.1.0": {ID: "files:test/foo-0.1.0", Kind: filesItem, Chart: "test/foo", Version: "0.1.0"},
+		"icon:test/bar":        {ID: "icon:test/bar", Kind: iconItem, Chart: "test/bar"},
+		"files:test/foo-2.0.0": {ID: "files:test/foo-2.0.0", Kind: filesItem, Chart: "test/foo", Version: "2.0.0", Attempts: maxItemAttempts},
+	}
+	icons, files, stale := failedJobs(charts, failed)
+	assert.Equal(t, icons, []chart{charts[0]}, "icons")
+	assert.Equal(t, files, []importChartFilesJob{{"foo", r, chartVersion{Version: "1.0.0"}}}, "files")
+	assert.Equal(t, len(stale), 2, "stale items")
+}
+
+func Test_hasRetriedItems(t *testing.T) {
+	assert.False(t, hasRetriedItems(map[string]failedItem{}), "no items")
+	assert.True(t, hasRetriedItems(map[string]failedItem{"icon:test/foo": {Attempts: 1}}), "failed item")
+	assert.False(t, hasRetriedItems(map[string]failedItem{"icon:test/foo": {Attempts: maxItemAttempts}}), "item failing every time")
+}
+
+func Test_iconWorkerFailedItems(t *testing.T) {
+	netClient = &badHTTPClient{}
+	c := chart{ID: "test/foo", Name: "foo", Icon: "https://my.examplerepo.com/foo.png", Repo: repo{Name: "test"}}
+	m := mock.Mock{}
+	m.On("UpsertId", "icon:test/foo", mock.Anything)
+	dbSession := mockstore.NewMockSession(&m)
+
+	icons := make(chan chart, 1)
+	icons <- c
+	close(icons)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	iconWorker(dbSession, &wg, icons, map[string]failedItem{"icon:test/foo": {ID: "icon:test/foo", Attempts: 2}})
+	m.AssertExpectations(t)
+	item := m.Calls[0].Arguments.Get(1).(failedItem)
+	assert.Equal(t, item.Attempts, 3, "attempts")
+	assert.Equal(t, item.Kind, iconItem, "kind")
+	assert.Equal(t, item.Chart, "test/foo", "chart")
+	assert.Equal(t, item.Error, "500 https://my.examplerepo.com/foo.png", "error")
+
+	t.Run("previously failed item succeeds", func(t *testing.T) {
+		m := mock.Mock{}
+		m.On("RemoveAll", bson.M{"_id": bson.M{"$in": []string{"icon:test/foo"}}})
+		dbSession := mockstore.NewMockSession(&m)
+		icons := make(chan chart, 1)
+		icons <- chart{ID: "test/foo", Name: "foo", Repo: repo{Name: "test"}}
+		close(icons)
+		wg.Add(1)
+		iconWorker(dbSession, &wg, icons, map[string]failedItem{"icon:test/foo": {ID: "icon:test/foo"}})
+		m.AssertExpectations(t)
+	})
+}
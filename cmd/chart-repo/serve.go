@@ -0,0 +1,128 @@
+/*
+Copyright (c) 2018 The Helm Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var serveCmd = &cobra.Command{
+	Use:   "serve",
+	Short: "serve an API to trigger syncs of the given chart repositories",
+	Run: func(cmd *cobra.Command, args []string) {
+		repoFlags, err := cmd.Flags().GetStringSlice("repo")
+		if err != nil {
+			logrus.Fatal(err)
+		}
+		port, err := cmd.Flags().GetString("port")
+		if err != nil {
+			logrus.Fatal(err)
+		}
+
+		configs, err := repoConfigs(cmd)
+		if err != nil {
+			logrus.Fatal(err)
+		}
+		if err := openBlobStore(cmd); err != nil {
+			logrus.Fatal(err)
+		}
+		if err := validateIconSettings(); err != nil {
+			logrus.Fatal(err)
+		}
+		if err := validateWorkerSettings(); err != nil {
+			logrus.Fatal(err)
+		}
+		authorizationHeader := os.Getenv("AUTHORIZATION_HEADER")
+		repos := map[string]repo{}
+		for _, r := range repoFlags {
+			kv := strings.SplitN(r, "=", 2)
+			if len(kv) != 2 {
+				logrus.Fatalf("Invalid repository %q, expected [REPO NAME]=[REPO URL]", r)
+			}
+			if repos[kv[0]], err = configuredRepo(configs, kv[0], kv[1], authorizationHeader); err != nil {
+				logrus.Fatal(err)
+			}
+		}
+		// The repositories of the repos file can be synced as well
+		for name, c := range configs {
+			if _, ok := repos[name]; ok {
+				continue
+			}
+			if c.URL == "" {
+				logrus.Fatalf("Repository %q has no URL", name)
+			}
+			if repos[name], err = c.repo(); err != nil {
+				logrus.Fatal(err)
+			}
+		}
+		if len(repos) == 0 {
+			logrus.Info("Need at least one repository")
+			cmd.Help()
+			return
+		}
+
+		filter, err := filtersFromFlags(cmd)
+		if err != nil {
+			logrus.Fatal(err)
+		}
+
+		secret := os.Getenv("SYNC_SECRET")
+		if secret == "" {
+			logrus.Fatal("SYNC_SECRET must be set")
+		}
+
+		dbSession, err := dbSessionFromFlags(cmd)
+		if err != nil {
+			logrus.Fatalf("Can't connect to mongoDB: %v", err)
+		}
+
+		shutdownTimeout, err := cmd.Flags().GetDuration("shutdown-timeout")
+		if err != nil {
+			logrus.Fatal(err)
+		}
+
+		s := newSyncServer(dbSession, repos, secret, filter)
+		srv := &http.Server{
+			Addr:         ":" + port,
+			Handler:      s.routes(),
+			ReadTimeout:  10 * time.Second,
+			WriteTimeout: 30 * time.Second,
+		}
+		stop := make(chan os.Signal, 1)
+		signal.Notify(stop, syscall.SIGTERM, os.Interrupt)
+
+		logrus.WithFields(logrus.Fields{"addr": srv.Addr}).Info("Started chart-repo sync server")
+		if err := s.serve(srv, stop, shutdownTimeout); err != nil && err != http.ErrServerClosed {
+			logrus.WithFields(logrus.Fields{"addr": srv.Addr}).WithError(err).Fatal("chart-repo sync server stopped unexpectedly")
+		}
+		logrus.Info("Stopped chart-repo sync server")
+	},
+}
+
+func init() {
+	serveCmd.Flags().StringSlice("repo", []string{}, "Chart repository that can be synced, as [REPO NAME]=[REPO URL]")
+	serveCmd.Flags().String("port", "8080", "Port to listen on")
+	serveCmd.Flags().Duration("shutdown-timeout", 30*time.Second, "Maximum amount of time to wait for in-flight requests on shutdown, running syncs are always waited for")
+}
@@ -18,9 +18,8 @@ package main
 
 import (
 	"os"
-	"strings"
+	"time"
 
-	"github.com/kubeapps/common/datastore"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 )
@@ -35,57 +34,41 @@ var syncCmd = &cobra.Command{
 			return
 		}
 
-		mongoURL, err := cmd.Flags().GetString("mongo-url")
+		filter, err := filtersFromFlags(cmd)
 		if err != nil {
 			logrus.Fatal(err)
 		}
-		mongoDB, err := cmd.Flags().GetString("mongo-database")
-		if err != nil {
-			logrus.Fatal(err)
-		}
-		mongoUser, err := cmd.Flags().GetString("mongo-user")
+
+		dbSession, err := dbSessionFromFlags(cmd)
 		if err != nil {
-			logrus.Fatal(err)
+			logrus.Fatalf("Can't connect to mongoDB: %v", err)
 		}
 
-		filter := new(filters)
-		filter.Annotations = make(map[string]string)
-		filterAnnotationsStrings, err := cmd.Flags().GetStringSlice("filter-annotation")
+		configs, err := repoConfigs(cmd)
 		if err != nil {
 			logrus.Fatal(err)
 		}
-		for _, a := range filterAnnotationsStrings {
-			kv := strings.Split(a, "=")
-			if len(kv) == 2 {
-				filter.Annotations[kv[0]] = kv[1]
-			} else {
-				filter.Annotations[a] = ""
-			}
-		}
-		filterNammesStrings, err := cmd.Flags().GetStringSlice("filter-name")
-		if err != nil {
+		if err := openBlobStore(cmd); err != nil {
 			logrus.Fatal(err)
 		}
-		filter.Names = filterNammesStrings
-
-		mongoPW := os.Getenv("MONGO_PASSWORD")
-		debug, err := cmd.Flags().GetBool("debug")
-		if err != nil {
+		if err := validateIconSettings(); err != nil {
 			logrus.Fatal(err)
 		}
-		if debug {
-			logrus.SetLevel(logrus.DebugLevel)
+		if err := validateWorkerSettings(); err != nil {
+			logrus.Fatal(err)
 		}
-		mongoConfig := datastore.Config{URL: mongoURL, Database: mongoDB, Username: mongoUser, Password: mongoPW}
-		dbSession, err := datastore.NewSession(mongoConfig)
+		r, err := configuredRepo(configs, args[0], args[1], os.Getenv("AUTHORIZATION_HEADER"))
 		if err != nil {
-			logrus.Fatalf("Can't connect to mongoDB: %v", err)
+			logrus.Fatal(err)
 		}
-
-		authorizationHeader := os.Getenv("AUTHORIZATION_HEADER")
-		if err = syncRepo(dbSession, args[0], args[1], authorizationHeader, filter); err != nil {
+		if err = syncRepo(dbSession, r, filter); err != nil {
 			logrus.Fatalf("Can't add chart repository to database: %v", err)
 		}
+		// The deliveries queued by this sync, and those to retry, are attempted
+		// once, the next syncs retry them
+		if err = deliverPendingWebhooks(dbSession, time.Now()); err != nil {
+			logrus.WithError(err).Error("Can't deliver webhooks")
+		}
 
 		logrus.Infof("Successfully added the chart repository %s to database", args[0])
 	},
@@ -0,0 +1,204 @@
+/*
+Copyright (c) 2018 The Helm Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/globalsign/mgo/bson"
+	"github.com/gorilla/mux"
+	"github.com/kubeapps/common/datastore"
+	"github.com/kubeapps/common/response"
+	log "github.com/sirupsen/logrus"
+)
+
+const syncRunsCollection = "sync_runs"
+
+// Status of a sync run
+const (
+	syncRunning   = "running"
+	syncSucceeded = "succeeded"
+	syncFailed    = "failed"
+)
+
+// syncRun records a sync triggered through the sync server
+type syncRun struct {
+	ID       bson.ObjectId `json:"id" bson:"_id"`
+	Repo     string        `json:"repo" bson:"repo"`
+	Status   string        `json:"status" bson:"status"`
+	Error    string        `json:"error,omitempty" bson:"error,omitempty"`
+	Started  time.Time     `json:"started" bson:"started"`
+	Finished *time.Time    `json:"finished,omitempty" bson:"finished,omitempty"`
+}
+
+// syncServer triggers syncs of the configured repositories on request, so
+// charts can be published without waiting for the next scheduled sync
+type syncServer struct {
+	dbSession datastore.Session
+	repos     map[string]repo
+	secret    string
+	filter    *filters
+	// sync is the function used to sync a repository, i.e. syncRepo
+	sync func(dbSession datastore.Session, r repo, filter *filters) error
+
+	mu sync.Mutex
+	// running holds the ID of the run in progress for each repository
+	running map[string]bson.ObjectId
+	wg      sync.WaitGroup
+}
+
+func newSyncServer(dbSession datastore.Session, repos map[string]repo, secret string, filter *filters) *syncServer {
+	return &syncServer{
+		dbSession: dbSession,
+		repos:     repos,
+		secret:    secret,
+		filter:    filter,
+		sync:      syncRepo,
+		running:   map[string]bson.ObjectId{},
+	}
+}
+
+// serve serves the sync API and delivers the queued webhooks until the
+// listener fails or a signal is received on stop. On a signal, in-flight
+// requests are given up to shutdownTimeout to complete, then the running
+// syncs and webhook deliveries are waited for.
+func (s *syncServer) serve(srv *http.Server, stop <-chan os.Signal, shutdownTimeout time.Duration) error {
+	stopDeliveries := make(chan struct{})
+	deliveriesDone := make(chan struct{})
+	go func() {
+		defer close(deliveriesDone)
+		runWebhookDeliveries(s.dbSession, webhookDeliveryInterval, stopDeliveries)
+	}()
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- srv.ListenAndServe()
+	}()
+
+	var err error
+	select {
+	case err = <-errCh:
+	case sig := <-stop:
+		log.WithFields(log.Fields{"signal": sig, "timeout": shutdownTimeout}).Info("Shutting down chart-repo sync server")
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		err = srv.Shutdown(ctx)
+		cancel()
+	}
+	// No sync is started once the server is shut down
+	s.wg.Wait()
+	close(stopDeliveries)
+	<-deliveriesDone
+	return err
+}
+
+func (s *syncServer) routes() http.Handler {
+	r := mux.NewRouter()
+	r.Methods("POST").Path("/v1/repos/{repo}/sync").Handler(s.authenticated(s.triggerSync))
+	r.Methods("GET").Path("/v1/repos/{repo}/sync/{id}").Handler(s.authenticated(s.getSyncRun))
+	return r
+}
+
+// authenticated only lets requests with the shared secret as bearer token through
+func (s *syncServer) authenticated(h http.HandlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		token := strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(token), []byte(s.secret)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			response.NewErrorResponse(http.StatusUnauthorized, "invalid sync secret").Write(w)
+			return
+		}
+		h(w, req)
+	})
+}
+
+// triggerSync starts a sync of the repository, or returns the run in progress
+// if the repository is already being synced
+func (s *syncServer) triggerSync(w http.ResponseWriter, req *http.Request) {
+	repoName := mux.Vars(req)["repo"]
+	r, ok := s.repos[repoName]
+	if !ok {
+		response.NewErrorResponse(http.StatusNotFound, "could not find repository").Write(w)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if id, ok := s.running[repoName]; ok {
+		response.NewDataResponse(syncRun{ID: id, Repo: repoName, Status: syncRunning}).WithCode(http.StatusAccepted).Write(w)
+		return
+	}
+
+	run := syncRun{ID: bson.NewObjectId(), Repo: repoName, Status: syncRunning, Started: time.Now()}
+	db, closer := s.dbSession.DB()
+	defer closer()
+	if err := db.C(syncRunsCollection).Insert(run); err != nil {
+		log.WithFields(log.Fields{"repo": repoName}).WithError(err).Error("failed to record sync run")
+		response.NewErrorResponse(http.StatusInternalServerError, "could not start sync").Write(w)
+		return
+	}
+	s.running[repoName] = run.ID
+	s.wg.Add(1)
+	go s.run(run, r)
+
+	response.NewDataResponse(run).WithCode(http.StatusAccepted).Write(w)
+}
+
+// run syncs the repository and records the result of the run
+func (s *syncServer) run(run syncRun, r repo) {
+	defer s.wg.Done()
+	log.WithFields(log.Fields{"repo": run.Repo, "run": run.ID.Hex()}).Info("Syncing repository")
+	err := s.sync(s.dbSession, r, s.filter)
+
+	s.mu.Lock()
+	delete(s.running, run.Repo)
+	s.mu.Unlock()
+
+	update := bson.M{"status": syncSucceeded, "finished": time.Now()}
+	if err != nil {
+		log.WithFields(log.Fields{"repo": run.Repo, "run": run.ID.Hex()}).WithError(err).Error("failed to sync repository")
+		update["status"] = syncFailed
+		update["error"] = err.Error()
+	}
+	db, closer := s.dbSession.DB()
+	defer closer()
+	if err := db.C(syncRunsCollection).UpdateId(run.ID, bson.M{"$set": update}); err != nil {
+		log.WithFields(log.Fields{"repo": run.Repo, "run": run.ID.Hex()}).WithError(err).Error("failed to record sync run")
+	}
+}
+
+// getSyncRun returns the status of a sync run
+func (s *syncServer) getSyncRun(w http.ResponseWriter, req *http.Request) {
+	vars := mux.Vars(req)
+	if !bson.IsObjectIdHex(vars["id"]) {
+		response.NewErrorResponse(http.StatusNotFound, "could not find sync run").Write(w)
+		return
+	}
+	db, closer := s.dbSession.DB()
+	defer closer()
+	var run syncRun
+	if err := db.C(syncRunsCollection).Find(bson.M{"_id": bson.ObjectIdHex(vars["id"]), "repo": vars["repo"]}).One(&run); err != nil {
+		response.NewErrorResponse(http.StatusNotFound, "could not find sync run").Write(w)
+		return
+	}
+	response.NewDataResponse(run).Write(w)
+}
@@ -0,0 +1,159 @@
+/*
+Copyright (c) 2018 The Helm Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/arschles/assert"
+	"github.com/globalsign/mgo/bson"
+	"github.com/kubeapps/common/datastore"
+	"github.com/kubeapps/common/datastore/mockstore"
+	"github.com/stretchr/testify/mock"
+)
+
+type bodySyncRun struct {
+	Data syncRun `json:"data"`
+}
+
+func newTestSyncServer(m *mock.Mock, syncErr error, release <-chan struct{}) (*syncServer, *[]string) {
+	var synced []string
+	repos := map[string]repo{"stable": {Name: "stable", URL: "https://charts.example.com"}}
+	s := newSyncServer(mockstore.NewMockSession(m), repos, "s3cr3t", new(filters))
+	s.sync = func(dbSession datastore.Session, r repo, filter *filters) error {
+		<-release
+		synced = append(synced, r.Name+"="+r.URL)
+		return syncErr
+	}
+	return s, &synced
+}
+
+func syncRequest(s *syncServer, method, path, token string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(method, path, nil)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	s.routes().ServeHTTP(w, req)
+	return w
+}
+
+func Test_triggerSync(t *testing.T) {
+	t.Run("invalid secret", func(t *testing.T) {
+		s, _ := newTestSyncServer(&mock.Mock{}, nil, nil)
+		w := syncRequest(s, "POST", "/v1/repos/stable/sync", "wrong")
+		assert.Equal(t, w.Code, http.StatusUnauthorized, "status code")
+	})
+
+	t.Run("unknown repository", func(t *testing.T) {
+		s, _ := newTestSyncServer(&mock.Mock{}, nil, nil)
+		w := syncRequest(s, "POST", "/v1/repos/incubator/sync", "s3cr3t")
+		assert.Equal(t, w.Code, http.StatusNotFound, "status code")
+	})
+
+	for _, tt := range []struct {
+		name       string
+		syncErr    error
+		wantUpdate bson.M
+	}{
+		{"sync succeeds", nil, bson.M{"status": syncSucceeded}},
+		{"sync fails", errors.New("no charts in repository index"), bson.M{"status": syncFailed, "error": "no charts in repository index"}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &mock.Mock{}
+			m.On("Insert", mock.Anything)
+			var update bson.M
+			m.On("UpdateId", mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+				update = args.Get(1).(bson.M)["$set"].(bson.M)
+			})
+			release := make(chan struct{})
+			s, synced := newTestSyncServer(m, tt.syncErr, release)
+
+			w := syncRequest(s, "POST", "/v1/repos/stable/sync", "s3cr3t")
+			assert.Equal(t, w.Code, http.StatusAccepted, "status code")
+			var b bodySyncRun
+			assert.NoErr(t, json.NewDecoder(w.Body).Decode(&b))
+			assert.True(t, b.Data.ID.Valid(), "run id")
+			assert.Equal(t, b.Data.Status, syncRunning, "status")
+
+			// A second request while the sync is running returns the same run
+			w = syncRequest(s, "POST", "/v1/repos/stable/sync", "s3cr3t")
+			var b2 bodySyncRun
+			assert.NoErr(t, json.NewDecoder(w.Body).Decode(&b2))
+			assert.Equal(t, b2.Data.ID, b.Data.ID, "run id")
+
+			close(release)
+			s.wg.Wait()
+			assert.Equal(t, *synced, []string{"stable=https://charts.example.com"}, "synced repositories")
+			m.AssertNumberOfCalls(t, "Insert", 1)
+			assert.Equal(t, m.Calls[1].Arguments.Get(0), b.Data.ID, "updated run")
+			assert.True(t, update["finished"] != nil, "finished time")
+			delete(update, "finished")
+			assert.Equal(t, update, tt.wantUpdate, "run update")
+		})
+	}
+}
+
+func Test_getSyncRun(t *testing.T) {
+	m := &mock.Mock{}
+	id := bson.NewObjectId()
+	m.On("One", mock.Anything).Return(nil).Run(func(args mock.Arguments) {
+		*args.Get(0).(*syncRun) = syncRun{ID: id, Repo: "stable", Status: syncSucceeded}
+	})
+	s, _ := newTestSyncServer(m, nil, nil)
+
+	w := syncRequest(s, "GET", "/v1/repos/stable/sync/"+id.Hex(), "s3cr3t")
+	assert.Equal(t, w.Code, http.StatusOK, "status code")
+	var b bodySyncRun
+	assert.NoErr(t, json.NewDecoder(w.Body).Decode(&b))
+	assert.Equal(t, b.Data.Status, syncSucceeded, "status")
+
+	w = syncRequest(s, "GET", "/v1/repos/stable/sync/foo", "s3cr3t")
+	assert.Equal(t, w.Code, http.StatusNotFound, "status code")
+}
+
+func Test_syncServerServe(t *testing.T) {
+	s, _ := newTestSyncServer(&mock.Mock{}, nil, nil)
+	srv := &http.Server{Addr: "127.0.0.1:0", Handler: s.routes()}
+	// A sync in progress
+	s.wg.Add(1)
+	stop := make(chan os.Signal, 1)
+	done := make(chan error, 1)
+	go func() {
+		done <- s.serve(srv, stop, time.Second)
+	}()
+
+	stop <- os.Interrupt
+	select {
+	case <-done:
+		t.Fatal("the server stopped before the sync completed")
+	case <-time.After(50 * time.Millisecond):
+	}
+	s.wg.Done()
+	select {
+	case err := <-done:
+		assert.NoErr(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("the server did not stop once the sync completed")
+	}
+}
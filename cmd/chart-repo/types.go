@@ -18,12 +18,20 @@ package main
 
 import (
 	"time"
+
+	"github.com/globalsign/mgo/bson"
 )
 
 type repo struct {
-	Name                string
-	URL                 string
-	AuthorizationHeader string `bson:"-"`
+	Name string
+	URL  string
+	// Credentials authorize the requests to the repository, and Client sends
+	// them if the repository needs its own TLS settings
+	Credentials credentialProvider `bson:"-"`
+	Client      httpClient         `bson:"-"`
+	// AuthHosts are the hosts other than the one of the repository URL the
+	// credentials are sent to, see repo.authorizes
+	AuthHosts []string `bson:"-"`
 }
 
 type maintainer struct {
@@ -32,15 +40,18 @@ type maintainer struct {
 }
 
 type chart struct {
-	ID            string `bson:"_id"`
-	Name          string
-	Repo          repo
-	Description   string
-	Home          string
-	Keywords      []string
-	Maintainers   []maintainer
-	Sources       []string
-	Icon          string
+	ID          string `bson:"_id"`
+	Name        string
+	Repo        repo
+	Description string
+	Home        string
+	Keywords    []string
+	Maintainers []maintainer
+	Sources     []string
+	Icon        string
+	Deprecated  bool
+	// ReplacedBy optionally points deprecated charts to their replacement
+	ReplacedBy    string
 	ChartVersions []chartVersion
 }
 
@@ -50,21 +61,184 @@ type chartVersion struct {
 	Created    time.Time
 	Digest     string
 	URLs       []string
+	Deprecated bool
+	// Yanked versions have been withdrawn by an administrator, they are listed
+	// after the other versions so they are never resolved as the latest one
+	Yanked bool
+	// Changes are read from the artifacthub.io/changes annotation and stored
+	// with the chart files
+	Changes []change `bson:"-"`
+}
+
+// change is an entry of the artifacthub.io/changes annotation
+type change struct {
+	Kind        string       `json:"kind,omitempty" bson:"kind,omitempty"`
+	Description string       `json:"description" bson:"description"`
+	Links       []changeLink `json:"links,omitempty" bson:"links,omitempty"`
+}
+
+type changeLink struct {
+	Name string `json:"name" bson:"name"`
+	URL  string `json:"url" bson:"url"`
 }
 
 type chartFiles struct {
-	ID     string `bson:"_id"`
-	Readme string
-	Values string
-	Schema string
-	Repo   repo
-	Digest string
+	ID        string `bson:"_id"`
+	Readme    string
+	Values    string
+	Schema    string
+	Repo      repo
+	Digest    string
+	Signed    bool
+	Changelog string
+	Changes   []change
+	// The keys of the files in the blob store, if one is configured, see
+	// blobs.go
+	ReadmeKey  string
+	ValuesKey  string
+	SchemaKey  string
+	TarballKey string
+	// Shared files are stored with the chart versions with the same digest in
+	// the file_contents collection, see contents.go
+	Shared bool
+	// Files lists all the files of the chart version
+	Files []chartFile
+	// CRDs are the CustomResourceDefinitions shipped by the chart version, see
+	// crds.go
+	CRDs []crd `bson:"crds"`
+	// Images are the container images of the default installation of the
+	// chart version, ImagesError is set if its templates could not be
+	// rendered, see images.go
+	Images      []containerImage `bson:"images"`
+	ImagesError string           `bson:"images_error,omitempty"`
+	// Chart and Version identify the chart version the images are listed
+	// for, they cannot be told apart in the id
+	Chart   string `bson:"chart"`
+	Version string `bson:"version"`
+	// FilesVersion is the chartFilesVersion the files were imported with
+	FilesVersion int `bson:"files_version"`
+}
+
+// chartContent holds the files of a chart tarball, shared by the chart versions
+// of every repository with the tarball digest it is keyed by
+type chartContent struct {
+	ID           string `bson:"_id"`
+	Readme       string
+	Values       string
+	Schema       string
+	Changelog    string
+	ReadmeKey    string
+	ValuesKey    string
+	SchemaKey    string
+	TarballKey   string
+	Files        []chartFile
+	CRDs         []crd            `bson:"crds"`
+	Images       []containerImage `bson:"images"`
+	ImagesError  string           `bson:"images_error,omitempty"`
+	FilesVersion int              `bson:"files_version"`
+}
+
+// chartFile is an entry of the file listing of a chart version, small text
+// files are stored along with it, see listing.go
+type chartFile struct {
+	Path string `bson:"path"`
+	Size int64  `bson:"size"`
+	Mode int64  `bson:"mode"`
+	// Content is the content of the file, or Key its key in the blob store if
+	// one is configured
+	Content string `bson:"content,omitempty"`
+	Key     string `bson:"key,omitempty"`
 }
 
 type repoCheck struct {
 	ID         string    `bson:"_id"`
 	LastUpdate time.Time `bson:"last_update"`
 	Checksum   string    `bson:"checksum"`
+	// ETag and LastModified are the validators returned by the server for the
+	// index, sent back to only download it again if it changed
+	ETag         string `bson:"etag,omitempty"`
+	LastModified string `bson:"last_modified,omitempty"`
+	// FilesVersion is the chartFilesVersion the files of the repository were
+	// imported with
+	FilesVersion int `bson:"files_version"`
+}
+
+// yankedVersion records a chart version withdrawn through the chartsvc admin
+// API, it is kept separately from the chart so it survives repository syncs
+type yankedVersion struct {
+	ID      string `bson:"_id"`
+	Repo    string `bson:"repo"`
+	Chart   string `bson:"chart"`
+	Version string `bson:"version"`
+}
+
+// event records a change in the charts of a repository
+type event struct {
+	ID      bson.ObjectId `json:"id" bson:"_id"`
+	Type    string        `json:"type" bson:"type"`
+	Repo    string        `json:"repo" bson:"repo"`
+	Chart   string        `json:"chart" bson:"chart"`
+	Name    string        `json:"name" bson:"name"`
+	Version string        `json:"version,omitempty" bson:"version,omitempty"`
+	Time    time.Time     `json:"time" bson:"time"`
+}
+
+// webhook is a subscription to the events of the repositories and charts
+// matching its patterns
+type webhook struct {
+	ID     bson.ObjectId `bson:"_id"`
+	URL    string        `bson:"url"`
+	Secret string        `bson:"secret"`
+	// Repo and Chart are glob patterns matched against the repository and
+	// chart names, an empty pattern matches everything
+	Repo  string `bson:"repo,omitempty"`
+	Chart string `bson:"chart,omitempty"`
+	// Events lists the event types to deliver, all of them if empty
+	Events []string `bson:"events,omitempty"`
+}
+
+// webhookDelivery is the delivery log entry of an event sent to a webhook
+type webhookDelivery struct {
+	ID         bson.ObjectId `bson:"_id"`
+	Webhook    bson.ObjectId `bson:"webhook"`
+	Event      bson.ObjectId `bson:"event"`
+	URL        string        `bson:"url"`
+	Attempts   int           `bson:"attempts"`
+	StatusCode int           `bson:"status_code,omitempty"`
+	Error      string        `bson:"error,omitempty"`
+	Delivered  bool          `bson:"delivered"`
+	Time       time.Time     `bson:"time"`
+	// Pending deliveries are attempted again at NextAttempt, see webhooks.go
+	Pending     bool      `bson:"pending"`
+	NextAttempt time.Time `bson:"next_attempt,omitempty"`
+	// LeaseExpiry is set while a process attempts the delivery
+	LeaseExpiry time.Time `bson:"lease_expiry,omitempty"`
+}
+
+// iconVariant is a chart icon resized and encoded in one of the configured
+// formats, see icons.go
+type iconVariant struct {
+	Size        int    `bson:"size"`
+	Format      string `bson:"format"`
+	ContentType string `bson:"content_type"`
+	Data        []byte `bson:"data,omitempty"`
+	// Key is the key of the icon in the blob store, if one is configured
+	Key string `bson:"key,omitempty"`
+}
+
+// failedItem records an icon or the files of a chart version that could not
+// be imported, so the next sync retries it even if the index is unchanged
+type failedItem struct {
+	ID      string    `bson:"_id"`
+	Repo    string    `bson:"repo"`
+	Kind    string    `bson:"kind"`
+	Chart   string    `bson:"chart"`
+	Version string    `bson:"version,omitempty"`
+	Error   string    `bson:"error"`
+	Time    time.Time `bson:"time"`
+	// Attempts is the number of syncs that failed to import the item, it is
+	// no longer retried after maxItemAttempts, see retry.go
+	Attempts int `bson:"attempts"`
 }
 
 type filters struct {
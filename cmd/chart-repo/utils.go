@@ -20,7 +20,6 @@ import (
 	"archive/tar"
 	"bytes"
 	"compress/gzip"
-	"crypto/sha256"
 	"crypto/tls"
 	"crypto/x509"
 	"errors"
@@ -32,6 +31,8 @@ import (
 	"os"
 	"path"
 	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -42,15 +43,35 @@ import (
 	"github.com/jinzhu/copier"
 	"github.com/kubeapps/common/datastore"
 	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
 	helmrepo "k8s.io/helm/pkg/repo"
 )
 
 const (
-	chartCollection       = "charts"
-	repositoryCollection  = "repos"
-	chartFilesCollection  = "files"
-	defaultTimeoutSeconds = 10
-	additionalCAFile      = "/usr/local/share/ca-certificates/ca.crt"
+	chartCollection      = "charts"
+	repositoryCollection = "repos"
+	chartFilesCollection = "files"
+	// chartContentsCollection holds the files shared by the chart versions with
+	// the same digest, see contents.go
+	chartContentsCollection = "file_contents"
+	yankedCollection        = "yanked"
+	eventsCollection        = "events"
+	additionalCAFile        = "/usr/local/share/ca-certificates/ca.crt"
+	// replacedByAnnotation can be set on deprecated charts to point users to
+	// the chart that replaces them, e.g. "stable/nginx-ingress"
+	replacedByAnnotation = "monocular.helm.sh/replaced-by"
+	// Types of the events recorded when a repository is synced
+	chartAddedEvent   = "chart_added"
+	versionAddedEvent = "version_added"
+	chartRemovedEvent = "chart_removed"
+	// changesAnnotation lists the changes introduced by a chart version, see
+	// https://artifacthub.io/docs/topics/annotations/helm/
+	changesAnnotation = "artifacthub.io/changes"
+	// chartFilesVersion is the version of what is imported from the tarballs
+	// of chart versions. It is bumped when more is imported, the files of chart
+	// versions imported before are then imported again: 1 added the file
+	// listings, CRDs and images.
+	chartFilesVersion = 1
 )
 
 type importChartFilesJob struct {
@@ -65,17 +86,43 @@ type httpClient interface {
 
 var netClient httpClient = &http.Client{}
 
+// requestTimeout is the timeout of each attempt of an outbound request
+var requestTimeout = 10 * time.Second
+
+// do sends a request to the repository with its credentials and client if
+// the host of the request is allowed to receive them, e.g. a client
+// certificate is not presented to the hosts of icons
+func (r repo) do(req *http.Request) (*http.Response, error) {
+	if !r.authorizes(req.URL.Hostname()) {
+		return netClient.Do(req)
+	}
+	if r.Credentials != nil {
+		if err := r.Credentials.authorize(req); err != nil {
+			return nil, err
+		}
+	}
+	if r.Client != nil {
+		return r.Client.Do(req)
+	}
+	return netClient.Do(req)
+}
+
 func parseRepoURL(repoURL string) (*url.URL, error) {
 	repoURL = strings.TrimSpace(repoURL)
 	return url.ParseRequestURI(repoURL)
 }
 
 func init() {
-	var err error
-	netClient, err = initNetClient(additionalCAFile)
-	if err != nil {
-		log.Fatal(err)
-	}
+	// The client is created once the flags have been parsed so the timeout,
+	// retries and limits can be configured
+	cobra.OnInitialize(func() {
+		client, err := initNetClient(additionalCAFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		limits = newRequestLimits(maxHostConcurrency, maxRequestsPerSecond)
+		netClient = outboundClient(client)
+	})
 }
 
 // Syncing is performed in the following steps:
@@ -87,72 +134,168 @@ func init() {
 // These steps are processed in this way to ensure relevant chart data is
 // imported into the database as fast as possible. E.g. we want all icons for
 // charts before fetching readmes for each chart and version pair.
-func syncRepo(dbSession datastore.Session, repoName, repoURL string, authorizationHeader string, filter *filters) error {
+//
+// The repository is requested with its credentials and client, if any.
+func syncRepo(dbSession datastore.Session, r repo, filter *filters) error {
+	repoName, repoURL := r.Name, r.URL
 	url, err := parseRepoURL(repoURL)
 	if err != nil {
 		log.WithFields(log.Fields{"url": repoURL}).WithError(err).Error("failed to parse URL")
 		return err
 	}
 
-	r := repo{Name: repoName, URL: url.String(), AuthorizationHeader: authorizationHeader}
-	repoBytes, err := fetchRepoIndex(r)
+	r.URL = url.String()
+	// Items that failed to be imported by the last sync are retried, which
+	// needs the index even if it has not been modified
+	failed, err := loadFailedItems(dbSession, repoName)
+	if err != nil {
+		return err
+	}
+	// The files of every chart version are imported again if they were imported
+	// by an older chart-repo, which also needs the index
+	check := lastCheck(dbSession, repoName)
+	backfill := check.FilesVersion < chartFilesVersion
+	cached := indexValidators{}
+	if !hasRetriedItems(failed) && !backfill {
+		cached = indexValidators{ETag: check.ETag, LastModified: check.LastModified}
+	}
+	body, validators, err := fetchRepoIndex(r, cached)
+	if err == errIndexNotModified {
+		log.WithFields(log.Fields{"url": repoURL}).Info("Skipping repository since the index has not been modified")
+		return nil
+	}
 	if err != nil {
 		return err
 	}
 
-	repoChecksum, err := getSha256(repoBytes)
+	// The index is only parsed if it changed, or items must be imported again
+	index, repoChecksum, err := spoolRepoIndex(body, maxIndexSize)
+	body.Close()
 	if err != nil {
+		log.WithFields(log.Fields{"url": repoURL}).WithError(err).Error("failed to read repo index")
 		return err
 	}
+	defer index.Close()
 
 	// Check if the repo has been already processed
-	if repoAlreadyProcessed(dbSession, repoName, repoChecksum) {
+	processed := repoAlreadyProcessed(dbSession, repoName, repoChecksum)
+	if processed && !hasRetriedItems(failed) && !backfill {
 		log.WithFields(log.Fields{"url": repoURL}).Info("Skipping repository since there are no updates")
-		return nil
+		// The validators of the index may have changed with an identical
+		// content, they are kept for the next conditional request
+		return updateLastCheck(dbSession, repoName, repoChecksum, validators, time.Now())
 	}
 
-	index, err := parseRepoIndex(repoBytes)
+	// The charts are built while the index is read, so the whole index is
+	// never held in memory
+	var charts []chart
+	_, err = readRepoIndex(index, maxIndexSize, func(entry helmrepo.ChartVersions) {
+		if c, ok := chartFromEntry(entry, r, filter); ok {
+			charts = append(charts, c)
+		}
+	})
 	if err != nil {
+		log.WithFields(log.Fields{"url": repoURL}).WithError(err).Error("failed to read repo index")
 		return err
 	}
 
-	charts := chartsFromIndex(index, r, filter)
-	if len(charts) == 0 {
-		return errors.New("no charts in repository index")
+	var changed []chart
+	var newVersions map[string][]chartVersion
+	if !processed {
+		if len(charts) == 0 {
+			return errors.New("no charts in repository index")
+		}
+		if err = markYankedVersions(dbSession, repoName, charts); err != nil {
+			return err
+		}
+		// Only the charts and versions that changed since the last sync are
+		// written and processed
+		stored, err := storedCharts(dbSession, repoName)
+		if err != nil {
+			return err
+		}
+		events := diffCharts(repoName, stored, charts, time.Now())
+		changed, newVersions = changedCharts(stored, charts)
+		log.WithFields(log.Fields{"url": repoURL, "charts": len(charts), "changed": len(changed)}).Info("Importing charts")
+		err = importCharts(dbSession, repoName, changed, chartIDs(charts))
+		if err != nil {
+			return err
+		}
+		if err = recordEvents(dbSession, events); err != nil {
+			return err
+		}
+		if err = queueWebhookDeliveries(dbSession, events, time.Now()); err != nil {
+			log.WithFields(log.Fields{"url": repoURL}).WithError(err).Error("failed to queue webhook deliveries")
+		}
 	}
-	err = importCharts(dbSession, charts)
-	if err != nil {
+
+	retryIcons, retryFiles, stale := failedJobs(charts, failed)
+	if err = clearFailedItems(dbSession, stale); err != nil {
 		return err
 	}
+	log.WithFields(log.Fields{"url": repoURL, "retried": len(retryIcons) + len(retryFiles)}).Debug("retrying failed items")
 
-	// Process 10 charts at a time
-	numWorkers := 10
-	iconJobs := make(chan chart, numWorkers)
-	chartFilesJobs := make(chan importChartFilesJob, numWorkers)
+	// The icons are imported first, so they are all available before the
+	// files of each chart version
+	iconJobs := make(chan chart, iconWorkers)
 	var wg sync.WaitGroup
-
-	log.Debugf("starting %d workers", numWorkers)
-	for i := 0; i < numWorkers; i++ {
+	log.Debugf("starting %d icon workers", iconWorkers)
+	for i := 0; i < iconWorkers; i++ {
 		wg.Add(1)
-		go importWorker(dbSession, &wg, iconJobs, chartFilesJobs)
+		go iconWorker(dbSession, &wg, iconJobs, failed)
 	}
 
-	// Enqueue jobs to process chart icons
-	for _, c := range charts {
+	// Enqueue jobs to process chart icons, the icons of the charts that have
+	// not been written are still in the database
+	queued := map[string]bool{}
+	for _, c := range changed {
+		queued[iconItemID(c)] = true
 		iconJobs <- c
 	}
-	// Close the iconJobs channel to signal the worker pools to move on to the
-	// chart files jobs
+	for _, c := range retryIcons {
+		if !queued[iconItemID(c)] {
+			iconJobs <- c
+		}
+	}
+	// Close the iconJobs channel to signal the workers that there are no more
+	// jobs to process, and wait for them to finish
 	close(iconJobs)
+	wg.Wait()
+
+	chartFilesJobs := make(chan importChartFilesJob, filesWorkers)
+	log.Debugf("starting %d files workers", filesWorkers)
+	for i := 0; i < filesWorkers; i++ {
+		wg.Add(1)
+		go filesWorker(dbSession, &wg, chartFilesJobs, failed)
+	}
 
 	// Iterate through the list of charts and enqueue the latest chart version to
 	// be processed. Append the rest of the chart versions to a list to be
 	// enqueued later
 	var toEnqueue []importChartFilesJob
-	for _, c := range charts {
-		chartFilesJobs <- importChartFilesJob{c.Name, c.Repo, c.ChartVersions[0]}
-		for _, cv := range c.ChartVersions[1:] {
+	filesCharts := changed
+	if backfill {
+		log.WithFields(log.Fields{"url": repoURL}).Info("Importing the files of every chart version again")
+		filesCharts = charts
+	}
+	for _, c := range filesCharts {
+		versions := c.ChartVersions
+		if !backfill {
+			versions = newVersions[c.ID]
+		}
+		if len(versions) == 0 {
+			continue
+		}
+		chartFilesJobs <- importChartFilesJob{c.Name, c.Repo, versions[0]}
+		queued[filesItemID(c.ID, versions[0].Version)] = true
+		for _, cv := range versions[1:] {
 			toEnqueue = append(toEnqueue, importChartFilesJob{c.Name, c.Repo, cv})
+			queued[filesItemID(c.ID, cv.Version)] = true
+		}
+	}
+	for _, j := range retryFiles {
+		if !queued[filesItemID(j.Repo.Name+"/"+j.Name, j.ChartVersion.Version)] {
+			toEnqueue = append(toEnqueue, j)
 		}
 	}
 
@@ -167,8 +310,16 @@ func syncRepo(dbSession datastore.Session, repoName, repoURL string, authorizati
 	// Wait for the worker pools to finish processing
 	wg.Wait()
 
+	// The signed and has-schema filters of the chart lists depend on the
+	// imported files, their validators change with the marker
+	if len(queued) > 0 || len(retryIcons) > 0 || len(retryFiles) > 0 {
+		if err := markImported(dbSession, repoName, time.Now()); err != nil {
+			return err
+		}
+	}
+
 	// Update cache in the database
-	if err = updateLastCheck(dbSession, repoName, repoChecksum, time.Now()); err != nil {
+	if err = updateLastCheck(dbSession, repoName, repoChecksum, validators, time.Now()); err != nil {
 		return err
 	}
 	log.WithFields(log.Fields{"url": repoURL}).Info("Stored repository update in cache")
@@ -176,15 +327,6 @@ func syncRepo(dbSession datastore.Session, repoName, repoURL string, authorizati
 	return nil
 }
 
-func getSha256(src []byte) (string, error) {
-	f := bytes.NewReader(src)
-	h := sha256.New()
-	if _, err := io.Copy(h, f); err != nil {
-		return "", err
-	}
-	return fmt.Sprintf("%x", h.Sum(nil)), nil
-}
-
 func repoAlreadyProcessed(dbSession datastore.Session, repoName string, checksum string) bool {
 	db, closer := dbSession.DB()
 	defer closer()
@@ -193,10 +335,37 @@ func repoAlreadyProcessed(dbSession datastore.Session, repoName string, checksum
 	return err == nil && checksum == lastCheck.Checksum
 }
 
-func updateLastCheck(dbSession datastore.Session, repoName string, checksum string, now time.Time) error {
+// lastCheck returns the last sync of the repository, or an empty check if it
+// has not been synced yet
+func lastCheck(dbSession datastore.Session, repoName string) repoCheck {
+	db, closer := dbSession.DB()
+	defer closer()
+	var check repoCheck
+	if err := db.C(repositoryCollection).Find(bson.M{"_id": repoName}).One(&check); err != nil {
+		return repoCheck{}
+	}
+	return check
+}
+
+func updateLastCheck(dbSession datastore.Session, repoName string, checksum string, validators indexValidators, now time.Time) error {
 	db, closer := dbSession.DB()
 	defer closer()
-	_, err := db.C(repositoryCollection).UpsertId(repoName, bson.M{"$set": bson.M{"last_update": now, "checksum": checksum}})
+	_, err := db.C(repositoryCollection).UpsertId(repoName, bson.M{"$set": bson.M{
+		"last_update":   now,
+		"checksum":      checksum,
+		"etag":          validators.ETag,
+		"last_modified": validators.LastModified,
+		"files_version": chartFilesVersion,
+	}})
+	return err
+}
+
+// markImported records when icons or files of the repository were last
+// imported
+func markImported(dbSession datastore.Session, repoName string, now time.Time) error {
+	db, closer := dbSession.DB()
+	defer closer()
+	_, err := db.C(repositoryCollection).UpsertId(repoName, bson.M{"$set": bson.M{"last_import": now}})
 	return err
 }
 
@@ -210,12 +379,32 @@ func deleteRepo(dbSession datastore.Session, repoName string) error {
 		return err
 	}
 
+	// The content shared with other repositories is kept
+	var shared []chartFiles
+	err = db.C(chartFilesCollection).Find(bson.M{"repo.name": repoName, "shared": true}).Select(bson.M{"digest": 1}).All(&shared)
+	if err != nil {
+		return err
+	}
 	_, err = db.C(chartFilesCollection).RemoveAll(bson.M{
 		"repo.name": repoName,
 	})
 	if err != nil {
 		return err
 	}
+	var digests []string
+	for _, f := range shared {
+		digests = append(digests, f.Digest)
+	}
+	if err = removeUnreferencedContent(db, digests); err != nil {
+		return err
+	}
+
+	_, err = db.C(failedItemsCollection).RemoveAll(bson.M{
+		"repo": repoName,
+	})
+	if err != nil {
+		return err
+	}
 
 	_, err = db.C(repositoryCollection).RemoveAll(bson.M{
 		"_id": repoName,
@@ -223,43 +412,97 @@ func deleteRepo(dbSession datastore.Session, repoName string) error {
 	return err
 }
 
-func fetchRepoIndex(r repo) ([]byte, error) {
+// indexValidators are the validators of the index of a repository, used to
+// make conditional requests
+type indexValidators struct {
+	ETag         string
+	LastModified string
+}
+
+// errIndexNotModified is returned by fetchRepoIndex if the index has not been
+// modified since it was last fetched
+var errIndexNotModified = errors.New("repo index not modified")
+
+// indexBody is the body of an index response, closing it closes the response
+// and the decompressor reading it if any
+type indexBody struct {
+	io.Reader
+	closers []io.Closer
+}
+
+func (b *indexBody) Close() error {
+	var err error
+	for _, c := range b.closers {
+		if cerr := c.Close(); cerr != nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// fetchRepoIndex requests the index of the repository and returns its body,
+// which must be closed by the caller. If the validators of a previous response
+// are given the request is conditional, and errIndexNotModified is returned if
+// the index has not changed.
+func fetchRepoIndex(r repo, cached indexValidators) (io.ReadCloser, indexValidators, error) {
 	indexURL, err := parseRepoURL(r.URL)
 	if err != nil {
 		log.WithFields(log.Fields{"url": r.URL}).WithError(err).Error("failed to parse URL")
-		return nil, err
+		return nil, indexValidators{}, err
 	}
 	indexURL.Path = path.Join(indexURL.Path, "index.yaml")
 	req, err := http.NewRequest("GET", indexURL.String(), nil)
 	if err != nil {
 		log.WithFields(log.Fields{"url": req.URL.String()}).WithError(err).Error("could not build repo index request")
-		return nil, err
+		return nil, indexValidators{}, err
 	}
 
 	req.Header.Set("User-Agent", userAgent())
-	if len(r.AuthorizationHeader) > 0 {
-		req.Header.Set("Authorization", r.AuthorizationHeader)
+	// Setting the header disables the transparent decompression of the
+	// transport, the response is decompressed below
+	req.Header.Set("Accept-Encoding", "gzip")
+	if cached.ETag != "" {
+		req.Header.Set("If-None-Match", cached.ETag)
 	}
-
-	res, err := netClient.Do(req)
-	if res != nil {
-		defer res.Body.Close()
+	if cached.LastModified != "" {
+		req.Header.Set("If-Modified-Since", cached.LastModified)
 	}
+
+	res, err := r.do(req)
 	if err != nil {
+		if res != nil {
+			res.Body.Close()
+		}
 		log.WithFields(log.Fields{"url": req.URL.String()}).WithError(err).Error("error requesting repo index")
-		return nil, err
+		return nil, indexValidators{}, err
 	}
 
+	if res.StatusCode == http.StatusNotModified {
+		res.Body.Close()
+		return nil, cached, errIndexNotModified
+	}
 	if res.StatusCode != http.StatusOK {
+		res.Body.Close()
 		log.WithFields(log.Fields{"url": req.URL.String(), "status": res.StatusCode}).Error("error requesting repo index, are you sure this is a chart repository?")
-		return nil, errors.New("repo index request failed")
+		return nil, indexValidators{}, errors.New("repo index request failed")
 	}
 
-	body, err := ioutil.ReadAll(res.Body)
-	if err != nil {
-		return nil, err
+	// Compressed or not, the index cannot be smaller than the response
+	if res.ContentLength > maxIndexSize {
+		res.Body.Close()
+		return nil, indexValidators{}, errIndexTooLarge
+	}
+
+	body := &indexBody{Reader: res.Body, closers: []io.Closer{res.Body}}
+	if strings.EqualFold(res.Header.Get("Content-Encoding"), "gzip") {
+		gzr, err := gzip.NewReader(res.Body)
+		if err != nil {
+			res.Body.Close()
+			return nil, indexValidators{}, err
+		}
+		body = &indexBody{Reader: gzr, closers: []io.Closer{gzr, res.Body}}
 	}
-	return body, nil
+	return body, indexValidators{ETag: res.Header.Get("ETag"), LastModified: res.Header.Get("Last-Modified")}, nil
 }
 
 func parseRepoIndex(body []byte) (*helmrepo.IndexFile, error) {
@@ -272,25 +515,17 @@ func parseRepoIndex(body []byte) (*helmrepo.IndexFile, error) {
 	return &index, nil
 }
 
-func chartsFromIndex(index *helmrepo.IndexFile, r repo, filter *filters) []chart {
-	var charts []chart
-	for _, entry := range index.Entries {
-		if entry[0].GetDeprecated() {
-			log.WithFields(log.Fields{"name": entry[0].GetName()}).Info("skipping deprecated chart")
-			continue
+// chartFromEntry returns the chart for an entry of the index, unless it does
+// not match the filters
+func chartFromEntry(entry helmrepo.ChartVersions, r repo, filter *filters) (chart, bool) {
+	if len(filter.Annotations) > 0 ||
+		len(filter.Names) > 0 {
+		if !filterEntry(entry[0], filter) {
+			log.WithFields(log.Fields{"name": entry[0].GetName()}).Info("skipping chart as filters did not match")
+			return chart{}, false
 		}
-
-		if len(filter.Annotations) > 0 ||
-			len(filter.Names) > 0 {
-			if !filterEntry(entry[0], filter) {
-				log.WithFields(log.Fields{"name": entry[0].GetName()}).Info("skipping chart as filters did not match")
-				continue
-			}
-		}
-
-		charts = append(charts, newChart(entry, r))
 	}
-	return charts
+	return newChart(entry, r), true
 }
 
 // Takes an entry from the index and constructs a database representation of the
@@ -301,14 +536,73 @@ func newChart(entry helmrepo.ChartVersions, r repo) chart {
 	copier.Copy(&c.ChartVersions, entry)
 	c.Repo = r
 	c.ID = fmt.Sprintf("%s/%s", r.Name, c.Name)
+	if c.Deprecated {
+		c.ReplacedBy = entry[0].GetAnnotations()[replacedByAnnotation]
+	}
+	for i := range c.ChartVersions {
+		if a, ok := entry[i].GetAnnotations()[changesAnnotation]; ok {
+			changes, err := parseChanges(a)
+			if err != nil {
+				log.WithFields(log.Fields{"name": c.Name, "version": c.ChartVersions[i].Version}).WithError(err).Info("invalid changes annotation")
+			}
+			c.ChartVersions[i].Changes = changes
+		}
+	}
 	return c
 }
 
-func importCharts(dbSession datastore.Session, charts []chart) error {
+// parseChanges parses the value of the changes annotation, which is either a
+// list of descriptions or a list of objects with a kind, description and links
+func parseChanges(annotation string) ([]change, error) {
+	var objects []change
+	if err := yaml.Unmarshal([]byte(annotation), &objects); err == nil {
+		return objects, nil
+	}
+	var descriptions []string
+	if err := yaml.Unmarshal([]byte(annotation), &descriptions); err != nil {
+		return nil, err
+	}
+	var changes []change
+	for _, d := range descriptions {
+		changes = append(changes, change{Description: d})
+	}
+	return changes, nil
+}
+
+// markYankedVersions flags the chart versions that have been yanked in the
+// repository and moves them after the rest of the versions of their chart
+func markYankedVersions(dbSession datastore.Session, repoName string, charts []chart) error {
+	db, closer := dbSession.DB()
+	defer closer()
+	var yanked []yankedVersion
+	if err := db.C(yankedCollection).Find(bson.M{"repo": repoName}).All(&yanked); err != nil {
+		return err
+	}
+	if len(yanked) == 0 {
+		return nil
+	}
+
+	isYanked := map[string]bool{}
+	for _, y := range yanked {
+		isYanked[y.Chart+"-"+y.Version] = true
+	}
+	for i := range charts {
+		c := &charts[i]
+		for j := range c.ChartVersions {
+			c.ChartVersions[j].Yanked = isYanked[c.ID+"-"+c.ChartVersions[j].Version]
+		}
+		sort.SliceStable(c.ChartVersions, func(a, b int) bool {
+			return !c.ChartVersions[a].Yanked && c.ChartVersions[b].Yanked
+		})
+	}
+	return nil
+}
+
+// importCharts writes the given charts and removes the charts of the
+// repository that are not in chartIDs
+func importCharts(dbSession datastore.Session, repoName string, charts []chart, chartIDs []string) error {
 	var pairs []interface{}
-	var chartIDs []string
 	for _, c := range charts {
-		chartIDs = append(chartIDs, c.ID)
 		// charts to upsert - pair of selector, chart
 		pairs = append(pairs, bson.M{"_id": c.ID}, c)
 	}
@@ -318,32 +612,173 @@ func importCharts(dbSession datastore.Session, charts []chart) error {
 	bulk := db.C(chartCollection).Bulk()
 
 	// Upsert pairs of selectors, charts
-	bulk.Upsert(pairs...)
+	if len(pairs) > 0 {
+		bulk.Upsert(pairs...)
+	}
 
 	// Remove charts no longer existing in index
 	bulk.RemoveAll(bson.M{
 		"_id": bson.M{
 			"$nin": chartIDs,
 		},
-		"repo.name": charts[0].Repo.Name,
+		"repo.name": repoName,
 	})
 
 	_, err := bulk.Run()
 	return err
 }
 
-func importWorker(dbSession datastore.Session, wg *sync.WaitGroup, icons <-chan chart, chartFiles <-chan importChartFilesJob) {
+// storedCharts returns the charts of the repository as stored in the database,
+// only the fields needed to find out what changed in the index are read
+func storedCharts(dbSession datastore.Session, repoName string) ([]chart, error) {
+	db, closer := dbSession.DB()
+	defer closer()
+	var stored []chart
+	err := db.C(chartCollection).Find(bson.M{"repo.name": repoName}).Select(bson.M{
+		"name": 1, "repo.url": 1, "chartversions.version": 1, "chartversions.digest": 1, "chartversions.yanked": 1,
+	}).All(&stored)
+	return stored, err
+}
+
+// chartFingerprint identifies the versions of a chart and where they are
+// served from. Chart metadata is read from the latest version so it is covered
+// by its digest.
+func chartFingerprint(c chart) string {
+	parts := []string{c.Repo.URL}
+	for _, cv := range c.ChartVersions {
+		parts = append(parts, cv.Version, cv.Digest, strconv.FormatBool(cv.Yanked))
+	}
+	return strings.Join(parts, "\x00")
+}
+
+// changedCharts returns the charts that are new or different from the stored
+// ones, along with their new or republished versions indexed by chart ID
+func changedCharts(stored, charts []chart) ([]chart, map[string][]chartVersion) {
+	storedByID := make(map[string]chart, len(stored))
+	for _, c := range stored {
+		storedByID[c.ID] = c
+	}
+	var changed []chart
+	versions := map[string][]chartVersion{}
+	for _, c := range charts {
+		sc, ok := storedByID[c.ID]
+		if ok && chartFingerprint(sc) == chartFingerprint(c) {
+			continue
+		}
+		changed = append(changed, c)
+		versions[c.ID] = changedVersions(sc, c)
+	}
+	return changed, versions
+}
+
+// changedVersions returns the versions of the chart that are new or have a
+// different digest than the stored ones
+func changedVersions(stored chart, c chart) []chartVersion {
+	digests := map[string]string{}
+	for _, cv := range stored.ChartVersions {
+		digests[cv.Version] = cv.Digest
+	}
+	var changed []chartVersion
+	for _, cv := range c.ChartVersions {
+		if d, ok := digests[cv.Version]; !ok || d != cv.Digest {
+			changed = append(changed, cv)
+		}
+	}
+	return changed
+}
+
+// chartIDs returns the IDs of the charts
+func chartIDs(charts []chart) []string {
+	var ids []string
+	for _, c := range charts {
+		ids = append(ids, c.ID)
+	}
+	return ids
+}
+
+// diffCharts returns the events needed to go from the existing charts to the
+// new ones. Adding a chart only produces an event for the chart, not for each
+// of its versions.
+func diffCharts(repoName string, existing, charts []chart, now time.Time) []event {
+	newEvent := func(eventType string, c chart, version string) event {
+		return event{ID: bson.NewObjectId(), Type: eventType, Repo: repoName, Chart: c.ID, Name: c.Name, Version: version, Time: now}
+	}
+
+	versions := map[string]map[string]bool{}
+	for _, c := range existing {
+		versions[c.ID] = map[string]bool{}
+		for _, cv := range c.ChartVersions {
+			versions[c.ID][cv.Version] = true
+		}
+	}
+
+	var events []event
+	inIndex := map[string]bool{}
+	for _, c := range charts {
+		inIndex[c.ID] = true
+		known, ok := versions[c.ID]
+		if !ok {
+			events = append(events, newEvent(chartAddedEvent, c, c.ChartVersions[0].Version))
+			continue
+		}
+		for _, cv := range c.ChartVersions {
+			if !known[cv.Version] {
+				events = append(events, newEvent(versionAddedEvent, c, cv.Version))
+			}
+		}
+	}
+	for _, c := range existing {
+		if !inIndex[c.ID] {
+			events = append(events, newEvent(chartRemovedEvent, c, ""))
+		}
+	}
+	return events
+}
+
+// recordEvents stores the events so they can be served by chartsvc
+func recordEvents(dbSession datastore.Session, events []event) error {
+	if len(events) == 0 {
+		return nil
+	}
+	db, closer := dbSession.DB()
+	defer closer()
+	docs := make([]interface{}, len(events))
+	for i, e := range events {
+		docs[i] = e
+	}
+	return db.C(eventsCollection).Insert(docs...)
+}
+
+// iconWorker imports chart icons, recording the ones that fail so they are
+// retried by the next sync, and clearing the previously failed ones that
+// succeed
+func iconWorker(dbSession datastore.Session, wg *sync.WaitGroup, icons <-chan chart, failed map[string]failedItem) {
 	defer wg.Done()
 	for c := range icons {
 		log.WithFields(log.Fields{"name": c.Name}).Debug("importing icon")
+		id := iconItemID(c)
 		if err := fetchAndImportIcon(dbSession, c); err != nil {
 			log.WithFields(log.Fields{"name": c.Name}).WithError(err).Error("failed to import icon")
+			recordFailedItem(dbSession, failedItem{ID: id, Repo: c.Repo.Name, Kind: iconItem, Chart: c.ID, Error: err.Error(), Time: time.Now()}, failed)
+		} else if _, ok := failed[id]; ok {
+			clearFailedItems(dbSession, []string{id})
 		}
 	}
+}
+
+// filesWorker imports the files of chart versions, recording the ones that
+// fail like iconWorker
+func filesWorker(dbSession datastore.Session, wg *sync.WaitGroup, chartFiles <-chan importChartFilesJob, failed map[string]failedItem) {
+	defer wg.Done()
 	for j := range chartFiles {
 		log.WithFields(log.Fields{"name": j.Name, "version": j.ChartVersion.Version}).Debug("importing readme and values")
+		chartID := j.Repo.Name + "/" + j.Name
+		id := filesItemID(chartID, j.ChartVersion.Version)
 		if err := fetchAndImportFiles(dbSession, j.Name, j.Repo, j.ChartVersion); err != nil {
 			log.WithFields(log.Fields{"name": j.Name, "version": j.ChartVersion.Version}).WithError(err).Error("failed to import files")
+			recordFailedItem(dbSession, failedItem{ID: id, Repo: j.Repo.Name, Kind: filesItem, Chart: chartID, Version: j.ChartVersion.Version, Error: err.Error(), Time: time.Now()}, failed)
+		} else if _, ok := failed[id]; ok {
+			clearFailedItems(dbSession, []string{id})
 		}
 	}
 }
@@ -359,11 +794,8 @@ func fetchAndImportIcon(dbSession datastore.Session, c chart) error {
 		return err
 	}
 	req.Header.Set("User-Agent", userAgent())
-	if len(c.Repo.AuthorizationHeader) > 0 {
-		req.Header.Set("Authorization", c.Repo.AuthorizationHeader)
-	}
 
-	res, err := netClient.Do(req)
+	res, err := c.Repo.do(req)
 	if res != nil {
 		defer res.Body.Close()
 	}
@@ -377,33 +809,42 @@ func fetchAndImportIcon(dbSession datastore.Session, c chart) error {
 
 	b := []byte{}
 	contentType := ""
+	var variants []iconVariant
 	if strings.Contains(res.Header.Get("Content-Type"), "image/svg") {
-		// if the icon is a SVG file simply read it
-		b, err = ioutil.ReadAll(res.Body)
+		// SVG icons are served as they are from our domain, so anything that
+		// could run a script or load a resource is removed
+		b, err = sanitizeSVG(res.Body)
 		if err != nil {
+			log.WithFields(log.Fields{"name": c.Name}).WithError(err).Error("failed to sanitize icon")
 			return err
 		}
-		contentType = res.Header.Get("Content-Type")
+		contentType = "image/svg+xml"
 	} else {
-		// if the icon is in any other format try to convert it to PNG
+		// if the icon is in any other format resize it to each of the
+		// configured sizes and formats
 		orig, err := imaging.Decode(res.Body)
 		if err != nil {
 			log.WithFields(log.Fields{"name": c.Name}).WithError(err).Error("failed to decode icon")
 			return err
 		}
-
-		// TODO: make this configurable?
-		icon := imaging.Fit(orig, 160, 160, imaging.Lanczos)
-
-		var buf bytes.Buffer
-		imaging.Encode(&buf, icon, imaging.PNG)
-		b = buf.Bytes()
+		if variants, err = iconVariants(orig); err != nil {
+			return err
+		}
+		if b, err = defaultIcon(orig, variants); err != nil {
+			return err
+		}
 		contentType = "image/png"
 	}
 
+	update, err := iconUpdate(b, contentType, variants)
+	if err != nil {
+		log.WithFields(log.Fields{"name": c.Name}).WithError(err).Error("failed to store icon")
+		return err
+	}
+
 	db, closer := dbSession.DB()
 	defer closer()
-	return db.C(chartCollection).UpdateId(c.ID, bson.M{"$set": bson.M{"raw_icon": b, "icon_content_type": contentType}})
+	return db.C(chartCollection).UpdateId(c.ID, update)
 }
 
 func fetchAndImportFiles(dbSession datastore.Session, name string, r repo, cv chartVersion) error {
@@ -411,11 +852,20 @@ func fetchAndImportFiles(dbSession datastore.Session, name string, r repo, cv ch
 	db, closer := dbSession.DB()
 	defer closer()
 
-	// Check if we already have indexed files for this chart version and digest
-	if err := db.C(chartFilesCollection).Find(bson.M{"_id": chartFilesID, "digest": cv.Digest}).One(&chartFiles{}); err == nil {
+	// Check if we already have indexed files for this chart version and digest,
+	// imported by this version of chart-repo
+	if err := db.C(chartFilesCollection).Find(bson.M{"_id": chartFilesID, "digest": cv.Digest, "files_version": chartFilesVersion}).One(&chartFiles{}); err == nil {
 		log.WithFields(log.Fields{"name": name, "version": cv.Version}).Debug("skipping existing files")
 		return nil
 	}
+	// The same chart version is often mirrored in several repositories, its
+	// files are only fetched once
+	if content, ok := sharedContent(db, cv.Digest); ok {
+		log.WithFields(log.Fields{"name": name, "version": cv.Version}).Debug("sharing existing files")
+		chartFiles := chartFiles{ID: chartFilesID, Repo: r, Digest: cv.Digest, Changes: cv.Changes, Signed: chartVersionSigned(r, cv), Shared: true, CRDs: content.CRDs, Images: content.Images, ImagesError: content.ImagesError, Chart: name, Version: cv.Version, FilesVersion: chartFilesVersion}
+		_, err := db.C(chartFilesCollection).UpsertId(chartFilesID, chartFiles)
+		return err
+	}
 	log.WithFields(log.Fields{"name": name, "version": cv.Version}).Debug("fetching files")
 
 	url := chartTarballURL(r, cv)
@@ -424,20 +874,22 @@ func fetchAndImportFiles(dbSession datastore.Session, name string, r repo, cv ch
 		return err
 	}
 	req.Header.Set("User-Agent", userAgent())
-	if len(r.AuthorizationHeader) > 0 {
-		req.Header.Set("Authorization", r.AuthorizationHeader)
-	}
 
-	res, err := netClient.Do(req)
+	res, err := r.do(req)
 	if err != nil {
 		return err
 	}
-	defer res.Body.Close()
 
 	// We read the whole chart into memory, this should be okay since the chart
 	// tarball needs to be small enough to fit into a GRPC call (Tiller
-	// requirement)
-	gzf, err := gzip.NewReader(res.Body)
+	// requirement). The body is closed right away since it holds a slot of the
+	// host, needed by the request of the provenance file.
+	tarball, err := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if err != nil {
+		return err
+	}
+	gzf, err := gzip.NewReader(bytes.NewReader(tarball))
 	if err != nil {
 		return err
 	}
@@ -448,14 +900,15 @@ func fetchAndImportFiles(dbSession datastore.Session, name string, r repo, cv ch
 	readmeFileName := name + "/README.md"
 	valuesFileName := name + "/values.yaml"
 	schemaFileName := name + "/values.schema.json"
-	filenames := []string{valuesFileName, readmeFileName, schemaFileName}
+	changelogFileName := name + "/CHANGELOG.md"
+	filenames := []string{valuesFileName, readmeFileName, schemaFileName, changelogFileName}
 
-	files, err := extractFilesFromTarball(filenames, tarf)
+	files, listing, err := extractFilesFromTarball(filenames, tarf)
 	if err != nil {
 		return err
 	}
 
-	chartFiles := chartFiles{ID: chartFilesID, Repo: r, Digest: cv.Digest}
+	chartFiles := chartFiles{ID: chartFilesID, Repo: r, Digest: cv.Digest, Changes: cv.Changes, Chart: name, Version: cv.Version, FilesVersion: chartFilesVersion}
 	if v, ok := files[readmeFileName]; ok {
 		chartFiles.Readme = v
 	} else {
@@ -471,35 +924,91 @@ func fetchAndImportFiles(dbSession datastore.Session, name string, r repo, cv ch
 	} else {
 		log.WithFields(log.Fields{"name": name, "version": cv.Version}).Info("values.schema.json not found")
 	}
+	// The changelog is optional so it is not logged when missing
+	chartFiles.Changelog = files[changelogFileName]
+	chartFiles.Files = budgetFileContents(chartFileListing(name, listing))
+	chartFiles.CRDs = chartCRDs(name, cv.Version, files)
+	if chartFiles.Images, err = chartImages(tarball); err != nil {
+		log.WithFields(log.Fields{"name": name, "version": cv.Version}).WithError(err).Warn("could not render templates to find images")
+		chartFiles.ImagesError = err.Error()
+	}
+	chartFiles.Signed = chartVersionSigned(r, cv)
+	if err := storeFileBlobs(&chartFiles, tarball); err != nil {
+		log.WithFields(log.Fields{"name": name, "version": cv.Version}).WithError(err).Error("failed to store files")
+		return err
+	}
+
+	// Only the files of tarballs matching their digest are shared, otherwise a
+	// repository could replace the files of charts of other repositories
+	if digestMatches(cv.Digest, tarball) {
+		content := shareContent(&chartFiles)
+		if _, err := db.C(chartContentsCollection).UpsertId(content.ID, content); err != nil {
+			return err
+		}
+	} else {
+		log.WithFields(log.Fields{"name": name, "version": cv.Version, "digest": cv.Digest}).Warn("tarball does not match its digest, its files are not shared")
+	}
 
 	// inserts the chart files if not already indexed, or updates the existing
 	// entry if digest has changed
-	db.C(chartFilesCollection).UpsertId(chartFilesID, chartFiles)
-
-	return nil
+	_, err = db.C(chartFilesCollection).UpsertId(chartFilesID, chartFiles)
+	return err
 }
 
-func extractFilesFromTarball(filenames []string, tarf *tar.Reader) (map[string]string, error) {
+// extractFilesFromTarball returns the content of the given files and of the
+// CRDs, see crds.go, and the listing of all the files of the tarball, see
+// listing.go
+func extractFilesFromTarball(filenames []string, tarf *tar.Reader) (map[string]string, []chartFile, error) {
 	ret := make(map[string]string)
+	var listing []chartFile
 	for {
 		header, err := tarf.Next()
 		if err == io.EOF {
 			break
 		}
 		if err != nil {
-			return ret, err
+			return ret, listing, err
+		}
+		if !header.FileInfo().Mode().IsRegular() {
+			continue
 		}
 
+		wanted := ""
 		for _, f := range filenames {
 			if strings.EqualFold(header.Name, f) {
-				var b bytes.Buffer
-				io.Copy(&b, tarf)
-				ret[f] = string(b.Bytes())
+				wanted = f
 				break
 			}
 		}
+		if wanted == "" && isCRDFile(header.Name) {
+			wanted = header.Name
+		}
+		var b bytes.Buffer
+		if wanted != "" || storesFile(header) {
+			io.Copy(&b, tarf)
+		}
+		if wanted != "" {
+			ret[wanted] = string(b.Bytes())
+		}
+		listing = append(listing, listChartFile(header, b.Bytes()))
+	}
+	return ret, listing, nil
+}
+
+// chartVersionSigned returns true if the repository serves a provenance file
+// for the chart version
+func chartVersionSigned(r repo, cv chartVersion) bool {
+	req, err := http.NewRequest("HEAD", chartTarballURL(r, cv)+".prov", nil)
+	if err != nil {
+		return false
+	}
+	req.Header.Set("User-Agent", userAgent())
+
+	res, err := r.do(req)
+	if res != nil {
+		defer res.Body.Close()
 	}
-	return ret, nil
+	return err == nil && res.StatusCode == http.StatusOK
 }
 
 func chartTarballURL(r repo, cv chartVersion) string {
@@ -537,7 +1046,7 @@ func initNetClient(additionalCA string) (*http.Client, error) {
 
 	// Return Transport for testing purposes
 	return &http.Client{
-		Timeout: time.Second * defaultTimeoutSeconds,
+		Timeout: requestTimeout,
 		Transport: &http.Transport{
 			TLSClientConfig: &tls.Config{
 				RootCAs: caCertPool,
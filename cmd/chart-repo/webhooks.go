@@ -0,0 +1,296 @@
+/*
+Copyright (c) 2018 The Helm Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/globalsign/mgo"
+	"github.com/globalsign/mgo/bson"
+	"github.com/kubeapps/common/datastore"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	webhooksCollection          = "webhooks"
+	webhookDeliveriesCollection = "webhook_deliveries"
+	// webhookMaxAttempts is the number of times a delivery is attempted before
+	// giving up
+	webhookMaxAttempts = 3
+	// webhookLease is how long a delivery claimed by a process is not
+	// attempted by the others, it only expires if the process died
+	webhookLease = 10 * time.Minute
+)
+
+var (
+	// webhookRetryDelay is the delay before the first retry of a failed
+	// delivery, it doubles with each attempt
+	webhookRetryDelay = 30 * time.Second
+	// webhookDeliveryInterval is how often the serve command attempts the
+	// pending deliveries
+	webhookDeliveryInterval = 10 * time.Second
+)
+
+// matches returns true if the event should be delivered to the webhook
+func (wh webhook) matches(e event) bool {
+	if len(wh.Events) > 0 {
+		found := false
+		for _, t := range wh.Events {
+			found = found || t == e.Type
+		}
+		if !found {
+			return false
+		}
+	}
+	if wh.Repo != "" {
+		if matched, _ := filepath.Match(wh.Repo, e.Repo); !matched {
+			return false
+		}
+	}
+	if wh.Chart != "" {
+		if matched, _ := filepath.Match(wh.Chart, e.Name); !matched {
+			return false
+		}
+	}
+	return true
+}
+
+// webhookSignature returns the value of the X-Monocular-Signature header, the
+// hex encoded HMAC-SHA256 of the payload keyed with the webhook secret
+func webhookSignature(secret string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// queueWebhookDeliveries queues the deliveries of the events to the webhooks
+// subscribed to them. They are sent by deliverPendingWebhooks so a slow
+// endpoint does not delay the sync.
+func queueWebhookDeliveries(dbSession datastore.Session, events []event, now time.Time) error {
+	if len(events) == 0 {
+		return nil
+	}
+	db, closer := dbSession.DB()
+	defer closer()
+	var webhooks []webhook
+	if err := db.C(webhooksCollection).Find(bson.M{}).All(&webhooks); err != nil {
+		return err
+	}
+	for _, wh := range webhooks {
+		for _, e := range events {
+			if !wh.matches(e) {
+				continue
+			}
+			d := webhookDelivery{ID: bson.NewObjectId(), Webhook: wh.ID, Event: e.ID, URL: wh.URL, Pending: true, NextAttempt: now, Time: now}
+			if err := db.C(webhookDeliveriesCollection).Insert(d); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// claimWebhookDelivery leases the delivery to the caller until the lease
+// expires or the delivery is updated. It returns false if another process
+// holds the lease or already attempted the delivery.
+func claimWebhookDelivery(db datastore.Database, d webhookDelivery, now time.Time) (bool, error) {
+	// Deliveries are never removed, the upsert fails with a duplicate key
+	// instead of inserting a document if the selector does not match
+	_, err := db.C(webhookDeliveriesCollection).Upsert(bson.M{
+		"_id":      d.ID,
+		"pending":  true,
+		"attempts": d.Attempts,
+		"$or": []bson.M{
+			{"lease_expiry": bson.M{"$exists": false}},
+			{"lease_expiry": bson.M{"$lte": now}},
+		},
+	}, bson.M{"$set": bson.M{"lease_expiry": now.Add(webhookLease)}})
+	if mgo.IsDup(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// deliverPendingWebhooks attempts the queued deliveries that are due. Failed
+// deliveries are attempted again by a later call, with an exponential backoff,
+// and the deliveries of a webhook are sent in order. Each delivery is claimed
+// before being attempted so concurrent syncs do not send it twice.
+func deliverPendingWebhooks(dbSession datastore.Session, now time.Time) error {
+	db, closer := dbSession.DB()
+	defer closer()
+	var pending []webhookDelivery
+	if err := db.C(webhookDeliveriesCollection).Find(bson.M{"pending": true}).Sort("_id").All(&pending); err != nil {
+		return err
+	}
+	if len(pending) == 0 {
+		return nil
+	}
+	var webhookIDs, eventIDs []bson.ObjectId
+	byWebhook := map[bson.ObjectId][]webhookDelivery{}
+	for _, d := range pending {
+		if _, ok := byWebhook[d.Webhook]; !ok {
+			webhookIDs = append(webhookIDs, d.Webhook)
+		}
+		byWebhook[d.Webhook] = append(byWebhook[d.Webhook], d)
+		eventIDs = append(eventIDs, d.Event)
+	}
+	var webhooks []webhook
+	if err := db.C(webhooksCollection).Find(bson.M{"_id": bson.M{"$in": webhookIDs}}).All(&webhooks); err != nil {
+		return err
+	}
+	var events []event
+	if err := db.C(eventsCollection).Find(bson.M{"_id": bson.M{"$in": eventIDs}}).All(&events); err != nil {
+		return err
+	}
+	webhookByID := map[bson.ObjectId]webhook{}
+	for _, wh := range webhooks {
+		webhookByID[wh.ID] = wh
+	}
+	eventByID := map[bson.ObjectId]event{}
+	for _, e := range events {
+		eventByID[e.ID] = e
+	}
+
+	var wg sync.WaitGroup
+	for id, deliveries := range byWebhook {
+		wg.Add(1)
+		// Each webhook is delivered concurrently so a slow endpoint does not
+		// delay the others
+		go func(wh webhook, found bool, deliveries []webhookDelivery) {
+			defer wg.Done()
+			for _, d := range deliveries {
+				// the next events wait for the delivery being retried
+				if d.NextAttempt.After(now) {
+					return
+				}
+				claimed, err := claimWebhookDelivery(db, d, now)
+				if err != nil {
+					log.WithFields(log.Fields{"url": d.URL, "event": d.Event.Hex()}).WithError(err).Error("failed to claim webhook delivery")
+				}
+				if !claimed {
+					return
+				}
+				d.LeaseExpiry = time.Time{}
+				e, ok := eventByID[d.Event]
+				switch {
+				case !found:
+					d.Pending, d.Error = false, "webhook deleted"
+				case !ok:
+					d.Pending, d.Error = false, "event not found"
+				default:
+					d = attemptWebhookDelivery(wh, e, d, now)
+				}
+				if !d.Delivered {
+					log.WithFields(log.Fields{"url": d.URL, "event": d.Event.Hex(), "attempts": d.Attempts, "pending": d.Pending}).Errorf("failed to deliver webhook: %s", d.Error)
+				}
+				if err := db.C(webhookDeliveriesCollection).UpdateId(d.ID, d); err != nil {
+					log.WithFields(log.Fields{"url": d.URL, "event": d.Event.Hex()}).WithError(err).Error("failed to log webhook delivery")
+				}
+				if d.Pending {
+					return
+				}
+			}
+		}(webhookByID[id], webhookByID[id].ID != "", deliveries)
+	}
+	wg.Wait()
+	return nil
+}
+
+// runWebhookDeliveries attempts the pending deliveries every interval until
+// stop is closed
+func runWebhookDeliveries(dbSession datastore.Session, interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case now := <-ticker.C:
+			if err := deliverPendingWebhooks(dbSession, now); err != nil {
+				log.WithError(err).Error("failed to deliver webhooks")
+			}
+		}
+	}
+}
+
+// attemptWebhookDelivery posts the event to the webhook once. Deliveries
+// failing with a network error or a 5xx response stay pending until they are
+// attempted webhookMaxAttempts times, with an exponential backoff.
+func attemptWebhookDelivery(wh webhook, e event, d webhookDelivery, now time.Time) webhookDelivery {
+	d.Attempts++
+	d.Time = now
+	d.Pending = false
+	payload, err := json.Marshal(e)
+	if err != nil {
+		d.Error = err.Error()
+		return d
+	}
+	d.StatusCode, err = postWebhook(wh, e, d.ID, payload)
+	if err == nil && d.StatusCode >= 200 && d.StatusCode < 300 {
+		d.Delivered = true
+		d.Error = ""
+		return d
+	}
+	if err != nil {
+		d.Error = err.Error()
+	} else {
+		d.Error = fmt.Sprintf("unexpected status code %d", d.StatusCode)
+		// Client errors will not be fixed by retrying
+		if d.StatusCode < 500 {
+			return d
+		}
+	}
+	if d.Attempts < webhookMaxAttempts {
+		d.Pending = true
+		d.NextAttempt = now.Add(webhookRetryDelay << uint(d.Attempts-1))
+	}
+	return d
+}
+
+func postWebhook(wh webhook, e event, deliveryID bson.ObjectId, payload []byte) (int, error) {
+	req, err := http.NewRequest("POST", wh.URL, bytes.NewReader(payload))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", userAgent())
+	req.Header.Set("X-Monocular-Event", e.Type)
+	req.Header.Set("X-Monocular-Delivery", deliveryID.Hex())
+	if wh.Secret != "" {
+		req.Header.Set("X-Monocular-Signature", webhookSignature(wh.Secret, payload))
+	}
+
+	res, err := netClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+	// Drain the body so the connection can be reused
+	io.Copy(ioutil.Discard, res.Body)
+	return res.StatusCode, nil
+}
@@ -0,0 +1,289 @@
+/*
+Copyright (c) 2018 The Helm Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/arschles/assert"
+	"github.com/globalsign/mgo"
+	"github.com/globalsign/mgo/bson"
+	"github.com/kubeapps/common/datastore"
+	"github.com/kubeapps/common/datastore/mockstore"
+	"github.com/stretchr/testify/mock"
+)
+
+// webhookClient records the webhook requests and replies with the given status
+// codes in order
+type webhookClient struct {
+	sync.Mutex
+	statusCodes []int
+	requests    []*http.Request
+	bodies      [][]byte
+}
+
+func (h *webhookClient) Do(req *http.Request) (*http.Response, error) {
+	h.Lock()
+	defer h.Unlock()
+	body, _ := ioutil.ReadAll(req.Body)
+	h.requests = append(h.requests, req)
+	h.bodies = append(h.bodies, body)
+	w := httptest.NewRecorder()
+	code := h.statusCodes[0]
+	if len(h.statusCodes) > 1 {
+		h.statusCodes = h.statusCodes[1:]
+	}
+	w.WriteHeader(code)
+	return w.Result(), nil
+}
+
+func Test_webhookMatches(t *testing.T) {
+	e := event{Type: versionAddedEvent, Repo: "stable", Name: "wordpress"}
+	tests := []struct {
+		name    string
+		webhook webhook
+		want    bool
+	}{
+		{"match all", webhook{}, true},
+		{"repo pattern", webhook{Repo: "sta*"}, true},
+		{"other repo", webhook{Repo: "incubator"}, false},
+		{"chart pattern", webhook{Repo: "stable", Chart: "word*"}, true},
+		{"other chart", webhook{Chart: "mariadb"}, false},
+		{"event type", webhook{Events: []string{chartRemovedEvent, versionAddedEvent}}, true},
+		{"other event type", webhook{Events: []string{chartRemovedEvent}}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.webhook.matches(e), tt.want, "matches")
+		})
+	}
+}
+
+func Test_attemptWebhookDelivery(t *testing.T) {
+	wh := webhook{ID: bson.NewObjectId(), URL: "https://hooks.example.com/monocular", Secret: "s3cr3t"}
+	e := event{ID: bson.NewObjectId(), Type: versionAddedEvent, Repo: "stable", Chart: "stable/wordpress", Name: "wordpress", Version: "0.7.5", Time: time.Now().UTC()}
+	now := time.Now()
+
+	tests := []struct {
+		name          string
+		statusCodes   []int
+		wantAttempts  int
+		wantDelivered bool
+	}{
+		{"delivered", []int{200}, 1, true},
+		{"delivered after retrying", []int{503, 502, 204}, 3, true},
+		{"server errors", []int{500}, webhookMaxAttempts, false},
+		{"client error is not retried", []int{410}, 1, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := &webhookClient{statusCodes: tt.statusCodes}
+			netClient = client
+			d := webhookDelivery{ID: bson.NewObjectId(), Webhook: wh.ID, Event: e.ID, URL: wh.URL, Pending: true, NextAttempt: now}
+			at := now
+			for d.Pending {
+				assert.False(t, d.NextAttempt.Before(at), "next attempt")
+				at = d.NextAttempt
+				d = attemptWebhookDelivery(wh, e, d, at)
+			}
+			assert.Equal(t, d.Attempts, tt.wantAttempts, "attempts")
+			assert.Equal(t, d.Delivered, tt.wantDelivered, "delivered")
+			assert.Equal(t, len(client.requests), tt.wantAttempts, "requests")
+			if tt.wantAttempts > 1 {
+				assert.Equal(t, at, now.Add(webhookRetryDelay*time.Duration(1<<uint(tt.wantAttempts-1)-1)), "backoff")
+			}
+
+			req := client.requests[0]
+			assert.Equal(t, req.Method, "POST", "method")
+			assert.Equal(t, req.Header.Get("X-Monocular-Event"), versionAddedEvent, "event header")
+			assert.Equal(t, req.Header.Get("X-Monocular-Delivery"), d.ID.Hex(), "delivery header")
+			assert.Equal(t, req.Header.Get("X-Monocular-Signature"), webhookSignature(wh.Secret, client.bodies[0]), "signature")
+			var payload event
+			assert.NoErr(t, json.Unmarshal(client.bodies[0], &payload))
+			assert.Equal(t, payload, e, "payload")
+		})
+	}
+}
+
+func Test_webhookSignature(t *testing.T) {
+	// echo -n '{"type":"chart_added"}' | openssl dgst -sha256 -hmac s3cr3t
+	assert.Equal(t, webhookSignature("s3cr3t", []byte(`{"type":"chart_added"}`)), "sha256=b036cd7aca7e6a87afd06b237d3782a784293eec0a0b8c8ed8b43ab497e2e2eb", "signature")
+}
+
+func Test_queueWebhookDeliveries(t *testing.T) {
+	events := []event{
+		{ID: bson.NewObjectId(), Type: versionAddedEvent, Repo: "stable", Name: "wordpress"},
+		{ID: bson.NewObjectId(), Type: chartRemovedEvent, Repo: "stable", Name: "mariadb"},
+	}
+	wh := webhook{ID: bson.NewObjectId(), URL: "https://hooks.example.com", Chart: "wordpress"}
+	m := &mock.Mock{}
+	m.On("All", mock.Anything).Run(func(args mock.Arguments) {
+		*args.Get(0).(*[]webhook) = []webhook{wh}
+	})
+	m.On("Insert", mock.Anything)
+	dbSession := mockstore.NewMockSession(m)
+	client := &webhookClient{statusCodes: []int{200}}
+	netClient = client
+
+	now := time.Now()
+	assert.NoErr(t, queueWebhookDeliveries(dbSession, events, now))
+	m.AssertNumberOfCalls(t, "Insert", 1)
+	d := m.Calls[1].Arguments.Get(0).(webhookDelivery)
+	assert.Equal(t, d, webhookDelivery{ID: d.ID, Webhook: wh.ID, Event: events[0].ID, URL: wh.URL, Pending: true, NextAttempt: now, Time: now}, "queued delivery")
+	assert.Equal(t, len(client.requests), 0, "requests")
+}
+
+func Test_deliverPendingWebhooks(t *testing.T) {
+	now := time.Now()
+	wh := webhook{ID: bson.NewObjectId(), URL: "https://hooks.example.com"}
+	events := []event{
+		{ID: bson.NewObjectId(), Type: versionAddedEvent, Repo: "stable", Name: "wordpress"},
+		{ID: bson.NewObjectId(), Type: versionAddedEvent, Repo: "stable", Name: "mariadb"},
+	}
+	pending := []webhookDelivery{
+		{ID: bson.NewObjectId(), Webhook: wh.ID, Event: events[0].ID, URL: wh.URL, Pending: true, NextAttempt: now},
+		{ID: bson.NewObjectId(), Webhook: wh.ID, Event: events[1].ID, URL: wh.URL, Pending: true, NextAttempt: now},
+		{ID: bson.NewObjectId(), Webhook: bson.NewObjectId(), Event: events[0].ID, URL: "https://deleted.example.com", Pending: true, NextAttempt: now},
+	}
+
+	tests := []struct {
+		name        string
+		statusCodes []int
+		want        []webhookDelivery
+	}{
+		{"delivered in order", []int{200}, []webhookDelivery{
+			{ID: pending[0].ID, Webhook: wh.ID, Event: events[0].ID, URL: wh.URL, Attempts: 1, StatusCode: 200, Delivered: true, Time: now, NextAttempt: now},
+			{ID: pending[1].ID, Webhook: wh.ID, Event: events[1].ID, URL: wh.URL, Attempts: 1, StatusCode: 200, Delivered: true, Time: now, NextAttempt: now},
+		}},
+		{"later events wait for the retry", []int{503}, []webhookDelivery{
+			{ID: pending[0].ID, Webhook: wh.ID, Event: events[0].ID, URL: wh.URL, Attempts: 1, StatusCode: 503, Error: "unexpected status code 503", Time: now, Pending: true, NextAttempt: now.Add(webhookRetryDelay)},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &mock.Mock{}
+			var deliveries []webhookDelivery
+			m.On("All", &deliveries).Run(func(args mock.Arguments) {
+				*args.Get(0).(*[]webhookDelivery) = pending
+			})
+			var webhooks []webhook
+			m.On("All", &webhooks).Run(func(args mock.Arguments) {
+				*args.Get(0).(*[]webhook) = []webhook{wh}
+			})
+			var evts []event
+			m.On("All", &evts).Run(func(args mock.Arguments) {
+				*args.Get(0).(*[]event) = events
+			})
+			m.On("UpdateId", mock.Anything, mock.Anything)
+			dbSession := mockstore.NewMockSession(m)
+			netClient = &webhookClient{statusCodes: tt.statusCodes}
+
+			assert.NoErr(t, deliverPendingWebhooks(dbSession, now))
+			updates := map[bson.ObjectId]webhookDelivery{}
+			for _, c := range m.Calls {
+				if c.Method == "UpdateId" {
+					updates[c.Arguments.Get(0).(bson.ObjectId)] = c.Arguments.Get(1).(webhookDelivery)
+				}
+			}
+			assert.Equal(t, len(updates), len(tt.want)+1, "updated deliveries")
+			for _, d := range tt.want {
+				assert.Equal(t, updates[d.ID], d, "delivery")
+			}
+			deleted := updates[pending[2].ID]
+			assert.Equal(t, deleted.Pending, false, "delivery to a deleted webhook")
+			assert.Equal(t, deleted.Error, "webhook deleted", "error of a delivery to a deleted webhook")
+		})
+	}
+}
+
+// claimedSession fails the claims of the deliveries held by another process
+// with a duplicate key error, as MongoDB does
+type claimedSession struct {
+	datastore.Session
+	held map[bson.ObjectId]bool
+}
+
+type claimedDatabase struct {
+	datastore.Database
+	held map[bson.ObjectId]bool
+}
+
+type claimedCollection struct {
+	datastore.Collection
+	held map[bson.ObjectId]bool
+}
+
+func (s claimedSession) DB() (datastore.Database, func()) {
+	db, closer := s.Session.DB()
+	return claimedDatabase{db, s.held}, closer
+}
+
+func (db claimedDatabase) C(name string) datastore.Collection {
+	return claimedCollection{db.Database.C(name), db.held}
+}
+
+func (c claimedCollection) Upsert(selector, update interface{}) (*mgo.ChangeInfo, error) {
+	if c.held[selector.(bson.M)["_id"].(bson.ObjectId)] {
+		return nil, &mgo.LastError{Code: 11000, Err: "duplicate key error"}
+	}
+	return &mgo.ChangeInfo{Updated: 1}, nil
+}
+
+func Test_deliverPendingWebhooksClaimed(t *testing.T) {
+	now := time.Now()
+	wh := webhook{ID: bson.NewObjectId(), URL: "https://hooks.example.com"}
+	other := webhook{ID: bson.NewObjectId(), URL: "https://other.example.com"}
+	e := event{ID: bson.NewObjectId(), Type: versionAddedEvent, Repo: "stable", Name: "wordpress"}
+	pending := []webhookDelivery{
+		{ID: bson.NewObjectId(), Webhook: wh.ID, Event: e.ID, URL: wh.URL, Pending: true, NextAttempt: now},
+		{ID: bson.NewObjectId(), Webhook: wh.ID, Event: e.ID, URL: wh.URL, Pending: true, NextAttempt: now},
+		{ID: bson.NewObjectId(), Webhook: other.ID, Event: e.ID, URL: other.URL, Pending: true, NextAttempt: now, LeaseExpiry: now.Add(-time.Minute)},
+	}
+
+	m := &mock.Mock{}
+	var deliveries []webhookDelivery
+	m.On("All", &deliveries).Run(func(args mock.Arguments) {
+		*args.Get(0).(*[]webhookDelivery) = pending
+	})
+	var webhooks []webhook
+	m.On("All", &webhooks).Run(func(args mock.Arguments) {
+		*args.Get(0).(*[]webhook) = []webhook{wh, other}
+	})
+	var evts []event
+	m.On("All", &evts).Run(func(args mock.Arguments) {
+		*args.Get(0).(*[]event) = []event{e}
+	})
+	m.On("UpdateId", mock.Anything, mock.Anything)
+	// the first delivery of wh is being attempted by another sync
+	dbSession := claimedSession{mockstore.NewMockSession(m), map[bson.ObjectId]bool{pending[0].ID: true}}
+	client := &webhookClient{statusCodes: []int{200}}
+	netClient = client
+
+	assert.NoErr(t, deliverPendingWebhooks(dbSession, now))
+	assert.Equal(t, len(client.requests), 1, "requests")
+	assert.Equal(t, client.requests[0].URL.String(), other.URL, "delivered webhook")
+	m.AssertNumberOfCalls(t, "UpdateId", 1)
+	d := m.Calls[len(m.Calls)-1].Arguments.Get(1).(webhookDelivery)
+	assert.Equal(t, d.ID, pending[2].ID, "updated delivery")
+	assert.True(t, d.LeaseExpiry.IsZero(), "lease released")
+}
@@ -0,0 +1,146 @@
+/*
+Copyright (c) 2018 The Helm Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Masterminds/semver"
+	"github.com/globalsign/mgo"
+	"github.com/globalsign/mgo/bson"
+	"github.com/helm/monocular/cmd/chartsvc/models"
+	"github.com/kubeapps/common/response"
+	log "github.com/sirupsen/logrus"
+)
+
+// requireAdmin only lets requests authenticated with the admin token through
+func requireAdmin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if adminToken == "" {
+			response.NewErrorResponse(http.StatusForbidden, "admin API is disabled").Write(w)
+			return
+		}
+		token := strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(token), []byte(adminToken)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			response.NewErrorResponse(http.StatusUnauthorized, "invalid admin token").Write(w)
+			return
+		}
+		next.ServeHTTP(w, req)
+	})
+}
+
+// yankChartVersion withdraws a chart version so it is no longer resolved as the
+// latest version of the chart. The version can still be fetched directly.
+func yankChartVersion(w http.ResponseWriter, req *http.Request, params Params) {
+	setChartVersionYanked(w, params, true)
+}
+
+// unyankChartVersion restores a previously yanked chart version
+func unyankChartVersion(w http.ResponseWriter, req *http.Request, params Params) {
+	setChartVersionYanked(w, params, false)
+}
+
+// sortChartVersions orders the versions the way chart-repo does when syncing:
+// newest semver first, versions that are not semver after the rest, and
+// yanked versions last so they are never the latest version
+func sortChartVersions(versions []models.ChartVersion) {
+	sort.SliceStable(versions, func(i, j int) bool {
+		if versions[i].Yanked != versions[j].Yanked {
+			return versions[j].Yanked
+		}
+		vi, err := semver.NewVersion(versions[i].Version)
+		if err != nil {
+			return false
+		}
+		vj, err := semver.NewVersion(versions[j].Version)
+		if err != nil {
+			return true
+		}
+		return vj.LessThan(vi)
+	})
+}
+
+func setChartVersionYanked(w http.ResponseWriter, params Params, yanked bool) {
+	db, closer := dbSession.DB()
+	defer closer()
+	var chart models.Chart
+	chartID := fmt.Sprintf("%s/%s", params["repo"], params["chartName"])
+	if err := db.C(chartCollection).FindId(chartID).One(&chart); err != nil {
+		log.WithError(err).Errorf("could not find chart with id %s", chartID)
+		response.NewErrorResponse(http.StatusNotFound, "could not find chart").Write(w)
+		return
+	}
+
+	found := false
+	for i := range chart.ChartVersions {
+		if chart.ChartVersions[i].Version == params["version"] {
+			chart.ChartVersions[i].Yanked = yanked
+			found = true
+		}
+	}
+	if !found {
+		response.NewErrorResponse(http.StatusNotFound, "could not find chart version").Write(w)
+		return
+	}
+
+	// The yanked versions are stored separately so chart-repo can restore them
+	// when the chart is synced again
+	yankedID := fmt.Sprintf("%s-%s", chartID, params["version"])
+	var err error
+	if yanked {
+		_, err = db.C(yankedCollection).UpsertId(yankedID, models.YankedVersion{
+			ID: yankedID, Repo: params["repo"], Chart: chartID, Version: params["version"],
+		})
+	} else if err = db.C(yankedCollection).Remove(bson.M{"_id": yankedID}); err == mgo.ErrNotFound {
+		err = nil
+	}
+	if err != nil {
+		log.WithError(err).Errorf("could not update yanked version %s", yankedID)
+		response.NewErrorResponse(http.StatusInternalServerError, "could not update chart version").Write(w)
+		return
+	}
+
+	sortChartVersions(chart.ChartVersions)
+	if err := db.C(chartCollection).UpdateId(chartID, bson.M{"$set": bson.M{"chartversions": chart.ChartVersions}}); err != nil {
+		log.WithError(err).Errorf("could not update chart with id %s", chartID)
+		response.NewErrorResponse(http.StatusInternalServerError, "could not update chart version").Write(w)
+		return
+	}
+
+	// The lists of charts of the repository change without a sync, their
+	// validators are based on its revision
+	if err := db.C(repositoryCollection).UpdateId(params["repo"], bson.M{
+		"$inc": bson.M{"revision": 1},
+		"$set": bson.M{"last_change": time.Now()},
+	}); err != nil {
+		log.WithError(err).Errorf("could not update revision of repository %s", params["repo"])
+	}
+
+	var cv models.ChartVersion
+	for _, v := range chart.ChartVersions {
+		if v.Version == params["version"] {
+			cv = v
+		}
+	}
+	response.NewDataResponse(newChartVersionResponse(&chart, cv)).Write(w)
+}
@@ -0,0 +1,157 @@
+/*
+Copyright (c) 2018 The Helm Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/globalsign/mgo/bson"
+	"github.com/helm/monocular/cmd/chartsvc/models"
+	"github.com/kubeapps/common/datastore/mockstore"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func Test_requireAdmin(t *testing.T) {
+	tests := []struct {
+		name          string
+		token         string
+		authorization string
+		wantCode      int
+	}{
+		{"admin API disabled", "", "Bearer ", http.StatusForbidden},
+		{"missing token", "secret", "", http.StatusUnauthorized},
+		{"invalid token", "secret", "Bearer wrong", http.StatusUnauthorized},
+		{"valid token", "secret", "Bearer secret", http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			adminToken = tt.token
+			defer func() { adminToken = "" }()
+
+			h := requireAdmin(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {}))
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest("PUT", "/charts/my-repo/my-chart/versions/0.1.0/yank", nil)
+			if tt.authorization != "" {
+				req.Header.Set("Authorization", tt.authorization)
+			}
+			h.ServeHTTP(w, req)
+			assert.Equal(t, tt.wantCode, w.Code)
+		})
+	}
+}
+
+func Test_yankChartVersion(t *testing.T) {
+	chart := models.Chart{ID: "my-repo/my-chart", ChartVersions: []models.ChartVersion{{Version: "0.2.0"}, {Version: "0.1.0"}}}
+	tests := []struct {
+		name         string
+		err          error
+		version      string
+		yank         bool
+		wantCode     int
+		wantVersions []models.ChartVersion
+	}{
+		{"chart does not exist", errors.New("not found"), "0.2.0", true, http.StatusNotFound, nil},
+		{"version does not exist", nil, "0.3.0", true, http.StatusNotFound, nil},
+		{
+			"yank latest version", nil, "0.2.0", true, http.StatusOK,
+			[]models.ChartVersion{{Version: "0.1.0"}, {Version: "0.2.0", Yanked: true}},
+		},
+		{
+			"unyank version", nil, "0.1.0", false, http.StatusOK,
+			[]models.ChartVersion{{Version: "0.2.0"}, {Version: "0.1.0"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var m mock.Mock
+			dbSession = mockstore.NewMockSession(&m)
+
+			if tt.err != nil {
+				m.On("One", mock.Anything).Return(tt.err)
+			} else {
+				m.On("One", &models.Chart{}).Return(nil).Run(func(args mock.Arguments) {
+					c := chart
+					c.ChartVersions = append([]models.ChartVersion(nil), chart.ChartVersions...)
+					*args.Get(0).(*models.Chart) = c
+				})
+			}
+			yankedID := chart.ID + "-" + tt.version
+			if tt.wantVersions != nil {
+				if tt.yank {
+					m.On("UpsertId", yankedID, models.YankedVersion{ID: yankedID, Repo: "my-repo", Chart: chart.ID, Version: tt.version})
+				}
+				m.On("UpdateId", chart.ID, bson.M{"$set": bson.M{"chartversions": tt.wantVersions}})
+				m.On("UpdateId", "my-repo", mock.Anything)
+			}
+
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest("PUT", "/charts/"+chart.ID+"/versions/"+tt.version+"/yank", nil)
+			params := Params{"repo": "my-repo", "chartName": "my-chart", "version": tt.version}
+			if tt.yank {
+				yankChartVersion(w, req, params)
+			} else {
+				unyankChartVersion(w, req, params)
+			}
+
+			m.AssertExpectations(t)
+			assert.Equal(t, tt.wantCode, w.Code)
+			if tt.wantCode == http.StatusOK {
+				var b bodyAPIResponse
+				json.NewDecoder(w.Body).Decode(&b)
+				assert.Equal(t, chart.ID+"-"+tt.version, b.Data.ID)
+				assert.Equal(t, tt.yank, b.Data.Attributes.(map[string]interface{})["yanked"])
+			}
+		})
+	}
+}
+
+func Test_unyankLatestVersion(t *testing.T) {
+	chart := models.Chart{ID: "my-repo/my-chart", ChartVersions: []models.ChartVersion{{Version: "0.10.0"}, {Version: "0.9.0"}, {Version: "0.2.0"}}}
+
+	var m mock.Mock
+	dbSession = mockstore.NewMockSession(&m)
+	m.On("One", &models.Chart{}).Return(nil).Run(func(args mock.Arguments) {
+		c := chart
+		c.ChartVersions = append([]models.ChartVersion(nil), chart.ChartVersions...)
+		*args.Get(0).(*models.Chart) = c
+	})
+	m.On("UpsertId", mock.Anything, mock.Anything)
+	m.On("UpdateId", chart.ID, mock.Anything).Run(func(args mock.Arguments) {
+		chart.ChartVersions = args.Get(1).(bson.M)["$set"].(bson.M)["chartversions"].([]models.ChartVersion)
+	})
+	m.On("UpdateId", "my-repo", mock.Anything)
+
+	params := Params{"repo": "my-repo", "chartName": "my-chart", "version": "0.10.0"}
+	yankChartVersion(httptest.NewRecorder(), httptest.NewRequest("PUT", "/charts/"+chart.ID+"/versions/0.10.0/yank", nil), params)
+	assert.Equal(t, []models.ChartVersion{{Version: "0.9.0"}, {Version: "0.2.0"}, {Version: "0.10.0", Yanked: true}}, chart.ChartVersions, "yanked version should be last")
+
+	unyankChartVersion(httptest.NewRecorder(), httptest.NewRequest("DELETE", "/charts/"+chart.ID+"/versions/0.10.0/yank", nil), params)
+	assert.Equal(t, []models.ChartVersion{{Version: "0.10.0"}, {Version: "0.9.0"}, {Version: "0.2.0"}}, chart.ChartVersions, "unyanked version should be the latest again")
+}
+
+func Test_sortChartVersions(t *testing.T) {
+	versions := []models.ChartVersion{{Version: "1.0.0", Yanked: true}, {Version: "latest"}, {Version: "0.2.0"}, {Version: "0.10.0"}}
+	sortChartVersions(versions)
+	assert.Equal(t, []models.ChartVersion{{Version: "0.10.0"}, {Version: "0.2.0"}, {Version: "latest"}, {Version: "1.0.0", Yanked: true}}, versions)
+}
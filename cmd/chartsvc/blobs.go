@@ -0,0 +1,71 @@
+/*
+Copyright (c) 2018 The Helm Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/helm/monocular/pkg/blobstore"
+	log "github.com/sirupsen/logrus"
+)
+
+// blobs is the store chart-repo moves the icons and files of the charts to,
+// nil if they are kept in the MongoDB documents
+var blobs blobstore.Store
+
+// blobETag returns the entity tag of content stored either in a document or
+// under the key in the blob store
+func blobETag(content []byte, key string) string {
+	if len(content) == 0 && key != "" {
+		return newETag(key)
+	}
+	return newETag(string(content))
+}
+
+// writeBlob writes the content of a document, or streams the blob with the key
+// if the content was moved to the blob store
+func writeBlob(w http.ResponseWriter, req *http.Request, content []byte, key string) {
+	if len(content) > 0 || key == "" {
+		w.Write(content)
+		return
+	}
+	if blobs == nil {
+		log.Errorf("blob %s requested without a blob store", key)
+		http.Error(w, "blob store not configured", http.StatusInternalServerError)
+		return
+	}
+	r, size, err := blobs.Get(key)
+	if err == blobstore.ErrNotFound {
+		log.Errorf("could not find blob %s", key)
+		http.NotFound(w, req)
+		return
+	}
+	if err != nil {
+		log.WithError(err).Errorf("could not get blob %s", key)
+		http.Error(w, "could not get blob", http.StatusBadGateway)
+		return
+	}
+	defer r.Close()
+	if size >= 0 {
+		w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+	}
+	if _, err := io.Copy(w, r); err != nil {
+		log.WithError(err).Errorf("could not stream blob %s", key)
+	}
+}
@@ -0,0 +1,132 @@
+/*
+Copyright (c) 2018 The Helm Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/helm/monocular/cmd/chartsvc/models"
+	"github.com/helm/monocular/pkg/blobstore"
+	"github.com/kubeapps/common/datastore/mockstore"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func Test_blobs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "blobs")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	blobs = blobstore.NewFileStore(dir)
+	defer func() { blobs = nil }()
+
+	iconKey, err := blobstore.Put(blobs, iconBytes())
+	assert.NoError(t, err)
+	webpKey, err := blobstore.Put(blobs, []byte("webp"))
+	assert.NoError(t, err)
+	readmeKey, err := blobstore.Put(blobs, []byte("# my-chart"))
+	assert.NoError(t, err)
+	tarballKey, err := blobstore.Put(blobs, []byte("tarball"))
+	assert.NoError(t, err)
+	missingKey := blobstore.Key([]byte("missing"))
+
+	chart := models.Chart{
+		ID:              "my-repo/my-chart",
+		IconKey:         iconKey,
+		IconContentType: "image/png",
+		IconVariants:    []models.IconVariant{{Size: 160, Format: "webp", ContentType: "image/webp", Key: webpKey}},
+	}
+	files := models.ChartFiles{ID: "my-repo/my-chart-1.0.0", Digest: "123", ReadmeKey: readmeKey, ValuesKey: missingKey, TarballKey: tarballKey}
+	params := Params{"repo": "my-repo", "chartName": "my-chart", "version": "1.0.0"}
+
+	tests := []struct {
+		name            string
+		handler         func(http.ResponseWriter, *http.Request, Params)
+		query           string
+		wantCode        int
+		wantBody        string
+		wantContentType string
+	}{
+		{"icon", getChartIcon, "", http.StatusOK, string(iconBytes()), "image/png"},
+		{"icon variant", getChartIcon, "?format=webp", http.StatusOK, "webp", "image/webp"},
+		{"README", getChartVersionReadme, "", http.StatusOK, "# my-chart", "text/plain; charset=utf-8"},
+		{"missing values", getChartVersionValues, "", http.StatusNotFound, "", ""},
+		{"tarball", getChartVersionTarball, "", http.StatusOK, "tarball", "application/gzip"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var m mock.Mock
+			dbSession = mockstore.NewMockSession(&m)
+			m.On("One", &models.Chart{}).Return(nil).Run(func(args mock.Arguments) {
+				*args.Get(0).(*models.Chart) = chart
+			})
+			m.On("One", &models.ChartFiles{}).Return(nil).Run(func(args mock.Arguments) {
+				*args.Get(0).(*models.ChartFiles) = files
+			})
+
+			w := httptest.NewRecorder()
+			tt.handler(w, httptest.NewRequest("GET", "/"+tt.query, nil), params)
+
+			assert.Equal(t, tt.wantCode, w.Code, "http status code should match")
+			if tt.wantCode == http.StatusOK {
+				assert.Equal(t, tt.wantBody, w.Body.String(), "body should match")
+				assert.Equal(t, tt.wantContentType, w.Header().Get("Content-Type"), "content type should match")
+			}
+		})
+	}
+
+	t.Run("icon link", func(t *testing.T) {
+		assert.Equal(t, "/v1/assets/my-repo/my-chart/logo", chartAttributes(chart).Icon, "icon link should be set")
+	})
+
+	t.Run("tarball route", func(t *testing.T) {
+		defer func(enabled bool) { serveTarballs = enabled }(serveTarballs)
+		for _, enabled := range []bool{false, true} {
+			serveTarballs = enabled
+			var m mock.Mock
+			dbSession = mockstore.NewMockSession(&m)
+			m.On("One", &models.ChartFiles{}).Return(nil).Run(func(args mock.Arguments) {
+				*args.Get(0).(*models.ChartFiles) = files
+			})
+			ts := httptest.NewServer(setupRoutes())
+			res, err := http.Get(ts.URL + pathPrefix + "/assets/my-repo/my-chart/versions/1.0.0/chart.tgz")
+			assert.NoError(t, err)
+			res.Body.Close()
+			ts.Close()
+			if enabled {
+				assert.Equal(t, http.StatusOK, res.StatusCode, "tarballs should be served")
+			} else {
+				assert.Equal(t, http.StatusNotFound, res.StatusCode, "tarballs should not be served")
+				m.AssertNotCalled(t, "One", &models.ChartFiles{})
+			}
+		}
+	})
+
+	t.Run("tarball without a blob store", func(t *testing.T) {
+		var m mock.Mock
+		dbSession = mockstore.NewMockSession(&m)
+		m.On("One", &models.ChartFiles{}).Return(nil).Run(func(args mock.Arguments) {
+			*args.Get(0).(*models.ChartFiles) = models.ChartFiles{ID: "my-repo/my-chart-1.0.0", Readme: "# my-chart"}
+		})
+		w := httptest.NewRecorder()
+		getChartVersionTarball(w, httptest.NewRequest("GET", "/", nil), params)
+		assert.Equal(t, http.StatusNotFound, w.Code, "http status code should match")
+	})
+}
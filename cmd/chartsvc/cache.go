@@ -0,0 +1,187 @@
+/*
+Copyright (c) 2018 The Helm Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/globalsign/mgo/bson"
+	"github.com/helm/monocular/cmd/chartsvc/models"
+)
+
+// Cache-Control policies used by the different routes
+const (
+	// Resources that are addressed by a chart version, which only change if the
+	// chart is republished with a different digest
+	cacheControlVersioned = "public, max-age=86400"
+	// Resources that change whenever a repository is synced
+	cacheControlDefault = "public, max-age=300"
+)
+
+// cacheValidators holds the values used to answer conditional requests for a
+// resource. Empty values are not sent.
+type cacheValidators struct {
+	ETag         string
+	LastModified time.Time
+}
+
+// newETag builds a strong entity tag from the given parts
+func newETag(parts ...string) string {
+	h := sha256.New()
+	for _, p := range parts {
+		h.Write([]byte(p))
+		// separate parts so that ("ab", "c") and ("a", "bc") differ
+		h.Write([]byte{0})
+	}
+	return fmt.Sprintf(`"%x"`, h.Sum(nil))
+}
+
+// chartValidators returns the validators of a chart based on the digest and
+// creation time of its latest version
+func chartValidators(c *models.Chart) cacheValidators {
+	if len(c.ChartVersions) == 0 {
+		return cacheValidators{}
+	}
+	latest := c.ChartVersions[0]
+	return cacheValidators{
+		ETag:         newETag(c.ID, latest.Digest, chartAttributes(*c).Icon),
+		LastModified: latest.Created,
+	}
+}
+
+// chartVersionValidators returns the validators of a specific chart version,
+// which changes when it is yanked
+func chartVersionValidators(c *models.Chart, cv models.ChartVersion) cacheValidators {
+	return cacheValidators{
+		ETag:         newETag(c.ID, cv.Version, cv.Digest, strconv.FormatBool(cv.Yanked)),
+		LastModified: cv.Created,
+	}
+}
+
+// chartListValidators returns the validators of a list of charts. The entity
+// tag changes whenever any of the charts (or the additional parts, e.g. the
+// pagination metadata) changes.
+func chartListValidators(charts []*models.Chart, parts ...string) cacheValidators {
+	var v cacheValidators
+	for _, c := range charts {
+		if len(c.ChartVersions) == 0 {
+			continue
+		}
+		latest := c.ChartVersions[0]
+		parts = append(parts, c.ID, latest.Digest, chartAttributes(*c).Icon)
+		if latest.Created.After(v.LastModified) {
+			v.LastModified = latest.Created
+		}
+	}
+	v.ETag = newETag(parts...)
+	return v
+}
+
+// repoValidators returns the validators of the charts of a repository (or all
+// of them if repo is empty) based on the checksums of the synced indexes and
+// on the changes made through the admin API. The
+// additional parts, e.g. the query of the request, are added to the entity
+// tag.
+func repoValidators(repo string, parts ...string) (cacheValidators, error) {
+	db, closer := dbSession.DB()
+	defer closer()
+
+	query := bson.M{}
+	if repo != "" {
+		query["_id"] = repo
+	}
+	var checks []models.RepoCheck
+	if err := db.C(repositoryCollection).Find(query).Sort("_id").All(&checks); err != nil {
+		return cacheValidators{}, err
+	}
+	if len(checks) == 0 {
+		return cacheValidators{}, nil
+	}
+
+	var v cacheValidators
+	for _, rc := range checks {
+		parts = append(parts, rc.ID, rc.Checksum, strconv.Itoa(rc.Revision), rc.LastImport.Format(time.RFC3339Nano))
+		for _, t := range []time.Time{rc.LastUpdate, rc.LastChange, rc.LastImport} {
+			if t.After(v.LastModified) {
+				v.LastModified = t
+			}
+		}
+	}
+	v.ETag = newETag(parts...)
+	return v, nil
+}
+
+// chartFilesValidators returns the validators of one of the files of a chart
+// version. Files without a known digest are not given an entity tag.
+func chartFilesValidators(files models.ChartFiles, name string) cacheValidators {
+	if files.Digest == "" {
+		return cacheValidators{}
+	}
+	return cacheValidators{ETag: newETag(files.ID, files.Digest, name)}
+}
+
+// checkNotModified sets the caching headers for the response and returns true
+// if the client already has an up to date copy of the resource, in which case
+// a 304 Not Modified response has been written and the caller should not write
+// a body.
+func checkNotModified(w http.ResponseWriter, req *http.Request, cacheControl string, v cacheValidators) bool {
+	h := w.Header()
+	h.Set("Cache-Control", cacheControl)
+	if v.ETag != "" {
+		h.Set("ETag", v.ETag)
+	}
+	if !v.LastModified.IsZero() {
+		h.Set("Last-Modified", v.LastModified.UTC().Format(http.TimeFormat))
+	}
+
+	if req.Method != "GET" && req.Method != "HEAD" {
+		return false
+	}
+
+	notModified := false
+	// If-None-Match takes precedence over If-Modified-Since (RFC 7232, section 6)
+	if inm := req.Header.Get("If-None-Match"); inm != "" {
+		notModified = v.ETag != "" && etagMatches(inm, v.ETag)
+	} else if ims := req.Header.Get("If-Modified-Since"); ims != "" && !v.LastModified.IsZero() {
+		t, err := http.ParseTime(ims)
+		// Last-Modified has a resolution of seconds
+		notModified = err == nil && !v.LastModified.Truncate(time.Second).After(t)
+	}
+
+	if notModified {
+		w.WriteHeader(http.StatusNotModified)
+	}
+	return notModified
+}
+
+// etagMatches uses the weak comparison function to check whether the given
+// entity tag is in the If-None-Match header value
+func etagMatches(header, etag string) bool {
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
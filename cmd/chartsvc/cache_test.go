@@ -0,0 +1,156 @@
+/*
+Copyright (c) 2018 The Helm Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/helm/monocular/cmd/chartsvc/models"
+	"github.com/kubeapps/common/datastore/mockstore"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func Test_checkNotModified(t *testing.T) {
+	lastModified := time.Date(2019, 3, 1, 10, 0, 0, 0, time.UTC)
+	v := cacheValidators{ETag: newETag("my-repo/my-chart", "123"), LastModified: lastModified}
+
+	tests := []struct {
+		name         string
+		header       string
+		value        string
+		validators   cacheValidators
+		wantModified bool
+	}{
+		{"unconditional request", "", "", v, true},
+		{"matching etag", "If-None-Match", v.ETag, v, false},
+		{"matching weak etag", "If-None-Match", "W/" + v.ETag, v, false},
+		{"matching etag in list", "If-None-Match", `"foo", ` + v.ETag, v, false},
+		{"wildcard etag", "If-None-Match", "*", v, false},
+		{"different etag", "If-None-Match", `"foo"`, v, true},
+		{"etag without validator", "If-None-Match", `"foo"`, cacheValidators{}, true},
+		{"not modified since", "If-Modified-Since", lastModified.Format(http.TimeFormat), v, false},
+		{"modified since", "If-Modified-Since", lastModified.Add(-time.Hour).Format(http.TimeFormat), v, true},
+		{"invalid date", "If-Modified-Since", "yesterday", v, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest("GET", "/charts", nil)
+			if tt.header != "" {
+				req.Header.Set(tt.header, tt.value)
+			}
+
+			notModified := checkNotModified(w, req, cacheControlDefault, tt.validators)
+
+			assert.Equal(t, !tt.wantModified, notModified)
+			assert.Equal(t, cacheControlDefault, w.Header().Get("Cache-Control"))
+			assert.Equal(t, tt.validators.ETag, w.Header().Get("ETag"))
+			if !tt.wantModified {
+				assert.Equal(t, http.StatusNotModified, w.Code)
+			}
+		})
+	}
+}
+
+func Test_chartListValidators(t *testing.T) {
+	older := time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC)
+	newer := time.Date(2019, 2, 1, 0, 0, 0, 0, time.UTC)
+	charts := []*models.Chart{
+		{ID: "my-repo/my-chart", ChartVersions: []models.ChartVersion{{Version: "0.0.1", Digest: "123", Created: older}}},
+		{ID: "my-repo/dokuwiki", ChartVersions: []models.ChartVersion{{Version: "1.2.3", Digest: "1234", Created: newer}}},
+	}
+
+	v := chartListValidators(charts)
+	assert.Equal(t, newer, v.LastModified, "last modified should be the newest chart")
+	assert.NotEqual(t, v.ETag, chartListValidators(charts[:1]).ETag, "etag should change with the list")
+	assert.NotEqual(t, v.ETag, chartListValidators(charts, "{2}").ETag, "etag should change with the metadata")
+}
+
+func Test_repoValidators(t *testing.T) {
+	synced := time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC)
+	yanked := time.Date(2019, 2, 1, 0, 0, 0, 0, time.UTC)
+	validators := func(check models.RepoCheck) cacheValidators {
+		var m mock.Mock
+		dbSession = mockstore.NewMockSession(&m)
+		m.On("All", &repoChecks).Run(func(args mock.Arguments) {
+			*args.Get(0).(*[]models.RepoCheck) = []models.RepoCheck{check}
+		})
+		v, err := repoValidators("my-repo")
+		assert.NoError(t, err)
+		return v
+	}
+
+	v := validators(models.RepoCheck{ID: "my-repo", Checksum: "abc", LastUpdate: synced})
+	assert.Equal(t, synced, v.LastModified, "last modified should be the last sync")
+	changed := validators(models.RepoCheck{ID: "my-repo", Checksum: "abc", LastUpdate: synced, Revision: 1, LastChange: yanked})
+	assert.NotEqual(t, v.ETag, changed.ETag, "etag should change with the revision")
+	assert.Equal(t, yanked, changed.LastModified, "last modified should be the last change")
+	imported := validators(models.RepoCheck{ID: "my-repo", Checksum: "abc", LastUpdate: synced, Revision: 1, LastChange: yanked, LastImport: yanked.Add(time.Hour)})
+	assert.NotEqual(t, changed.ETag, imported.ETag, "etag should change when files are imported")
+	assert.Equal(t, yanked.Add(time.Hour), imported.LastModified, "last modified should be the last import")
+}
+
+func Test_chartVersionValidatorsYanked(t *testing.T) {
+	chart := models.Chart{ID: "my-repo/my-chart"}
+	cv := models.ChartVersion{Version: "0.1.0", Digest: "123"}
+	yanked := cv
+	yanked.Yanked = true
+	assert.NotEqual(t, chartVersionValidators(&chart, cv).ETag, chartVersionValidators(&chart, yanked).ETag, "etag should change when the version is yanked")
+}
+
+func Test_getChartNotModified(t *testing.T) {
+	chart := models.Chart{ID: "my-repo/my-chart", ChartVersions: []models.ChartVersion{{Version: "0.1.0", Digest: "123"}}}
+
+	var m mock.Mock
+	dbSession = mockstore.NewMockSession(&m)
+	m.On("One", &models.Chart{}).Return(nil).Run(func(args mock.Arguments) {
+		*args.Get(0).(*models.Chart) = chart
+	})
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/charts/"+chart.ID, nil)
+	req.Header.Set("If-None-Match", chartValidators(&chart).ETag)
+	getChart(w, req, Params{"repo": "my-repo", "chartName": "my-chart"})
+
+	assert.Equal(t, http.StatusNotModified, w.Code, "http status code should match")
+	assert.Empty(t, w.Body.Bytes(), "body should be empty")
+}
+
+func Test_listChartsNotModified(t *testing.T) {
+	checks := []models.RepoCheck{{ID: "my-repo", Checksum: "abc", LastUpdate: time.Now()}}
+
+	var m mock.Mock
+	dbSession = mockstore.NewMockSession(&m)
+	m.On("All", &repoChecks).Run(func(args mock.Arguments) {
+		*args.Get(0).(*[]models.RepoCheck) = checks
+	})
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/charts?size=10", nil)
+	req.Header.Set("If-None-Match", newETag("size=10", "my-repo", "abc", "0", time.Time{}.Format(time.RFC3339Nano)))
+	listCharts(w, req)
+
+	// The charts should not be queried
+	m.AssertExpectations(t)
+	m.AssertNumberOfCalls(t, "All", 1)
+	assert.Equal(t, http.StatusNotModified, w.Code, "http status code should match")
+}
@@ -0,0 +1,127 @@
+/*
+Copyright (c) 2018 The Helm Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/globalsign/mgo/bson"
+	"github.com/helm/monocular/cmd/chartsvc/models"
+	"github.com/kubeapps/common/response"
+	log "github.com/sirupsen/logrus"
+)
+
+// changelogEntry holds the changes introduced by a chart version
+type changelogEntry struct {
+	Version    string               `json:"version"`
+	AppVersion string               `json:"app_version"`
+	Created    time.Time            `json:"created"`
+	Yanked     bool                 `json:"yanked,omitempty"`
+	Changes    []models.ChartChange `json:"changes,omitempty"`
+	// Changelog is the CHANGELOG.md included in the chart version
+	Changelog string `json:"changelog,omitempty"`
+}
+
+// getChartChangelog returns the changes of each version of the given chart,
+// newest first. If the from query param is set, only the versions released
+// after that version are returned.
+func getChartChangelog(w http.ResponseWriter, req *http.Request, params Params) {
+	db, closer := dbSession.DB()
+	defer closer()
+	var chart models.Chart
+	chartID := fmt.Sprintf("%s/%s", params["repo"], params["chartName"])
+	if err := db.C(chartCollection).FindId(chartID).One(&chart); err != nil {
+		log.WithError(err).Errorf("could not find chart with id %s", chartID)
+		response.NewErrorResponse(http.StatusNotFound, "could not find chart").Write(w)
+		return
+	}
+
+	versions := chart.ChartVersions
+	if from := req.FormValue("from"); from != "" {
+		var fromVersion *models.ChartVersion
+		for i := range chart.ChartVersions {
+			if chart.ChartVersions[i].Version == from {
+				fromVersion = &chart.ChartVersions[i]
+			}
+		}
+		if fromVersion == nil {
+			response.NewErrorResponse(http.StatusNotFound, "could not find chart version").Write(w)
+			return
+		}
+		// Versions are compared by release date since they are not required to
+		// follow semver
+		var newer []models.ChartVersion
+		for _, cv := range chart.ChartVersions {
+			if cv.Created.After(fromVersion.Created) {
+				newer = append(newer, cv)
+			}
+		}
+		versions = newer
+	}
+
+	// The files of the versions are imported after the chart, the entity tag
+	// changes once they are
+	var ids []string
+	filesByID := map[string]models.ChartFiles{}
+	if len(versions) > 0 {
+		for _, cv := range versions {
+			ids = append(ids, fmt.Sprintf("%s-%s", chart.ID, cv.Version))
+		}
+		var files []models.ChartFiles
+		if err := db.C(filesCollection).Find(bson.M{"_id": bson.M{"$in": ids}}).All(&files); err != nil {
+			log.WithError(err).Errorf("could not find files for chart with id %s", chartID)
+			response.NewErrorResponse(http.StatusInternalServerError, "could not fetch changelog").Write(w)
+			return
+		}
+		if err := withSharedContent(db, files); err != nil {
+			log.WithError(err).Errorf("could not find shared files for chart with id %s", chartID)
+			response.NewErrorResponse(http.StatusInternalServerError, "could not fetch changelog").Write(w)
+			return
+		}
+		for _, f := range files {
+			filesByID[f.ID] = f
+		}
+	}
+
+	parts := []string{chart.ID, req.FormValue("from")}
+	for i, cv := range versions {
+		f, imported := filesByID[ids[i]]
+		parts = append(parts, cv.Version, cv.Digest, strconv.FormatBool(cv.Yanked), strconv.FormatBool(imported), f.Digest)
+	}
+	// Without Last-Modified, as the files can be imported after the versions
+	// are created
+	if checkNotModified(w, req, cacheControlDefault, cacheValidators{ETag: newETag(parts...)}) {
+		return
+	}
+
+	entries := []changelogEntry{}
+	for i, cv := range versions {
+		f := filesByID[ids[i]]
+		entries = append(entries, changelogEntry{
+			Version:    cv.Version,
+			AppVersion: cv.AppVersion,
+			Created:    cv.Created,
+			Yanked:     cv.Yanked,
+			Changes:    f.Changes,
+			Changelog:  f.Changelog,
+		})
+	}
+	response.NewDataResponse(entries).Write(w)
+}
@@ -0,0 +1,129 @@
+/*
+Copyright (c) 2018 The Helm Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/helm/monocular/cmd/chartsvc/models"
+	"github.com/kubeapps/common/datastore/mockstore"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func Test_getChartChangelog(t *testing.T) {
+	now := time.Now().UTC().Truncate(time.Second)
+	chart := models.Chart{ID: "my-repo/my-chart", ChartVersions: []models.ChartVersion{
+		{Version: "0.3.0", Created: now},
+		{Version: "0.2.0", Created: now.Add(-time.Hour)},
+		{Version: "0.1.0", Created: now.Add(-2 * time.Hour)},
+	}}
+	files := []models.ChartFiles{
+		{ID: "my-repo/my-chart-0.3.0", Changelog: "# Changelog", Changes: []models.ChartChange{{Kind: "added", Description: "Ingress"}}},
+		{ID: "my-repo/my-chart-0.2.0", Changes: []models.ChartChange{{Description: "Bump app"}}},
+	}
+
+	tests := []struct {
+		name         string
+		err          error
+		from         string
+		wantCode     int
+		wantVersions []string
+	}{
+		{"chart does not exist", errors.New("not found"), "", http.StatusNotFound, nil},
+		{"all versions", nil, "", http.StatusOK, []string{"0.3.0", "0.2.0", "0.1.0"}},
+		{"versions after the given one", nil, "0.1.0", http.StatusOK, []string{"0.3.0", "0.2.0"}},
+		{"latest version", nil, "0.3.0", http.StatusOK, []string{}},
+		{"version does not exist", nil, "1.0.0", http.StatusNotFound, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var m mock.Mock
+			dbSession = mockstore.NewMockSession(&m)
+
+			if tt.err != nil {
+				m.On("One", mock.Anything).Return(tt.err)
+			} else {
+				m.On("One", &models.Chart{}).Return(nil).Run(func(args mock.Arguments) {
+					*args.Get(0).(*models.Chart) = chart
+				})
+			}
+			if len(tt.wantVersions) > 0 {
+				var cf []models.ChartFiles
+				m.On("All", &cf).Run(func(args mock.Arguments) {
+					*args.Get(0).(*[]models.ChartFiles) = files
+				})
+			}
+
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest("GET", "/charts/my-repo/my-chart/changelog?from="+tt.from, nil)
+			getChartChangelog(w, req, Params{"repo": "my-repo", "chartName": "my-chart"})
+
+			m.AssertExpectations(t)
+			assert.Equal(t, tt.wantCode, w.Code)
+			if tt.wantCode == http.StatusOK {
+				var b struct {
+					Data []changelogEntry `json:"data"`
+				}
+				json.NewDecoder(w.Body).Decode(&b)
+				versions := []string{}
+				for _, e := range b.Data {
+					versions = append(versions, e.Version)
+				}
+				assert.Equal(t, tt.wantVersions, versions)
+				if len(b.Data) > 0 {
+					assert.Equal(t, "# Changelog", b.Data[0].Changelog)
+					assert.Equal(t, files[0].Changes, b.Data[0].Changes)
+					assert.Equal(t, now, b.Data[0].Created)
+				}
+			}
+		})
+	}
+}
+
+func Test_getChartChangelogETag(t *testing.T) {
+	chart := models.Chart{ID: "my-repo/my-chart", ChartVersions: []models.ChartVersion{{Version: "0.1.0", Digest: "123"}}}
+	etag := func(files []models.ChartFiles) string {
+		var m mock.Mock
+		dbSession = mockstore.NewMockSession(&m)
+		m.On("One", &models.Chart{}).Return(nil).Run(func(args mock.Arguments) {
+			*args.Get(0).(*models.Chart) = chart
+		})
+		var cf []models.ChartFiles
+		m.On("All", &cf).Run(func(args mock.Arguments) {
+			*args.Get(0).(*[]models.ChartFiles) = files
+		})
+		w := httptest.NewRecorder()
+		getChartChangelog(w, httptest.NewRequest("GET", "/charts/my-repo/my-chart/changelog", nil), Params{"repo": "my-repo", "chartName": "my-chart"})
+		assert.Equal(t, http.StatusOK, w.Code)
+		return w.Header().Get("ETag")
+	}
+
+	missing := etag(nil)
+	imported := etag([]models.ChartFiles{{ID: "my-repo/my-chart-0.1.0", Digest: "123", Changelog: "# Changelog"}})
+	assert.NotEqual(t, missing, imported, "the entity tag should change once the files are imported")
+	assert.Equal(t, imported, etag([]models.ChartFiles{{ID: "my-repo/my-chart-0.1.0", Digest: "123", Changelog: "# Changelog"}}))
+
+	chart.ChartVersions[0].Yanked = true
+	assert.NotEqual(t, imported, etag([]models.ChartFiles{{ID: "my-repo/my-chart-0.1.0", Digest: "123"}}), "the entity tag should change once the version is yanked")
+}
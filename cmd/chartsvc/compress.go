@@ -0,0 +1,166 @@
+/*
+Copyright (c) 2018 The Helm Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+)
+
+// supportedEncodings lists the content codings chartsvc is able to produce, in
+// order of preference
+var supportedEncodings = []string{"br", "gzip"}
+
+// negotiateEncoding returns the preferred content coding accepted by the
+// client, or an empty string if the response should not be compressed
+func negotiateEncoding(acceptEncoding string) string {
+	accepted := map[string]float64{}
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		fields := strings.Split(part, ";")
+		coding := strings.ToLower(strings.TrimSpace(fields[0]))
+		if coding == "" {
+			continue
+		}
+		q := 1.0
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if v, err := strconv.ParseFloat(strings.TrimPrefix(param, "q="), 64); err == nil {
+					q = v
+				}
+			}
+		}
+		accepted[coding] = q
+	}
+
+	best, bestQ := "", 0.0
+	for _, enc := range supportedEncodings {
+		q, ok := accepted[enc]
+		if !ok {
+			q, ok = accepted["*"]
+		}
+		if ok && q > bestQ {
+			best, bestQ = enc, q
+		}
+	}
+	return best
+}
+
+// compressHandler is a negroni middleware that compresses responses using the
+// content coding negotiated with the client
+type compressHandler struct{}
+
+func (compressHandler) ServeHTTP(w http.ResponseWriter, req *http.Request, next http.HandlerFunc) {
+	w.Header().Add("Vary", "Accept-Encoding")
+	enc := negotiateEncoding(req.Header.Get("Accept-Encoding"))
+	if enc == "" || req.Method == "HEAD" {
+		next(w, req)
+		return
+	}
+
+	cw := &compressResponseWriter{ResponseWriter: w, encoding: enc}
+	defer cw.Close()
+	next(cw, req)
+}
+
+// compressResponseWriter compresses the body of the response if its status
+// code and content type are suitable for compression
+type compressResponseWriter struct {
+	http.ResponseWriter
+	encoding    string
+	writer      io.WriteCloser
+	wroteHeader bool
+}
+
+func (cw *compressResponseWriter) WriteHeader(code int) {
+	if cw.wroteHeader {
+		return
+	}
+	cw.wroteHeader = true
+
+	h := cw.Header()
+	if shouldCompress(code, h) {
+		h.Del("Content-Length")
+		h.Set("Content-Encoding", cw.encoding)
+		// The compressed representation is not byte-for-byte identical to the
+		// uncompressed one, so only a weak entity tag can be shared between them
+		if etag := h.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+			h.Set("ETag", "W/"+etag)
+		}
+		switch cw.encoding {
+		case "br":
+			cw.writer = brotli.NewWriter(cw.ResponseWriter)
+		case "gzip":
+			cw.writer = gzip.NewWriter(cw.ResponseWriter)
+		}
+	}
+	cw.ResponseWriter.WriteHeader(code)
+}
+
+func (cw *compressResponseWriter) Write(b []byte) (int, error) {
+	if !cw.wroteHeader {
+		if cw.Header().Get("Content-Type") == "" {
+			cw.Header().Set("Content-Type", http.DetectContentType(b))
+		}
+		cw.WriteHeader(http.StatusOK)
+	}
+	if cw.writer == nil {
+		return cw.ResponseWriter.Write(b)
+	}
+	return cw.writer.Write(b)
+}
+
+// Flush sends any buffered compressed data to the client
+func (cw *compressResponseWriter) Flush() {
+	if f, ok := cw.writer.(interface{ Flush() error }); ok {
+		f.Flush()
+	}
+	if f, ok := cw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Close flushes the compressed stream, it must be called once the response has
+// been written
+func (cw *compressResponseWriter) Close() error {
+	if cw.writer == nil {
+		return nil
+	}
+	return cw.writer.Close()
+}
+
+// shouldCompress returns true if a response with the given status code and
+// headers should be compressed
+func shouldCompress(code int, h http.Header) bool {
+	if code < http.StatusOK || code == http.StatusNoContent || code == http.StatusNotModified {
+		return false
+	}
+	if h.Get("Content-Encoding") != "" {
+		return false
+	}
+	// Raster images and chart tarballs are already compressed
+	contentType := h.Get("Content-Type")
+	if strings.HasPrefix(contentType, "image/") && !strings.HasPrefix(contentType, "image/svg") || contentType == "application/gzip" {
+		return false
+	}
+	return true
+}
@@ -0,0 +1,121 @@
+/*
+Copyright (c) 2018 The Helm Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"compress/gzip"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_negotiateEncoding(t *testing.T) {
+	tests := []struct {
+		name           string
+		acceptEncoding string
+		want           string
+	}{
+		{"no header", "", ""},
+		{"gzip only", "gzip", "gzip"},
+		{"brotli preferred", "gzip, deflate, br", "br"},
+		{"quality values", "br;q=0.5, gzip;q=0.8", "gzip"},
+		{"disabled coding", "br;q=0, gzip", "gzip"},
+		{"wildcard", "*", "br"},
+		{"unsupported coding", "deflate", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, negotiateEncoding(tt.acceptEncoding))
+		})
+	}
+}
+
+func Test_compressHandler(t *testing.T) {
+	body := `{"data":[]}`
+	handler := func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("ETag", `"123"`)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(body))
+	}
+
+	t.Run("gzip", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "/charts", nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		compressHandler{}.ServeHTTP(w, req, handler)
+
+		assert.Equal(t, "gzip", w.Header().Get("Content-Encoding"))
+		assert.Equal(t, `W/"123"`, w.Header().Get("ETag"), "entity tag should be weak")
+		gzr, err := gzip.NewReader(w.Body)
+		assert.NoError(t, err)
+		b, err := ioutil.ReadAll(gzr)
+		assert.NoError(t, err)
+		assert.Equal(t, body, string(b))
+	})
+
+	t.Run("brotli", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "/charts", nil)
+		req.Header.Set("Accept-Encoding", "br")
+		compressHandler{}.ServeHTTP(w, req, handler)
+
+		assert.Equal(t, "br", w.Header().Get("Content-Encoding"))
+		b, err := ioutil.ReadAll(brotli.NewReader(w.Body))
+		assert.NoError(t, err)
+		assert.Equal(t, body, string(b))
+	})
+
+	t.Run("identity", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "/charts", nil)
+		compressHandler{}.ServeHTTP(w, req, handler)
+
+		assert.Empty(t, w.Header().Get("Content-Encoding"))
+		assert.Equal(t, `"123"`, w.Header().Get("ETag"))
+		assert.Equal(t, body, w.Body.String())
+		assert.Equal(t, "Accept-Encoding", w.Header().Get("Vary"))
+	})
+
+	t.Run("raster images are not compressed", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "/assets/my-repo/my-chart/logo", nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		compressHandler{}.ServeHTTP(w, req, func(w http.ResponseWriter, req *http.Request) {
+			w.Write(iconBytes())
+		})
+
+		assert.Empty(t, w.Header().Get("Content-Encoding"))
+		assert.Equal(t, iconBytes(), w.Body.Bytes())
+	})
+
+	t.Run("not modified responses are not compressed", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "/charts", nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		compressHandler{}.ServeHTTP(w, req, func(w http.ResponseWriter, req *http.Request) {
+			w.WriteHeader(http.StatusNotModified)
+		})
+
+		assert.Equal(t, http.StatusNotModified, w.Code)
+		assert.Empty(t, w.Header().Get("Content-Encoding"))
+		assert.Empty(t, w.Body.Bytes())
+	})
+}
@@ -0,0 +1,76 @@
+/*
+Copyright (c) 2018 The Helm Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"github.com/globalsign/mgo/bson"
+	"github.com/helm/monocular/cmd/chartsvc/models"
+	"github.com/kubeapps/common/datastore"
+)
+
+// findChartFiles returns the files of a chart version, along with their
+// content if it is shared with the chart versions of other repositories
+func findChartFiles(db datastore.Database, fileID string) (models.ChartFiles, error) {
+	var files models.ChartFiles
+	if err := db.C(filesCollection).FindId(fileID).One(&files); err != nil {
+		return files, err
+	}
+	if !files.Shared {
+		return files, nil
+	}
+	var content models.ChartFiles
+	if err := db.C(contentsCollection).FindId(files.Digest).One(&content); err != nil {
+		return files, err
+	}
+	return withContent(files, content), nil
+}
+
+// withSharedContent adds their shared content to the files
+func withSharedContent(db datastore.Database, files []models.ChartFiles) error {
+	var digests []string
+	for _, f := range files {
+		if f.Shared {
+			digests = append(digests, f.Digest)
+		}
+	}
+	if len(digests) == 0 {
+		return nil
+	}
+	var contents []models.ChartFiles
+	if err := db.C(contentsCollection).Find(bson.M{"_id": bson.M{"$in": digests}}).All(&contents); err != nil {
+		return err
+	}
+	byDigest := map[string]models.ChartFiles{}
+	for _, c := range contents {
+		byDigest[c.ID] = c
+	}
+	for i, f := range files {
+		if c, ok := byDigest[f.Digest]; ok && f.Shared {
+			files[i] = withContent(f, c)
+		}
+	}
+	return nil
+}
+
+// withContent returns the files with the content of the document keyed by
+// their digest
+func withContent(files, content models.ChartFiles) models.ChartFiles {
+	files.Readme, files.Values, files.Schema, files.Changelog = content.Readme, content.Values, content.Schema, content.Changelog
+	files.ReadmeKey, files.ValuesKey, files.SchemaKey, files.TarballKey = content.ReadmeKey, content.ValuesKey, content.SchemaKey, content.TarballKey
+	files.Files = content.Files
+	return files
+}
@@ -0,0 +1,83 @@
+/*
+Copyright (c) 2018 The Helm Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/helm/monocular/cmd/chartsvc/models"
+	"github.com/kubeapps/common/datastore/mockstore"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func Test_sharedChartFiles(t *testing.T) {
+	files := models.ChartFiles{ID: "mirror/my-chart-1.0.0", Digest: "123", Shared: true}
+	content := models.ChartFiles{ID: "123", Readme: "# my-chart", Changelog: "# Changelog"}
+	params := Params{"repo": "mirror", "chartName": "my-chart", "version": "1.0.0"}
+
+	t.Run("README", func(t *testing.T) {
+		var m mock.Mock
+		dbSession = mockstore.NewMockSession(&m)
+		m.On("One", &models.ChartFiles{}).Return(nil).Run(func(args mock.Arguments) {
+			*args.Get(0).(*models.ChartFiles) = files
+		}).Once()
+		m.On("One", &models.ChartFiles{}).Return(nil).Run(func(args mock.Arguments) {
+			*args.Get(0).(*models.ChartFiles) = content
+		}).Once()
+
+		w := httptest.NewRecorder()
+		getChartVersionReadme(w, httptest.NewRequest("GET", "/", nil), params)
+		m.AssertExpectations(t)
+		assert.Equal(t, http.StatusOK, w.Code, "http status code should match")
+		assert.Equal(t, "# my-chart", w.Body.String(), "README should match")
+	})
+
+	t.Run("missing content", func(t *testing.T) {
+		var m mock.Mock
+		dbSession = mockstore.NewMockSession(&m)
+		m.On("One", &models.ChartFiles{}).Return(nil).Run(func(args mock.Arguments) {
+			*args.Get(0).(*models.ChartFiles) = files
+		}).Once()
+		m.On("One", &models.ChartFiles{}).Return(errors.New("not found")).Once()
+
+		w := httptest.NewRecorder()
+		getChartVersionValues(w, httptest.NewRequest("GET", "/", nil), params)
+		assert.Equal(t, http.StatusNotFound, w.Code, "http status code should match")
+	})
+
+	t.Run("changelog", func(t *testing.T) {
+		var m mock.Mock
+		dbSession = mockstore.NewMockSession(&m)
+		var cf []models.ChartFiles
+		m.On("All", &cf).Run(func(args mock.Arguments) {
+			*args.Get(0).(*[]models.ChartFiles) = []models.ChartFiles{content}
+		}).Once()
+		db, closer := dbSession.DB()
+		defer closer()
+
+		all := []models.ChartFiles{files, {ID: "stable/my-chart-0.1.0", Changelog: "# Not shared"}}
+		assert.NoError(t, withSharedContent(db, all))
+		m.AssertExpectations(t)
+		assert.Equal(t, "# Changelog", all[0].Changelog, "shared changelog should be added")
+		assert.Equal(t, "mirror/my-chart-1.0.0", all[0].ID, "files should keep their id")
+		assert.Equal(t, "# Not shared", all[1].Changelog, "changelog should be kept")
+	})
+}
@@ -0,0 +1,183 @@
+/*
+Copyright (c) 2018 The Helm Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/globalsign/mgo/bson"
+	"github.com/helm/monocular/cmd/chartsvc/models"
+	"github.com/kubeapps/common/response"
+	log "github.com/sirupsen/logrus"
+)
+
+// crdResponse lists the chart versions shipping a CRD. Conflict is true if it
+// is shipped by charts with different names, which cannot be installed in the
+// same cluster
+type crdResponse struct {
+	Name     string        `json:"name"`
+	Group    string        `json:"group"`
+	Kind     string        `json:"kind"`
+	Plural   string        `json:"plural"`
+	Charts   []crdProvider `json:"charts"`
+	Conflict bool          `json:"conflict"`
+}
+
+// crdProvider is a chart version shipping a CRD, with the versions and scope
+// of the CRD it defines
+type crdProvider struct {
+	Repo     string   `json:"repo"`
+	Chart    string   `json:"chart"`
+	Version  string   `json:"version"`
+	Versions []string `json:"versions"`
+	Scope    string   `json:"scope"`
+	Path     string   `json:"path"`
+}
+
+// parseCRDKind splits the kind query param, either a kind or a kind qualified
+// with its group such as Certificate.cert-manager.io
+func parseCRDKind(kind string) (string, string) {
+	if i := strings.Index(kind, "."); i >= 0 {
+		return kind[:i], kind[i+1:]
+	}
+	return kind, ""
+}
+
+// listCRDs returns the CRDs of the given kind and the chart versions shipping
+// them
+func listCRDs(w http.ResponseWriter, req *http.Request) {
+	kind, group := parseCRDKind(req.FormValue("kind"))
+	if kind == "" {
+		response.NewErrorResponse(http.StatusBadRequest, "kind is required").Write(w)
+		return
+	}
+	match := bson.M{"kind": kind}
+	if group != "" {
+		match["group"] = group
+	}
+
+	files, truncated, err := findMatchingFiles("crds", match)
+	var chartNames map[string][]string
+	if err == nil && truncated {
+		chartNames, err = crdChartNames(match)
+	}
+	if err != nil {
+		log.WithError(err).Errorf("could not find CRDs of kind %s", req.FormValue("kind"))
+		response.NewErrorResponse(http.StatusInternalServerError, "could not fetch CRDs").Write(w)
+		return
+	}
+
+	byName := map[string]*crdResponse{}
+	charts := map[string]map[string]bool{}
+	for _, f := range files {
+		for _, c := range f.CRDs {
+			if c.Kind != kind || (group != "" && c.Group != group) {
+				continue
+			}
+			cr, ok := byName[c.Name]
+			if !ok {
+				cr = &crdResponse{Name: c.Name, Group: c.Group, Kind: c.Kind, Plural: c.Plural}
+				byName[c.Name] = cr
+				charts[c.Name] = map[string]bool{}
+			}
+			cr.Charts = append(cr.Charts, crdProvider{Repo: f.Repo.Name, Chart: c.Chart, Version: c.Version, Versions: c.Versions, Scope: c.Scope, Path: c.Path})
+			charts[c.Name][c.Chart] = true
+		}
+	}
+
+	crds := []crdResponse{}
+	for name, cr := range byName {
+		cr.Conflict = len(charts[name]) > 1
+		if truncated {
+			// The chart versions not listed may ship the CRD too
+			cr.Conflict = len(chartNames[name]) > 1
+		}
+		sort.Slice(cr.Charts, func(i, j int) bool {
+			a, b := cr.Charts[i], cr.Charts[j]
+			if a.Repo != b.Repo {
+				return a.Repo < b.Repo
+			}
+			if a.Chart != b.Chart {
+				return a.Chart < b.Chart
+			}
+			return a.Version < b.Version
+		})
+		crds = append(crds, *cr)
+	}
+	sort.Slice(crds, func(i, j int) bool { return crds[i].Name < crds[j].Name })
+	if truncated {
+		response.NewDataResponseWithMeta(crds, matchingFilesMeta{Truncated: true}).Write(w)
+		return
+	}
+	response.NewDataResponse(crds).Write(w)
+}
+
+// crdCharts are the names of the charts shipping a CRD
+type crdCharts struct {
+	Name   string   `bson:"_id"`
+	Charts []string `bson:"charts"`
+}
+
+// crdChartNames returns the names of the charts shipping each CRD matching the
+// selector, grouped by the database so that it covers every chart version
+func crdChartNames(selector bson.M) (map[string][]string, error) {
+	db, closer := dbSession.DB()
+	defer closer()
+	crdMatch := bson.M{}
+	for k, v := range selector {
+		crdMatch["crds."+k] = v
+	}
+	var groups []crdCharts
+	err := db.C(filesCollection).Pipe([]bson.M{
+		{"$match": bson.M{"crds": bson.M{"$elemMatch": selector}}},
+		{"$unwind": "$crds"},
+		{"$match": crdMatch},
+		{"$group": bson.M{"_id": "$crds.name", "charts": bson.M{"$addToSet": "$crds.chart"}}},
+	}).All(&groups)
+	if err != nil {
+		return nil, err
+	}
+	names := map[string][]string{}
+	for _, g := range groups {
+		names[g.Name] = g.Charts
+	}
+	return names, nil
+}
+
+// listChartVersionCRDs returns the CRDs shipped by a chart version
+func listChartVersionCRDs(w http.ResponseWriter, req *http.Request, params Params) {
+	db, closer := dbSession.DB()
+	defer closer()
+	fileID := fmt.Sprintf("%s/%s-%s", params["repo"], params["chartName"], params["version"])
+	files, err := findChartFiles(db, fileID)
+	if err != nil {
+		log.WithError(err).Errorf("could not find files with id %s", fileID)
+		response.NewErrorResponse(http.StatusNotFound, "could not find chart version").Write(w)
+		return
+	}
+	if checkNotModified(w, req, cacheControlVersioned, chartFilesValidators(files, "crds")) {
+		return
+	}
+	crds := files.CRDs
+	if crds == nil {
+		crds = []models.CRD{}
+	}
+	response.NewDataResponse(crds).Write(w)
+}
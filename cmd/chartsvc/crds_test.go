@@ -0,0 +1,169 @@
+/*
+Copyright (c) 2018 The Helm Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/helm/monocular/cmd/chartsvc/models"
+	"github.com/kubeapps/common/datastore/mockstore"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+var certificateCRD = models.CRD{Name: "certificates.cert-manager.io", Group: "cert-manager.io", Kind: "Certificate", Plural: "certificates", Scope: "Namespaced", Versions: []string{"v1"}, Chart: "cert-manager", Version: "1.0.0", Path: "crds/certificates.yaml"}
+
+func Test_listCRDsTruncated(t *testing.T) {
+	// the chart versions of legacy-certs shipping the CRD are not listed
+	var files []models.ChartFiles
+	for i := 0; i <= maxMatchingFiles; i++ {
+		files = append(files, models.ChartFiles{Repo: models.Repo{Name: "stable"}, CRDs: []models.CRD{certificateCRD}})
+	}
+
+	var m mock.Mock
+	dbSession = mockstore.NewMockSession(&m)
+	var cf []models.ChartFiles
+	m.On("All", &cf).Run(func(args mock.Arguments) {
+		*args.Get(0).(*[]models.ChartFiles) = files
+	})
+	var groups []crdCharts
+	m.On("All", &groups).Run(func(args mock.Arguments) {
+		*args.Get(0).(*[]crdCharts) = []crdCharts{{Name: certificateCRD.Name, Charts: []string{"cert-manager", "legacy-certs"}}}
+	})
+
+	w := httptest.NewRecorder()
+	listCRDs(w, httptest.NewRequest("GET", "/v1/crds?kind=Certificate", nil))
+	assert.Equal(t, http.StatusOK, w.Code, "http status code should match")
+	var b struct {
+		Data []crdResponse
+		Meta matchingFilesMeta
+	}
+	assert.NoError(t, json.NewDecoder(w.Body).Decode(&b))
+	assert.Len(t, b.Data, 1, "CRDs should match")
+	assert.Len(t, b.Data[0].Charts, maxMatchingFiles, "chart versions should be truncated")
+	assert.True(t, b.Data[0].Conflict, "conflict should cover the chart versions not listed")
+	assert.True(t, b.Meta.Truncated, "list should be marked as truncated")
+}
+
+func Test_parseCRDKind(t *testing.T) {
+	kind, group := parseCRDKind("Certificate.cert-manager.io")
+	assert.Equal(t, "Certificate", kind, "kind should match")
+	assert.Equal(t, "cert-manager.io", group, "group should match")
+	kind, group = parseCRDKind("Certificate")
+	assert.Equal(t, "Certificate", kind, "kind should match")
+	assert.Equal(t, "", group, "group should be empty")
+}
+
+func Test_listCRDs(t *testing.T) {
+	issuer := models.CRD{Name: "issuers.cert-manager.io", Group: "cert-manager.io", Kind: "Issuer", Chart: "cert-manager", Version: "1.0.0"}
+	legacy := certificateCRD
+	legacy.Chart, legacy.Version, legacy.Versions = "legacy-certs", "0.1.0", []string{"v1alpha1"}
+	other := models.CRD{Name: "certificates.example.com", Group: "example.com", Kind: "Certificate", Chart: "example", Version: "2.0.0"}
+	files := []models.ChartFiles{
+		{ID: "stable/cert-manager-1.0.0", Repo: models.Repo{Name: "stable"}, CRDs: []models.CRD{certificateCRD, issuer}},
+		{ID: "incubator/legacy-certs-0.1.0", Repo: models.Repo{Name: "incubator"}, CRDs: []models.CRD{legacy}},
+		{ID: "stable/example-2.0.0", Repo: models.Repo{Name: "stable"}, CRDs: []models.CRD{other}},
+	}
+
+	tests := []struct {
+		name      string
+		kind      string
+		wantCode  int
+		wantNames []string
+		conflicts []bool
+	}{
+		{"missing kind", "", http.StatusBadRequest, nil, nil},
+		{"kind", "Certificate", http.StatusOK, []string{"certificates.cert-manager.io", "certificates.example.com"}, []bool{true, false}},
+		{"kind and group", "Certificate.example.com", http.StatusOK, []string{"certificates.example.com"}, []bool{false}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var m mock.Mock
+			dbSession = mockstore.NewMockSession(&m)
+			var cf []models.ChartFiles
+			m.On("All", &cf).Run(func(args mock.Arguments) {
+				*args.Get(0).(*[]models.ChartFiles) = files
+			})
+
+			w := httptest.NewRecorder()
+			listCRDs(w, httptest.NewRequest("GET", "/v1/crds?kind="+tt.kind, nil))
+			assert.Equal(t, tt.wantCode, w.Code, "http status code should match")
+			if tt.wantCode != http.StatusOK {
+				return
+			}
+			var b struct {
+				Data []crdResponse
+			}
+			assert.NoError(t, json.NewDecoder(w.Body).Decode(&b))
+			var names []string
+			var conflicts []bool
+			for _, c := range b.Data {
+				names = append(names, c.Name)
+				conflicts = append(conflicts, c.Conflict)
+			}
+			assert.Equal(t, tt.wantNames, names, "CRDs should match")
+			assert.Equal(t, tt.conflicts, conflicts, "conflicts should match")
+		})
+	}
+
+	t.Run("charts", func(t *testing.T) {
+		var m mock.Mock
+		dbSession = mockstore.NewMockSession(&m)
+		var cf []models.ChartFiles
+		m.On("All", &cf).Run(func(args mock.Arguments) {
+			*args.Get(0).(*[]models.ChartFiles) = files
+		})
+		w := httptest.NewRecorder()
+		listCRDs(w, httptest.NewRequest("GET", "/v1/crds?kind=Certificate.cert-manager.io", nil))
+		var b struct {
+			Data []crdResponse
+		}
+		assert.NoError(t, json.NewDecoder(w.Body).Decode(&b))
+		assert.Equal(t, []crdProvider{
+			{Repo: "incubator", Chart: "legacy-certs", Version: "0.1.0", Versions: []string{"v1alpha1"}, Scope: "Namespaced", Path: "crds/certificates.yaml"},
+			{Repo: "stable", Chart: "cert-manager", Version: "1.0.0", Versions: []string{"v1"}, Scope: "Namespaced", Path: "crds/certificates.yaml"},
+		}, b.Data[0].Charts, "charts should match")
+	})
+}
+
+func Test_listChartVersionCRDs(t *testing.T) {
+	params := Params{"repo": "stable", "chartName": "cert-manager", "version": "1.0.0"}
+	tests := []struct {
+		name     string
+		files    models.ChartFiles
+		wantCRDs []models.CRD
+	}{
+		{"chart with CRDs", models.ChartFiles{ID: "stable/cert-manager-1.0.0", CRDs: []models.CRD{certificateCRD}}, []models.CRD{certificateCRD}},
+		{"chart without CRDs", models.ChartFiles{ID: "stable/cert-manager-1.0.0"}, []models.CRD{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			withChartFiles(tt.files)
+			w := httptest.NewRecorder()
+			listChartVersionCRDs(w, httptest.NewRequest("GET", "/", nil), params)
+			assert.Equal(t, http.StatusOK, w.Code, "http status code should match")
+			var b struct {
+				Data []models.CRD
+			}
+			assert.NoError(t, json.NewDecoder(w.Body).Decode(&b))
+			assert.Equal(t, tt.wantCRDs, b.Data, "CRDs should match")
+		})
+	}
+}
@@ -0,0 +1,175 @@
+/*
+Copyright (c) 2018 The Helm Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+
+	"github.com/globalsign/mgo/bson"
+	"github.com/helm/monocular/cmd/chartsvc/models"
+	"github.com/kubeapps/common/response"
+	log "github.com/sirupsen/logrus"
+)
+
+// defaultCursorPageSize is the number of charts returned per page when using
+// cursor pagination without a size
+const defaultCursorPageSize = 50
+
+// chartCursor points to a position in an ordered list of charts. It is sent to
+// clients as an opaque string.
+type chartCursor struct {
+	// Keys are the values of the sort fields at the position
+	Keys []string `json:"k"`
+	// Backward is set if the page before the position is requested
+	Backward bool `json:"b,omitempty"`
+}
+
+func (c chartCursor) String() string {
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// parseCursor decodes a cursor as returned by chartCursor.String. An empty
+// string refers to the first page.
+func parseCursor(s string) (*chartCursor, error) {
+	if s == "" {
+		return nil, nil
+	}
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	var c chartCursor
+	if err := json.Unmarshal(b, &c); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// cursorLinks holds the links to the pages next to the current one
+type cursorLinks struct {
+	Next string `json:"next,omitempty"`
+	Prev string `json:"prev,omitempty"`
+}
+
+// cursorMeta is the metadata of a cursor paginated list. The total number of
+// pages is not known when using cursors.
+type cursorMeta struct {
+	Facets *facets `json:"facets,omitempty"`
+}
+
+type cursorListResponse struct {
+	Data  apiListResponse `json:"data"`
+	Meta  *cursorMeta     `json:"meta,omitempty"`
+	Links cursorLinks     `json:"links"`
+}
+
+// getCursorChartList returns a page of charts starting at the given cursor (or
+// the first page if cur is nil). Unlike page numbers, cursors are not affected
+// by charts being added or removed from the previous pages.
+func getCursorChartList(q chartListQuery, cur *chartCursor, pageSize int) (charts []*models.Chart, hasPrev, hasNext bool, err error) {
+	db, closer := dbSession.DB()
+	defer closer()
+
+	pipeline := chartListPipeline(q)
+	backward := cur != nil && cur.Backward
+	if cur != nil {
+		after, err := q.Order.afterStage(cur.Keys, backward)
+		if err != nil {
+			return []*models.Chart{}, false, false, err
+		}
+		pipeline = append(pipeline, after)
+	}
+	// Fetch an extra chart to know if there are more pages
+	pipeline = append(pipeline, q.Order.sortStage(backward), bson.M{"$limit": pageSize + 1})
+
+	if err := db.C(chartCollection).Pipe(pipeline).All(&charts); err != nil {
+		return []*models.Chart{}, false, false, err
+	}
+
+	more := len(charts) > pageSize
+	if more {
+		charts = charts[:pageSize]
+	}
+	if backward {
+		// Restore the requested order
+		for i, j := 0, len(charts)-1; i < j; i, j = i+1, j-1 {
+			charts[i], charts[j] = charts[j], charts[i]
+		}
+		return charts, more, true, nil
+	}
+	return charts, cur != nil, more, nil
+}
+
+// cursorLink returns the URL of the request with the given cursor
+func cursorLink(req *http.Request, cur chartCursor) string {
+	u := *req.URL
+	q := u.Query()
+	q.Del("page")
+	q.Set("cursor", cur.String())
+	u.RawQuery = q.Encode()
+	return u.RequestURI()
+}
+
+// writeCursorChartList writes a page of the list of charts of a repository (or
+// all of them if repo is empty) along with the links to the adjacent pages
+func writeCursorChartList(w http.ResponseWriter, req *http.Request, q chartListQuery, pageSize int) {
+	cur, err := parseCursor(req.FormValue("cursor"))
+	if err == nil && cur != nil {
+		// The keys must match the sort order, the charts would not be
+		// fetched otherwise
+		_, err = q.Order.afterStage(cur.Keys, cur.Backward)
+	}
+	if err != nil {
+		response.NewErrorResponse(http.StatusBadRequest, "invalid cursor").Write(w)
+		return
+	}
+	if pageSize == 0 {
+		pageSize = defaultCursorPageSize
+	}
+
+	charts, hasPrev, hasNext, err := getCursorChartList(q, cur, pageSize)
+	var meta *cursorMeta
+	if err == nil && q.Facets {
+		meta = &cursorMeta{}
+		meta.Facets, err = getChartListFacets(q)
+	}
+	if err != nil {
+		log.WithError(err).Error("could not fetch charts")
+		response.NewErrorResponse(http.StatusInternalServerError, "could not fetch all charts").Write(w)
+		return
+	}
+
+	links := cursorLinks{}
+	if len(charts) > 0 {
+		first, last := charts[0], charts[len(charts)-1]
+		if hasNext {
+			links.Next = cursorLink(req, chartCursor{Keys: q.Order.keys(last)})
+		}
+		if hasPrev {
+			links.Prev = cursorLink(req, chartCursor{Keys: q.Order.keys(first), Backward: true})
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(cursorListResponse{newChartListResponse(charts), meta, links}); err != nil {
+		log.WithError(err).Error("could not write charts")
+	}
+}
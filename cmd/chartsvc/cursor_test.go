@@ -0,0 +1,171 @@
+/*
+Copyright (c) 2018 The Helm Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/helm/monocular/cmd/chartsvc/models"
+	"github.com/kubeapps/common/datastore/mockstore"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+type bodyCursorListResponse struct {
+	Data  *apiListResponse `json:"data"`
+	Links cursorLinks      `json:"links"`
+}
+
+func Test_parseCursor(t *testing.T) {
+	cur := chartCursor{Keys: []string{"wordpress", "stable/wordpress"}, Backward: true}
+	parsed, err := parseCursor(cur.String())
+	assert.NoError(t, err)
+	assert.Equal(t, cur, *parsed)
+
+	parsed, err = parseCursor("")
+	assert.NoError(t, err)
+	assert.Nil(t, parsed, "empty cursor should point to the first page")
+
+	_, err = parseCursor("not-a-cursor")
+	assert.Error(t, err)
+}
+
+func Test_listChartsWithCursor(t *testing.T) {
+	charts := []*models.Chart{
+		{ID: "stable/dokuwiki", Name: "dokuwiki", ChartVersions: []models.ChartVersion{{Version: "1.2.3", Digest: "1234"}}},
+		{ID: "stable/drupal", Name: "drupal", ChartVersions: []models.ChartVersion{{Version: "1.2.3", Digest: "12345"}}},
+		{ID: "stable/wordpress", Name: "wordpress", ChartVersions: []models.ChartVersion{{Version: "1.2.3", Digest: "123456"}}},
+	}
+	after := chartCursor{Keys: []string{"apache", "stable/apache"}}
+	before := chartCursor{Keys: []string{"zookeeper", "stable/zookeeper"}, Backward: true}
+
+	tests := []struct {
+		name     string
+		query    string
+		charts   []*models.Chart
+		wantLen  int
+		wantNext *chartCursor
+		wantPrev *chartCursor
+	}{
+		{"first page with more pages", "?cursor=&size=2", charts, 2,
+			&chartCursor{Keys: []string{"drupal", "stable/drupal"}}, nil},
+		{"first page without more pages", "?cursor=&size=3", charts, 3, nil, nil},
+		{"next page", "?cursor=" + after.String() + "&size=3", charts, 3,
+			nil, &chartCursor{Keys: []string{"dokuwiki", "stable/dokuwiki"}, Backward: true}},
+		{"previous page", "?cursor=" + before.String() + "&size=2", []*models.Chart{charts[2], charts[1], charts[0]}, 2,
+			&chartCursor{Keys: []string{"wordpress", "stable/wordpress"}}, &chartCursor{Keys: []string{"drupal", "stable/drupal"}, Backward: true}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var m mock.Mock
+			dbSession = mockstore.NewMockSession(&m)
+			m.On("All", &repoChecks)
+			m.On("All", &chartsList).Run(func(args mock.Arguments) {
+				*args.Get(0).(*[]*models.Chart) = tt.charts
+			})
+
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest("GET", "/v1/charts"+tt.query, nil)
+			listCharts(w, req)
+
+			m.AssertExpectations(t)
+			assert.Equal(t, http.StatusOK, w.Code)
+
+			var b bodyCursorListResponse
+			json.NewDecoder(w.Body).Decode(&b)
+			assert.Len(t, *b.Data, tt.wantLen)
+			assertCursorLink(t, tt.wantNext, b.Links.Next)
+			assertCursorLink(t, tt.wantPrev, b.Links.Prev)
+		})
+	}
+
+	t.Run("invalid cursor", func(t *testing.T) {
+		var m mock.Mock
+		dbSession = mockstore.NewMockSession(&m)
+		m.On("All", &repoChecks)
+
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "/v1/charts?cursor=not-a-cursor", nil)
+		listCharts(w, req)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+}
+
+func assertCursorLink(t *testing.T, want *chartCursor, link string) {
+	if want == nil {
+		assert.Empty(t, link, "link should not be set")
+		return
+	}
+	u, err := url.Parse(link)
+	assert.NoError(t, err)
+	assert.Equal(t, "/v1/charts", u.Path)
+	cur, err := parseCursor(u.Query().Get("cursor"))
+	assert.NoError(t, err)
+	assert.Equal(t, *want, *cur)
+}
+
+func Test_listChartsWithCursorSorted(t *testing.T) {
+	created := time.Date(2019, 3, 1, 10, 0, 0, 0, time.UTC)
+	charts := []*models.Chart{
+		{ID: "stable/dokuwiki", Name: "dokuwiki", ChartVersions: []models.ChartVersion{{Version: "1.2.3", Digest: "1234", Created: created}}},
+		{ID: "stable/drupal", Name: "drupal", ChartVersions: []models.ChartVersion{{Version: "1.2.3", Digest: "12345", Created: created}}},
+	}
+
+	var m mock.Mock
+	dbSession = mockstore.NewMockSession(&m)
+	m.On("All", &repoChecks)
+	m.On("All", &chartsList).Run(func(args mock.Arguments) {
+		*args.Get(0).(*[]*models.Chart) = charts
+	})
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/v1/charts?cursor=&size=1&sort=-updated", nil)
+	listCharts(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var b bodyCursorListResponse
+	json.NewDecoder(w.Body).Decode(&b)
+	u, _ := url.Parse(b.Links.Next)
+	assert.Equal(t, "-updated", u.Query().Get("sort"), "sort should be kept in the links")
+	assertCursorLink(t, &chartCursor{Keys: []string{created.Format(time.RFC3339Nano), "stable/dokuwiki"}}, b.Links.Next)
+
+	t.Run("cursor from another order", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		cur := chartCursor{Keys: []string{"a", "b", "c"}}
+		req := httptest.NewRequest("GET", "/v1/charts?sort=-updated&cursor="+cur.String(), nil)
+		listCharts(w, req)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+	t.Run("tampered cursor", func(t *testing.T) {
+		// the charts are not queried
+		var m mock.Mock
+		dbSession = mockstore.NewMockSession(&m)
+		m.On("All", &repoChecks)
+		w := httptest.NewRecorder()
+		cur := chartCursor{Keys: []string{"yesterday", "stable/dokuwiki"}}
+		req := httptest.NewRequest("GET", "/v1/charts?sort=-updated&cursor="+cur.String(), nil)
+		listCharts(w, req)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+}
@@ -0,0 +1,318 @@
+/*
+Copyright (c) 2018 The Helm Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/globalsign/mgo/bson"
+	"github.com/helm/monocular/cmd/chartsvc/models"
+	"github.com/kubeapps/common/response"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// defaultEventsPeriod is how far back events are listed if since is not set
+	defaultEventsPeriod = 7 * 24 * time.Hour
+	// maxEvents is the maximum number of events returned by the events API
+	maxEvents = 1000
+	// feedSize is the number of entries in the Atom and RSS feeds
+	feedSize = 50
+)
+
+// getEvents returns the latest events matching the query, newest first
+func getEvents(match bson.M, limit int) ([]models.Event, error) {
+	db, closer := dbSession.DB()
+	defer closer()
+	events := []models.Event{}
+	err := db.C(eventsCollection).Pipe([]bson.M{
+		{"$match": match},
+		{"$sort": bson.D{{Name: "time", Value: -1}, {Name: "_id", Value: -1}}},
+		{"$limit": limit},
+	}).All(&events)
+	return events, err
+}
+
+// getEventsAfter returns the events matching the query oldest first, starting
+// after the event at the cursor if one is given, and whether more events
+// follow
+func getEventsAfter(match bson.M, cur *chartCursor, limit int) ([]models.Event, bool, error) {
+	db, closer := dbSession.DB()
+	defer closer()
+	if cur != nil {
+		t, id, err := eventCursorKeys(cur)
+		if err != nil {
+			return nil, false, err
+		}
+		match = bson.M{"$and": []bson.M{match, {"$or": []bson.M{
+			{"time": bson.M{"$gt": t}},
+			{"time": t, "_id": bson.M{"$gt": id}},
+		}}}}
+	}
+	events := []models.Event{}
+	// Fetch an extra event to know if there are more
+	err := db.C(eventsCollection).Pipe([]bson.M{
+		{"$match": match},
+		{"$sort": bson.D{{Name: "time", Value: 1}, {Name: "_id", Value: 1}}},
+		{"$limit": limit + 1},
+	}).All(&events)
+	if err != nil {
+		return nil, false, err
+	}
+	more := len(events) > limit
+	if more {
+		events = events[:limit]
+	}
+	return events, more, nil
+}
+
+// eventCursor points after the event in the list of events
+func eventCursor(e models.Event) chartCursor {
+	return chartCursor{Keys: []string{e.Time.UTC().Format(time.RFC3339Nano), e.ID.Hex()}}
+}
+
+// eventCursorKeys returns the time and ID of the event a cursor points after
+func eventCursorKeys(cur *chartCursor) (time.Time, bson.ObjectId, error) {
+	if len(cur.Keys) != 2 || !bson.IsObjectIdHex(cur.Keys[1]) {
+		return time.Time{}, "", errors.New("cursor does not point to an event")
+	}
+	t, err := time.Parse(time.RFC3339Nano, cur.Keys[0])
+	if err != nil {
+		return time.Time{}, "", err
+	}
+	return t, bson.ObjectIdHex(cur.Keys[1]), nil
+}
+
+type eventListResponse struct {
+	Data  []models.Event `json:"data"`
+	Links cursorLinks    `json:"links"`
+}
+
+// listEvents returns the events that happened after the since query param,
+// optionally filtered by repo and chart name, oldest first. At most maxEvents
+// are returned, the next link points to the following ones.
+func listEvents(w http.ResponseWriter, req *http.Request) {
+	since := time.Now().Add(-defaultEventsPeriod)
+	if s := req.FormValue("since"); s != "" {
+		t, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			response.NewErrorResponse(http.StatusBadRequest, "invalid value for since, expected an RFC 3339 date").Write(w)
+			return
+		}
+		since = t
+	}
+	cur, err := parseCursor(req.FormValue("cursor"))
+	if err == nil && cur != nil {
+		_, _, err = eventCursorKeys(cur)
+	}
+	if err != nil {
+		response.NewErrorResponse(http.StatusBadRequest, "invalid cursor").Write(w)
+		return
+	}
+
+	match := bson.M{"time": bson.M{"$gt": since}}
+	if repo := req.FormValue("repo"); repo != "" {
+		match["repo"] = repo
+	}
+	if name := req.FormValue("chart"); name != "" {
+		match["name"] = name
+	}
+
+	events, more, err := getEventsAfter(match, cur, maxEvents)
+	if err != nil {
+		log.WithError(err).Error("could not fetch events")
+		response.NewErrorResponse(http.StatusInternalServerError, "could not fetch events").Write(w)
+		return
+	}
+	links := cursorLinks{}
+	if more {
+		links.Next = cursorLink(req, eventCursor(events[len(events)-1]))
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(eventListResponse{events, links}); err != nil {
+		log.WithError(err).Error("could not write events")
+	}
+}
+
+// getRepoEventFeed returns an Atom or RSS feed of the events of a repository
+func getRepoEventFeed(w http.ResponseWriter, req *http.Request, params Params) {
+	writeEventFeed(w, req, params["format"], "Charts in "+params["repo"], bson.M{"repo": params["repo"]})
+}
+
+// getChartEventFeed returns an Atom or RSS feed of the events of a chart
+func getChartEventFeed(w http.ResponseWriter, req *http.Request, params Params) {
+	chartID := fmt.Sprintf("%s/%s", params["repo"], params["chartName"])
+	writeEventFeed(w, req, params["format"], "Releases of "+chartID, bson.M{"chart": chartID})
+}
+
+func writeEventFeed(w http.ResponseWriter, req *http.Request, format, title string, match bson.M) {
+	events, err := getEvents(match, feedSize)
+	if err != nil {
+		log.WithError(err).Error("could not fetch events")
+		response.NewErrorResponse(http.StatusInternalServerError, "could not fetch events").Write(w)
+		return
+	}
+
+	v := cacheValidators{}
+	ids := []string{format}
+	for _, e := range events {
+		ids = append(ids, e.ID.Hex())
+	}
+	v.ETag = newETag(ids...)
+	if len(events) > 0 {
+		v.LastModified = events[0].Time
+	}
+	if checkNotModified(w, req, cacheControlDefault, v) {
+		return
+	}
+
+	base := requestBaseURL(req)
+	var feed interface{}
+	contentType := "application/atom+xml; charset=UTF-8"
+	if format == "rss" {
+		feed = newRSSFeed(base, req.URL.Path, title, events)
+		contentType = "application/rss+xml; charset=UTF-8"
+	} else {
+		feed = newAtomFeed(base, req.URL.Path, title, events)
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Write([]byte(xml.Header))
+	if err := xml.NewEncoder(w).Encode(feed); err != nil {
+		log.WithError(err).Error("could not encode feed")
+	}
+}
+
+// requestBaseURL returns the scheme and host the request was sent to
+func requestBaseURL(req *http.Request) string {
+	scheme := "http"
+	if req.TLS != nil {
+		scheme = "https"
+	}
+	if proto := req.Header.Get("X-Forwarded-Proto"); proto != "" {
+		scheme = proto
+	}
+	return scheme + "://" + req.Host
+}
+
+// eventSummary describes an event in a sentence
+func eventSummary(e models.Event) string {
+	switch e.Type {
+	case "chart_added":
+		return fmt.Sprintf("%s %s was added to %s", e.Name, e.Version, e.Repo)
+	case "version_added":
+		return fmt.Sprintf("%s %s was released in %s", e.Name, e.Version, e.Repo)
+	case "chart_removed":
+		return fmt.Sprintf("%s was removed from %s", e.Name, e.Repo)
+	}
+	return fmt.Sprintf("%s: %s", e.Type, e.Chart)
+}
+
+// eventLink returns the API URL of the chart or chart version of the event
+func eventLink(base string, e models.Event) string {
+	link := base + pathPrefix + "/charts/" + e.Chart
+	if e.Version != "" && e.Type != "chart_removed" {
+		link += "/versions/" + e.Version
+	}
+	return link
+}
+
+type atomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr,omitempty"`
+}
+
+type atomEntry struct {
+	Title   string   `xml:"title"`
+	ID      string   `xml:"id"`
+	Updated string   `xml:"updated"`
+	Link    atomLink `xml:"link"`
+	Summary string   `xml:"summary"`
+}
+
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	Title   string      `xml:"title"`
+	ID      string      `xml:"id"`
+	Updated string      `xml:"updated"`
+	Link    atomLink    `xml:"link"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+func newAtomFeed(base, path, title string, events []models.Event) atomFeed {
+	f := atomFeed{
+		Title:   title,
+		ID:      base + path,
+		Updated: time.Now().UTC().Format(time.RFC3339),
+		Link:    atomLink{Href: base + path, Rel: "self"},
+	}
+	if len(events) > 0 {
+		f.Updated = events[0].Time.UTC().Format(time.RFC3339)
+	}
+	for _, e := range events {
+		f.Entries = append(f.Entries, atomEntry{
+			Title:   eventSummary(e),
+			ID:      "urn:monocular:event:" + e.ID.Hex(),
+			Updated: e.Time.UTC().Format(time.RFC3339),
+			Link:    atomLink{Href: eventLink(base, e)},
+			Summary: eventSummary(e),
+		})
+	}
+	return f
+}
+
+type rssItem struct {
+	Title       string `xml:"title"`
+	Link        string `xml:"link"`
+	GUID        string `xml:"guid"`
+	PubDate     string `xml:"pubDate"`
+	Description string `xml:"description"`
+}
+
+type rssChannel struct {
+	Title       string    `xml:"title"`
+	Link        string    `xml:"link"`
+	Description string    `xml:"description"`
+	Items       []rssItem `xml:"item"`
+}
+
+type rssFeed struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	Channel rssChannel `xml:"channel"`
+}
+
+func newRSSFeed(base, path, title string, events []models.Event) rssFeed {
+	f := rssFeed{Version: "2.0", Channel: rssChannel{Title: title, Link: base + path, Description: title}}
+	for _, e := range events {
+		f.Channel.Items = append(f.Channel.Items, rssItem{
+			Title:       eventSummary(e),
+			Link:        eventLink(base, e),
+			GUID:        "urn:monocular:event:" + e.ID.Hex(),
+			PubDate:     e.Time.UTC().Format(time.RFC1123Z),
+			Description: eventSummary(e),
+		})
+	}
+	return f
+}
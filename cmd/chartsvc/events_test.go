@@ -0,0 +1,163 @@
+/*
+Copyright (c) 2018 The Helm Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/globalsign/mgo/bson"
+	"github.com/helm/monocular/cmd/chartsvc/models"
+	"github.com/kubeapps/common/datastore/mockstore"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+var testEvents = []models.Event{
+	{ID: bson.NewObjectId(), Type: "version_added", Repo: "stable", Chart: "stable/wordpress", Name: "wordpress", Version: "0.7.5", Time: time.Date(2018, 10, 2, 0, 0, 0, 0, time.UTC)},
+	{ID: bson.NewObjectId(), Type: "chart_added", Repo: "stable", Chart: "stable/wordpress", Name: "wordpress", Version: "0.7.4", Time: time.Date(2018, 10, 1, 0, 0, 0, 0, time.UTC)},
+}
+
+func Test_listEvents(t *testing.T) {
+	tests := []struct {
+		name     string
+		query    string
+		wantCode int
+	}{
+		{"default period", "", http.StatusOK},
+		{"since date", "?since=2018-10-01T00:00:00Z&repo=stable&chart=wordpress", http.StatusOK},
+		{"invalid since", "?since=yesterday", http.StatusBadRequest},
+		{"next page", "?cursor=" + eventCursor(testEvents[1]).String(), http.StatusOK},
+		{"invalid cursor", "?cursor=" + chartCursor{Keys: []string{"yesterday", "1"}}.String(), http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var m mock.Mock
+			dbSession = mockstore.NewMockSession(&m)
+			if tt.wantCode == http.StatusOK {
+				m.On("All", &[]models.Event{}).Run(func(args mock.Arguments) {
+					*args.Get(0).(*[]models.Event) = testEvents
+				})
+			}
+
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest("GET", "/v1/events"+tt.query, nil)
+			listEvents(w, req)
+
+			m.AssertExpectations(t)
+			assert.Equal(t, tt.wantCode, w.Code)
+			if tt.wantCode == http.StatusOK {
+				var b struct {
+					Data []models.Event `json:"data"`
+				}
+				json.NewDecoder(w.Body).Decode(&b)
+				assert.Equal(t, testEvents, b.Data)
+			}
+		})
+	}
+}
+
+func Test_listEventsNextPage(t *testing.T) {
+	start := time.Date(2018, 10, 1, 0, 0, 0, 0, time.UTC)
+	var events []models.Event
+	for i := 0; i <= maxEvents; i++ {
+		events = append(events, models.Event{ID: bson.NewObjectId(), Type: "version_added", Repo: "stable", Time: start.Add(time.Duration(i) * time.Second)})
+	}
+
+	var m mock.Mock
+	dbSession = mockstore.NewMockSession(&m)
+	m.On("All", &[]models.Event{}).Run(func(args mock.Arguments) {
+		*args.Get(0).(*[]models.Event) = events
+	})
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/v1/events?repo=stable", nil)
+	listEvents(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	var b struct {
+		Data  []models.Event `json:"data"`
+		Links cursorLinks    `json:"links"`
+	}
+	json.NewDecoder(w.Body).Decode(&b)
+	assert.Len(t, b.Data, maxEvents, "number of events")
+	assert.Equal(t, "/v1/events?cursor="+eventCursor(events[maxEvents-1]).String()+"&repo=stable", b.Links.Next)
+
+	cur, err := parseCursor(eventCursor(events[maxEvents-1]).String())
+	assert.NoError(t, err)
+	at, id, err := eventCursorKeys(cur)
+	assert.NoError(t, err)
+	assert.True(t, at.Equal(events[maxEvents-1].Time), "cursor time")
+	assert.Equal(t, events[maxEvents-1].ID, id, "cursor event")
+}
+
+func Test_getChartEventFeed(t *testing.T) {
+	tests := []struct {
+		format          string
+		wantContentType string
+	}{
+		{"atom", "application/atom+xml; charset=UTF-8"},
+		{"rss", "application/rss+xml; charset=UTF-8"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			var m mock.Mock
+			dbSession = mockstore.NewMockSession(&m)
+			m.On("All", &[]models.Event{}).Run(func(args mock.Arguments) {
+				*args.Get(0).(*[]models.Event) = testEvents
+			})
+
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest("GET", "http://monocular.example.com/v1/events/stable/wordpress/feed."+tt.format, nil)
+			getChartEventFeed(w, req, Params{"repo": "stable", "chartName": "wordpress", "format": tt.format})
+
+			m.AssertExpectations(t)
+			assert.Equal(t, http.StatusOK, w.Code)
+			assert.Equal(t, tt.wantContentType, w.Header().Get("Content-Type"))
+			assert.Equal(t, "Tue, 02 Oct 2018 00:00:00 GMT", w.Header().Get("Last-Modified"))
+
+			var links []string
+			if tt.format == "atom" {
+				var f atomFeed
+				assert.NoError(t, xml.NewDecoder(w.Body).Decode(&f))
+				assert.Equal(t, "Releases of stable/wordpress", f.Title)
+				for _, e := range f.Entries {
+					links = append(links, e.Link.Href)
+				}
+				assert.Equal(t, "wordpress 0.7.5 was released in stable", f.Entries[0].Title)
+			} else {
+				var f rssFeed
+				assert.NoError(t, xml.NewDecoder(w.Body).Decode(&f))
+				assert.Equal(t, "2.0", f.Version)
+				for _, i := range f.Channel.Items {
+					links = append(links, i.Link)
+				}
+				assert.Equal(t, "Tue, 02 Oct 2018 00:00:00 +0000", f.Channel.Items[0].PubDate)
+			}
+			assert.Equal(t, []string{
+				"http://monocular.example.com/v1/charts/stable/wordpress/versions/0.7.5",
+				"http://monocular.example.com/v1/charts/stable/wordpress/versions/0.7.4",
+			}, links)
+		})
+	}
+}
@@ -0,0 +1,94 @@
+/*
+Copyright (c) 2018 The Helm Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/helm/monocular/cmd/chartsvc/models"
+	"github.com/kubeapps/common/response"
+	log "github.com/sirupsen/logrus"
+)
+
+// chartFileResponse is an entry of the file listing of a chart version, stored
+// is true if the content of the file can be fetched
+type chartFileResponse struct {
+	models.ChartFile
+	Stored bool `json:"stored"`
+}
+
+// hasContent returns true if the content of the file was stored by chart-repo,
+// which only stores small text files
+func hasContent(f models.ChartFile) bool {
+	return f.Content != "" || f.Key != "" || f.Size == 0
+}
+
+// listChartVersionFiles returns the listing of the files of a chart version
+func listChartVersionFiles(w http.ResponseWriter, req *http.Request, params Params) {
+	db, closer := dbSession.DB()
+	defer closer()
+	fileID := fmt.Sprintf("%s/%s-%s", params["repo"], params["chartName"], params["version"])
+	files, err := findChartFiles(db, fileID)
+	if err != nil {
+		log.WithError(err).Errorf("could not find files with id %s", fileID)
+		http.NotFound(w, req)
+		return
+	}
+	if checkNotModified(w, req, cacheControlVersioned, chartFilesValidators(files, "files")) {
+		return
+	}
+	listing := []chartFileResponse{}
+	for _, f := range files.Files {
+		listing = append(listing, chartFileResponse{f, hasContent(f)})
+	}
+	response.NewDataResponse(listing).Write(w)
+}
+
+// getChartVersionFile returns a file of a chart version, as plain text
+func getChartVersionFile(w http.ResponseWriter, req *http.Request, params Params) {
+	db, closer := dbSession.DB()
+	defer closer()
+	fileID := fmt.Sprintf("%s/%s-%s", params["repo"], params["chartName"], params["version"])
+	files, err := findChartFiles(db, fileID)
+	if err != nil {
+		log.WithError(err).Errorf("could not find files with id %s", fileID)
+		http.NotFound(w, req)
+		return
+	}
+	var file *models.ChartFile
+	for i, f := range files.Files {
+		if f.Path == params["path"] {
+			file = &files.Files[i]
+			break
+		}
+	}
+	if file == nil || !hasContent(*file) {
+		log.Errorf("could not find the content of %s for id %s", params["path"], fileID)
+		http.NotFound(w, req)
+		return
+	}
+
+	if checkNotModified(w, req, cacheControlVersioned, chartFilesValidators(files, "files/"+file.Path)) {
+		return
+	}
+	// the files are served as text so that templates or scripts of the charts
+	// are not rendered by browsers
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	writeBlob(w, req, []byte(file.Content), file.Key)
+}
@@ -0,0 +1,93 @@
+/*
+Copyright (c) 2018 The Helm Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/helm/monocular/cmd/chartsvc/models"
+	"github.com/kubeapps/common/datastore/mockstore"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+var testChartFiles = models.ChartFiles{
+	ID:     "my-repo/my-chart-1.0.0",
+	Digest: "123",
+	Files: []models.ChartFile{
+		{Path: "Chart.yaml", Size: 14, Mode: 0644, Content: "name: my-chart"},
+		{Path: "templates/NOTES.txt", Size: 0, Mode: 0644},
+		{Path: "files/logo.png", Size: 2048, Mode: 0644},
+	},
+}
+
+func withChartFiles(files models.ChartFiles) {
+	var m mock.Mock
+	dbSession = mockstore.NewMockSession(&m)
+	m.On("One", &models.ChartFiles{}).Return(nil).Run(func(args mock.Arguments) {
+		*args.Get(0).(*models.ChartFiles) = files
+	})
+}
+
+func Test_listChartVersionFiles(t *testing.T) {
+	withChartFiles(testChartFiles)
+	w := httptest.NewRecorder()
+	params := Params{"repo": "my-repo", "chartName": "my-chart", "version": "1.0.0"}
+	listChartVersionFiles(w, httptest.NewRequest("GET", "/", nil), params)
+	assert.Equal(t, http.StatusOK, w.Code, "http status code should match")
+
+	var b struct {
+		Data []map[string]interface{}
+	}
+	assert.NoError(t, json.NewDecoder(w.Body).Decode(&b))
+	assert.Equal(t, []map[string]interface{}{
+		{"path": "Chart.yaml", "size": float64(14), "mode": float64(0644), "stored": true},
+		{"path": "templates/NOTES.txt", "size": float64(0), "mode": float64(0644), "stored": true},
+		{"path": "files/logo.png", "size": float64(2048), "mode": float64(0644), "stored": false},
+	}, b.Data, "listing should match")
+}
+
+func Test_getChartVersionFile(t *testing.T) {
+	tests := []struct {
+		name     string
+		path     string
+		wantCode int
+		wantBody string
+	}{
+		{"text file", "Chart.yaml", http.StatusOK, "name: my-chart"},
+		{"empty file", "templates/NOTES.txt", http.StatusOK, ""},
+		{"file without content", "files/logo.png", http.StatusNotFound, ""},
+		{"missing file", "LICENSE", http.StatusNotFound, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			withChartFiles(testChartFiles)
+			w := httptest.NewRecorder()
+			params := Params{"repo": "my-repo", "chartName": "my-chart", "version": "1.0.0", "path": tt.path}
+			getChartVersionFile(w, httptest.NewRequest("GET", "/", nil), params)
+			assert.Equal(t, tt.wantCode, w.Code, "http status code should match")
+			if tt.wantCode == http.StatusOK {
+				assert.Equal(t, tt.wantBody, w.Body.String(), "body should match")
+				assert.Equal(t, "text/plain; charset=utf-8", w.Header().Get("Content-Type"), "content type should match")
+				assert.Equal(t, "nosniff", w.Header().Get("X-Content-Type-Options"), "content type options should match")
+			}
+		})
+	}
+}
@@ -17,10 +17,12 @@ limitations under the License.
 package main
 
 import (
+	"errors"
 	"fmt"
 	"math"
 	"net/http"
 	"strconv"
+	"strings"
 
 	"github.com/globalsign/mgo/bson"
 	"github.com/gorilla/mux"
@@ -42,6 +44,12 @@ func (h WithParams) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 
 const chartCollection = "charts"
 const filesCollection = "files"
+const contentsCollection = "file_contents"
+const repositoryCollection = "repos"
+const yankedCollection = "yanked"
+const eventsCollection = "events"
+const webhooksCollection = "webhooks"
+const webhookDeliveriesCollection = "webhook_deliveries"
 
 type apiResponse struct {
 	ID            string      `json:"id"`
@@ -90,6 +98,11 @@ func showDuplicates(req *http.Request) bool {
 	return len(req.FormValue("showDuplicates")) > 0
 }
 
+// includeDeprecated returns if a request wants to retrieve deprecated charts. Default false
+func includeDeprecated(req *http.Request) bool {
+	return len(req.FormValue("includeDeprecated")) > 0
+}
+
 // min returns the minimum of two integers.
 // We are not using math.Min since that compares float64
 // and it's unnecessarily complex.
@@ -115,31 +128,13 @@ func uniqChartList(charts []*models.Chart) []*models.Chart {
 	return res
 }
 
-func getPaginatedChartList(repo string, pageNumber, pageSize int, showDuplicates bool) (apiListResponse, interface{}, error) {
+func getPaginatedChartList(q chartListQuery, pageNumber, pageSize int) ([]*models.Chart, interface{}, error) {
 	db, closer := dbSession.DB()
 	defer closer()
 	var charts []*models.Chart
 
 	c := db.C(chartCollection)
-	pipeline := []bson.M{}
-	if repo != "" {
-		pipeline = append(pipeline, bson.M{"$match": bson.M{"repo.name": repo}})
-	}
-
-	if !showDuplicates {
-		// We should query unique charts
-		pipeline = append(pipeline,
-			// Add a new field to store the latest version
-			bson.M{"$addFields": bson.M{"firstChartVersion": bson.M{"$arrayElemAt": []interface{}{"$chartversions", 0}}}},
-			// Group by unique digest for the latest version (remove duplicates)
-			bson.M{"$group": bson.M{"_id": "$firstChartVersion.digest", "chart": bson.M{"$first": "$$ROOT"}}},
-			// Restore original object struct
-			bson.M{"$replaceRoot": bson.M{"newRoot": "$chart"}},
-		)
-	}
-
-	// Order by name
-	pipeline = append(pipeline, bson.M{"$sort": bson.M{"name": 1}})
+	pipeline := append(chartListPipeline(q), q.Order.sortStage(false))
 
 	totalPages := 1
 	if pageSize != 0 {
@@ -149,7 +144,7 @@ func getPaginatedChartList(repo string, pageNumber, pageSize int, showDuplicates
 		cc := count{}
 		err := c.Pipe(countPipeline).One(&cc)
 		if err != nil {
-			return apiListResponse{}, 0, err
+			return []*models.Chart{}, 0, err
 		}
 		totalPages = int(math.Ceil(float64(cc.Count) / float64(pageSize)))
 
@@ -165,34 +160,85 @@ func getPaginatedChartList(repo string, pageNumber, pageSize int, showDuplicates
 	}
 	err := c.Pipe(pipeline).All(&charts)
 	if err != nil {
-		return apiListResponse{}, 0, err
+		return []*models.Chart{}, 0, err
 	}
 
-	return newChartListResponse(charts), meta{totalPages}, nil
+	meta, err := newListMeta(q, totalPages)
+	if err != nil {
+		return []*models.Chart{}, 0, err
+	}
+	return charts, meta, nil
 }
 
-// listCharts returns a list of charts
-func listCharts(w http.ResponseWriter, req *http.Request) {
-	pageNumber, pageSize := getPageNumberAndSize(req)
-	cl, meta, err := getPaginatedChartList("", pageNumber, pageSize, showDuplicates(req))
+// streamChartList writes the full list of charts as it is read from the
+// database, so memory use does not grow with the size of the catalog
+func streamChartList(w http.ResponseWriter, q chartListQuery) {
+	meta, err := newListMeta(q, 1)
 	if err != nil {
-		log.WithError(err).Error("could not fetch charts")
+		log.WithError(err).Error("could not fetch chart facets")
 		response.NewErrorResponse(http.StatusInternalServerError, "could not fetch all charts").Write(w)
 		return
 	}
-	response.NewDataResponseWithMeta(cl, meta).Write(w)
+
+	db, closer := dbSession.DB()
+	defer closer()
+
+	pipeline := append(chartListPipeline(q), q.Order.sortStage(false))
+	it := iterPipe(db.C(chartCollection).Pipe(pipeline))
+	if err := writeChartListStream(w, it, meta); err != nil {
+		log.WithError(err).Error("could not fetch charts")
+		if err == errStreamNotStarted {
+			response.NewErrorResponse(http.StatusInternalServerError, "could not fetch all charts").Write(w)
+		}
+	}
 }
 
-// listRepoCharts returns a list of charts in the given repo
-func listRepoCharts(w http.ResponseWriter, req *http.Request, params Params) {
+// writeChartList writes the list of charts of a repository (or all of them if
+// repo is empty). The list is paginated if a page size is given.
+func writeChartList(w http.ResponseWriter, req *http.Request, repo string) {
+	q, err := parseChartListQuery(req, repo)
+	if err != nil {
+		response.NewErrorResponse(http.StatusBadRequest, err.Error()).Write(w)
+		return
+	}
+
+	// The catalog only changes when a repository is synced, so the list can be
+	// validated without querying the charts
+	v, err := repoValidators(repo, req.URL.RawQuery)
+	if err != nil {
+		log.WithError(err).Error("could not fetch repository checksums")
+	}
+	if checkNotModified(w, req, cacheControlDefault, v) {
+		return
+	}
+
 	pageNumber, pageSize := getPageNumberAndSize(req)
-	cl, meta, err := getPaginatedChartList(params["repo"], pageNumber, pageSize, showDuplicates(req))
+	if _, ok := req.URL.Query()["cursor"]; ok {
+		writeCursorChartList(w, req, q, pageSize)
+		return
+	}
+	if pageSize == 0 {
+		streamChartList(w, q)
+		return
+	}
+
+	charts, meta, err := getPaginatedChartList(q, pageNumber, pageSize)
 	if err != nil {
 		log.WithError(err).Error("could not fetch charts")
 		response.NewErrorResponse(http.StatusInternalServerError, "could not fetch all charts").Write(w)
 		return
 	}
-	response.NewDataResponseWithMeta(cl, meta).Write(w)
+	response.NewDataResponseWithMeta(newChartListResponse(charts), meta).Write(w)
+}
+
+// listCharts returns a list of charts
+func listCharts(w http.ResponseWriter, req *http.Request) {
+	writeChartList(w, req, "")
+}
+
+// listRepoCharts returns a list of charts in the given repo
+func listRepoCharts(w http.ResponseWriter, req *http.Request, params Params) {
+	writeChartList(w, req, params["repo"])
 }
 
 // getChart returns the chart from the given repo
@@ -201,12 +247,16 @@ func getChart(w http.ResponseWriter, req *http.Request, params Params) {
 	defer closer()
 	var chart models.Chart
 	chartID := fmt.Sprintf("%s/%s", params["repo"], params["chartName"])
-	if err := db.C(chartCollection).FindId(chartID).One(&chart); err != nil {
+	if err := db.C(chartCollection).FindId(chartID).Select(chartFields).One(&chart); err != nil {
 		log.WithError(err).Errorf("could not find chart with id %s", chartID)
 		response.NewErrorResponse(http.StatusNotFound, "could not find chart").Write(w)
 		return
 	}
 
+	if checkNotModified(w, req, cacheControlDefault, chartValidators(&chart)) {
+		return
+	}
+
 	cr := newChartResponse(&chart)
 	response.NewDataResponse(cr).Write(w)
 }
@@ -217,12 +267,22 @@ func listChartVersions(w http.ResponseWriter, req *http.Request, params Params)
 	defer closer()
 	var chart models.Chart
 	chartID := fmt.Sprintf("%s/%s", params["repo"], params["chartName"])
-	if err := db.C(chartCollection).FindId(chartID).One(&chart); err != nil {
+	if err := db.C(chartCollection).FindId(chartID).Select(chartFields).One(&chart); err != nil {
 		log.WithError(err).Errorf("could not find chart with id %s", chartID)
 		response.NewErrorResponse(http.StatusNotFound, "could not find chart").Write(w)
 		return
 	}
 
+	v := chartValidators(&chart)
+	digests := []string{chart.ID}
+	for _, cv := range chart.ChartVersions {
+		digests = append(digests, cv.Version, cv.Digest, strconv.FormatBool(cv.Deprecated), strconv.FormatBool(cv.Yanked))
+	}
+	v.ETag = newETag(digests...)
+	if checkNotModified(w, req, cacheControlDefault, v) {
+		return
+	}
+
 	cvl := newChartVersionListResponse(&chart)
 	response.NewDataResponse(cvl).Write(w)
 }
@@ -238,6 +298,7 @@ func getChartVersion(w http.ResponseWriter, req *http.Request, params Params) {
 		"chartversions": bson.M{"$elemMatch": bson.M{"version": params["version"]}},
 	}).Select(bson.M{
 		"name": 1, "repo": 1, "description": 1, "home": 1, "keywords": 1, "maintainers": 1, "sources": 1,
+		"deprecated": 1, "replacedby": 1,
 		"chartversions.$": 1,
 	}).One(&chart); err != nil {
 		log.WithError(err).Errorf("could not find chart with id %s", chartID)
@@ -245,6 +306,10 @@ func getChartVersion(w http.ResponseWriter, req *http.Request, params Params) {
 		return
 	}
 
+	if checkNotModified(w, req, cacheControlVersioned, chartVersionValidators(&chart, chart.ChartVersions[0])) {
+		return
+	}
+
 	cvr := newChartVersionResponse(&chart, chart.ChartVersions[0])
 	response.NewDataResponse(cvr).Write(w)
 }
@@ -261,68 +326,165 @@ func getChartIcon(w http.ResponseWriter, req *http.Request, params Params) {
 		return
 	}
 
-	if chart.RawIcon == nil {
+	if chart.RawIcon == nil && chart.IconKey == "" {
 		http.NotFound(w, req)
 		return
 	}
 
-	if chart.IconContentType != "" {
+	icon, key, contentType := chart.RawIcon, chart.IconKey, chart.IconContentType
+	if req.FormValue("size") != "" || req.FormValue("format") != "" {
+		variant, err := selectIconVariant(req, chart.IconVariants)
+		if err != nil {
+			response.NewErrorResponse(http.StatusBadRequest, err.Error()).Write(w)
+			return
+		}
+		// SVG icons and icons imported before variants existed only have the
+		// original icon
+		if variant != nil {
+			icon, key, contentType = variant.Data, variant.Key, variant.ContentType
+		}
+	}
+
+	v := chartValidators(&chart)
+	v.ETag = blobETag(icon, key)
+	if checkNotModified(w, req, cacheControlDefault, v) {
+		return
+	}
+
+	if contentType != "" {
 		// Force the Content-Type header because the autogenerated type does not work for
 		// image/svg+xml. It is detected as plain text
-		w.Header().Set("Content-Type", chart.IconContentType)
+		w.Header().Set("Content-Type", contentType)
+	}
+	if strings.HasPrefix(contentType, "image/svg") {
+		// Icons are sanitized by chart-repo, this prevents scripts from running if
+		// one was opened directly anyway
+		w.Header().Set("Content-Security-Policy", "default-src 'none'; style-src 'unsafe-inline'; img-src data:")
 	}
 
-	w.Write(chart.RawIcon)
+	writeBlob(w, req, icon, key)
+}
+
+// selectIconVariant returns the smallest icon variant in the requested format
+// not smaller than the requested size, or the largest one
+func selectIconVariant(req *http.Request, variants []models.IconVariant) (*models.IconVariant, error) {
+	size := 0
+	if s := req.FormValue("size"); s != "" {
+		var err error
+		if size, err = strconv.Atoi(s); err != nil || size < 1 {
+			return nil, errors.New("invalid value for size, expected a positive integer")
+		}
+	}
+	format := req.FormValue("format")
+	switch format {
+	case "", "png", "webp":
+	default:
+		return nil, errors.New("invalid value for format, expected png or webp")
+	}
+
+	var selected *models.IconVariant
+	for i := range variants {
+		v := &variants[i]
+		if format != "" && v.Format != format || format == "" && v.Format != "png" {
+			continue
+		}
+		switch {
+		case selected == nil:
+			selected = v
+		case selected.Size < size:
+			// Larger icons are better than the current one which is too small
+			if v.Size > selected.Size {
+				selected = v
+			}
+		case v.Size >= size && v.Size < selected.Size:
+			selected = v
+		}
+	}
+	return selected, nil
 }
 
 // getChartVersionReadme returns the README for a given chart
 func getChartVersionReadme(w http.ResponseWriter, req *http.Request, params Params) {
 	db, closer := dbSession.DB()
 	defer closer()
-	var files models.ChartFiles
 	fileID := fmt.Sprintf("%s/%s-%s", params["repo"], params["chartName"], params["version"])
-	if err := db.C(filesCollection).FindId(fileID).One(&files); err != nil {
+	files, err := findChartFiles(db, fileID)
+	if err != nil {
 		log.WithError(err).Errorf("could not find files with id %s", fileID)
 		http.NotFound(w, req)
 		return
 	}
 	readme := []byte(files.Readme)
-	if len(readme) == 0 {
+	if len(readme) == 0 && files.ReadmeKey == "" {
 		log.Errorf("could not find a README for id %s", fileID)
 		http.NotFound(w, req)
 		return
 	}
-	w.Write(readme)
+	if checkNotModified(w, req, cacheControlVersioned, chartFilesValidators(files, "README.md")) {
+		return
+	}
+	writeBlob(w, req, readme, files.ReadmeKey)
 }
 
 // getChartVersionValues returns the values.yaml for a given chart
 func getChartVersionValues(w http.ResponseWriter, req *http.Request, params Params) {
 	db, closer := dbSession.DB()
 	defer closer()
-	var files models.ChartFiles
 	fileID := fmt.Sprintf("%s/%s-%s", params["repo"], params["chartName"], params["version"])
-	if err := db.C(filesCollection).FindId(fileID).One(&files); err != nil {
+	files, err := findChartFiles(db, fileID)
+	if err != nil {
 		log.WithError(err).Errorf("could not find values.yaml with id %s", fileID)
 		http.NotFound(w, req)
 		return
 	}
 
-	w.Write([]byte(files.Values))
+	if checkNotModified(w, req, cacheControlVersioned, chartFilesValidators(files, "values.yaml")) {
+		return
+	}
+	writeBlob(w, req, []byte(files.Values), files.ValuesKey)
 }
 
 // getChartVersionSchema returns the values.schema.json for a given chart
 func getChartVersionSchema(w http.ResponseWriter, req *http.Request, params Params) {
 	db, closer := dbSession.DB()
 	defer closer()
-	var files models.ChartFiles
 	fileID := fmt.Sprintf("%s/%s-%s", params["repo"], params["chartName"], params["version"])
-	if err := db.C(filesCollection).FindId(fileID).One(&files); err != nil {
+	files, err := findChartFiles(db, fileID)
+	if err != nil {
 		log.WithError(err).Errorf("could not find values.schema.json with id %s", fileID)
 		http.NotFound(w, req)
 		return
 	}
 
-	w.Write([]byte(files.Schema))
+	if checkNotModified(w, req, cacheControlVersioned, chartFilesValidators(files, "values.schema.json")) {
+		return
+	}
+	writeBlob(w, req, []byte(files.Schema), files.SchemaKey)
+}
+
+// getChartVersionTarball returns the tarball of a given chart version, which
+// is only stored if a blob store is configured
+func getChartVersionTarball(w http.ResponseWriter, req *http.Request, params Params) {
+	db, closer := dbSession.DB()
+	defer closer()
+	fileID := fmt.Sprintf("%s/%s-%s", params["repo"], params["chartName"], params["version"])
+	files, err := findChartFiles(db, fileID)
+	if err != nil {
+		log.WithError(err).Errorf("could not find tarball with id %s", fileID)
+		http.NotFound(w, req)
+		return
+	}
+	if files.TarballKey == "" {
+		http.NotFound(w, req)
+		return
+	}
+
+	if checkNotModified(w, req, cacheControlVersioned, chartFilesValidators(files, "tarball")) {
+		return
+	}
+	w.Header().Set("Content-Type", "application/gzip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", params["chartName"]+"-"+params["version"]+".tgz"))
+	writeBlob(w, req, nil, files.TarballKey)
 }
 
 // listChartsWithFilters returns the list of repos that contains the given chart and the latest version found
@@ -331,11 +493,15 @@ func listChartsWithFilters(w http.ResponseWriter, req *http.Request, params Para
 	defer closer()
 
 	var charts []*models.Chart
-	if err := db.C(chartCollection).Find(bson.M{
+	conditions := bson.M{
 		"name": params["chartName"],
 		"chartversions": bson.M{
 			"$elemMatch": bson.M{"version": req.FormValue("version"), "appversion": req.FormValue("appversion")},
-		}}).Select(bson.M{
+		}}
+	if !includeDeprecated(req) {
+		conditions["deprecated"] = bson.M{"$ne": true}
+	}
+	if err := db.C(chartCollection).Find(conditions).Select(bson.M{
 		"name": 1, "repo": 1,
 		"chartversions": bson.M{"$slice": 1},
 	}).All(&charts); err != nil {
@@ -350,6 +516,9 @@ func listChartsWithFilters(w http.ResponseWriter, req *http.Request, params Para
 	if !showDuplicates(req) {
 		chartResponse = uniqChartList(charts)
 	}
+	if checkNotModified(w, req, cacheControlDefault, chartListValidators(chartResponse)) {
+		return
+	}
 	cl := newChartListResponse(chartResponse)
 	response.NewDataResponse(cl).Write(w)
 }
@@ -380,7 +549,10 @@ func searchCharts(w http.ResponseWriter, req *http.Request, params Params) {
 	if params["repo"] != "" {
 		conditions["repo.name"] = params["repo"]
 	}
-	if err := db.C(chartCollection).Find(conditions).All(&charts); err != nil {
+	if !includeDeprecated(req) {
+		conditions["deprecated"] = bson.M{"$ne": true}
+	}
+	if err := db.C(chartCollection).Find(conditions).Select(chartFields).All(&charts); err != nil {
 		log.WithError(err).Errorf(
 			"could not find charts with the given query %s",
 			query,
@@ -392,6 +564,9 @@ func searchCharts(w http.ResponseWriter, req *http.Request, params Params) {
 	if !showDuplicates(req) {
 		chartResponse = uniqChartList(charts)
 	}
+	if checkNotModified(w, req, cacheControlDefault, chartListValidators(chartResponse)) {
+		return
+	}
 	cl := newChartListResponse(chartResponse)
 	response.NewDataResponse(cl).Write(w)
 }
@@ -427,7 +602,8 @@ func chartVersionAttributes(cid string, cv models.ChartVersion) models.ChartVers
 }
 
 func chartAttributes(c models.Chart) models.Chart {
-	if c.RawIcon != nil {
+	// The icon itself is usually left out of the queries, see chartFields
+	if c.RawIcon != nil || c.IconKey != "" || c.IconContentType != "" {
 		c.Icon = pathPrefix + "/assets/" + c.ID + "/logo"
 	} else {
 		// If the icon wasn't processed, it is either not set or invalid
@@ -43,6 +43,7 @@ type bodyAPIResponse struct {
 }
 
 var chartsList []*models.Chart
+var repoChecks []models.RepoCheck
 var cc count
 
 const testChartReadme = "# Quickstart\n\n```bash\nhelm install my-repo/my-chart\n```"
@@ -82,6 +83,11 @@ func Test_chartAttributes(t *testing.T) {
 			}
 		})
 	}
+
+	t.Run("icon left out of the query", func(t *testing.T) {
+		c := chartAttributes(models.Chart{ID: "repo/mychart", IconContentType: "image/png"})
+		assert.Equal(t, pathPrefix+"/assets/repo/mychart/logo", c.Icon)
+	})
 }
 
 func Test_chartVersionAttributes(t *testing.T) {
@@ -246,6 +252,7 @@ func Test_listCharts(t *testing.T) {
 			var m mock.Mock
 			dbSession = mockstore.NewMockSession(&m)
 
+			m.On("All", &repoChecks)
 			m.On("All", &chartsList).Run(func(args mock.Arguments) {
 				*args.Get(0).(*[]*models.Chart) = tt.charts
 			})
@@ -309,6 +316,7 @@ func Test_listRepoCharts(t *testing.T) {
 			var m mock.Mock
 			dbSession = mockstore.NewMockSession(&m)
 
+			m.On("All", &repoChecks)
 			m.On("All", &chartsList).Run(func(args mock.Arguments) {
 				*args.Get(0).(*[]*models.Chart) = tt.charts
 			})
@@ -603,6 +611,69 @@ func Test_getChartIcon(t *testing.T) {
 	}
 }
 
+func Test_getChartIconVariants(t *testing.T) {
+	chart := models.Chart{
+		ID:              "my-repo/my-chart",
+		RawIcon:         []byte("default"),
+		IconContentType: "image/png",
+		IconVariants: []models.IconVariant{
+			{Size: 64, Format: "png", ContentType: "image/png", Data: []byte("png-64")},
+			{Size: 64, Format: "webp", ContentType: "image/webp", Data: []byte("webp-64")},
+			{Size: 160, Format: "png", ContentType: "image/png", Data: []byte("png-160")},
+			{Size: 160, Format: "webp", ContentType: "image/webp", Data: []byte("webp-160")},
+		},
+	}
+	svg := models.Chart{ID: "my-repo/my-chart", RawIcon: []byte("<svg></svg>"), IconContentType: "image/svg+xml"}
+	tests := []struct {
+		name     string
+		chart    models.Chart
+		query    string
+		wantCode int
+		wantBody string
+	}{
+		{"default icon", chart, "", http.StatusOK, "default"},
+		{"smallest large enough", chart, "?size=64", http.StatusOK, "png-64"},
+		{"next size", chart, "?size=100&format=webp", http.StatusOK, "webp-160"},
+		{"largest size", chart, "?size=512", http.StatusOK, "png-160"},
+		{"format only", chart, "?format=webp", http.StatusOK, "webp-64"},
+		{"svg icon", svg, "?size=64&format=webp", http.StatusOK, "<svg></svg>"},
+		{"invalid size", chart, "?size=big", http.StatusBadRequest, ""},
+		{"negative size", chart, "?size=-1", http.StatusBadRequest, ""},
+		{"invalid format", chart, "?format=gif", http.StatusBadRequest, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var m mock.Mock
+			dbSession = mockstore.NewMockSession(&m)
+			m.On("One", &models.Chart{}).Return(nil).Run(func(args mock.Arguments) {
+				*args.Get(0).(*models.Chart) = tt.chart
+			})
+
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest("GET", "/assets/my-repo/my-chart/logo"+tt.query, nil)
+			getChartIcon(w, req, Params{"repo": "my-repo", "chartName": "my-chart"})
+
+			assert.Equal(t, tt.wantCode, w.Code, "http status code should match")
+			if tt.wantCode == http.StatusOK {
+				assert.Equal(t, w.Body.String(), tt.wantBody, "icon should match")
+				assert.Equal(t, w.Header().Get("ETag"), newETag(tt.wantBody), "etag should match")
+			}
+		})
+	}
+
+	t.Run("svg policy", func(t *testing.T) {
+		var m mock.Mock
+		dbSession = mockstore.NewMockSession(&m)
+		m.On("One", &models.Chart{}).Return(nil).Run(func(args mock.Arguments) {
+			*args.Get(0).(*models.Chart) = svg
+		})
+		w := httptest.NewRecorder()
+		getChartIcon(w, httptest.NewRequest("GET", "/assets/my-repo/my-chart/logo", nil), Params{"repo": "my-repo", "chartName": "my-chart"})
+		assert.Equal(t, w.Header().Get("Content-Type"), "image/svg+xml", "content type")
+		assert.True(t, strings.Contains(w.Header().Get("Content-Security-Policy"), "default-src 'none'"), "scripts are not allowed")
+	})
+}
+
 func Test_getChartVersionReadme(t *testing.T) {
 	tests := []struct {
 		name     string
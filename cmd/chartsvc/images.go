@@ -0,0 +1,163 @@
+/*
+Copyright (c) 2018 The Helm Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/globalsign/mgo/bson"
+	"github.com/helm/monocular/cmd/chartsvc/models"
+	"github.com/helm/monocular/pkg/imageref"
+	"github.com/kubeapps/common/response"
+	log "github.com/sirupsen/logrus"
+)
+
+// imagesMeta holds the error chart-repo got rendering the templates of a
+// chart version, in which case its images are unknown
+type imagesMeta struct {
+	RenderError string `json:"renderError,omitempty"`
+}
+
+// imageChartResponse is a chart version pulling the images matching a
+// reference
+type imageChartResponse struct {
+	Repo    string         `json:"repo"`
+	Chart   string         `json:"chart"`
+	Version string         `json:"version"`
+	Images  []models.Image `json:"images"`
+}
+
+// imageQuery is the image reference given with the ref query param. A
+// reference without a tag or digest matches every tag of the image.
+type imageQuery struct {
+	imageref.Reference
+	anyTag bool
+}
+
+// parseImageQuery parses and normalizes the reference
+func parseImageQuery(ref string) (imageQuery, error) {
+	r, err := imageref.Parse(ref)
+	if err != nil {
+		return imageQuery{}, err
+	}
+	return imageQuery{r, !strings.ContainsAny(ref[strings.LastIndex(ref, "/")+1:], ":@")}, nil
+}
+
+// selector returns the condition on the images matching the query
+func (q imageQuery) selector() bson.M {
+	s := bson.M{"name": q.Name}
+	switch {
+	case q.anyTag:
+	case q.Digest != "":
+		s["digest"] = q.Digest
+	default:
+		s["tag"] = q.Tag
+	}
+	return s
+}
+
+// matches returns true if the image meets the condition of the selector
+func (q imageQuery) matches(img models.Image) bool {
+	switch {
+	case img.Name != q.Name:
+		return false
+	case q.anyTag:
+		return true
+	case q.Digest != "":
+		return img.Digest == q.Digest
+	}
+	return img.Tag == q.Tag
+}
+
+// listImageCharts returns the chart versions pulling the image given with the
+// ref query param
+func listImageCharts(w http.ResponseWriter, req *http.Request) {
+	ref := req.FormValue("ref")
+	if ref == "" {
+		response.NewErrorResponse(http.StatusBadRequest, "ref is required").Write(w)
+		return
+	}
+	q, err := parseImageQuery(ref)
+	if err != nil {
+		response.NewErrorResponse(http.StatusBadRequest, err.Error()).Write(w)
+		return
+	}
+
+	files, truncated, err := findMatchingFiles("images", q.selector())
+	if err != nil {
+		log.WithError(err).Errorf("could not find charts pulling %s", ref)
+		response.NewErrorResponse(http.StatusInternalServerError, "could not fetch charts").Write(w)
+		return
+	}
+
+	charts := []imageChartResponse{}
+	for _, f := range files {
+		c := imageChartResponse{Repo: f.Repo.Name, Chart: f.Chart, Version: f.Version}
+		for _, img := range f.Images {
+			if q.matches(img) {
+				c.Images = append(c.Images, img)
+			}
+		}
+		if len(c.Images) > 0 {
+			charts = append(charts, c)
+		}
+	}
+	sort.Slice(charts, func(i, j int) bool {
+		a, b := charts[i], charts[j]
+		if a.Repo != b.Repo {
+			return a.Repo < b.Repo
+		}
+		if a.Chart != b.Chart {
+			return a.Chart < b.Chart
+		}
+		return a.Version < b.Version
+	})
+	if truncated {
+		response.NewDataResponseWithMeta(charts, matchingFilesMeta{Truncated: true}).Write(w)
+		return
+	}
+	response.NewDataResponse(charts).Write(w)
+}
+
+// listChartVersionImages returns the container images of the default
+// installation of a chart version
+func listChartVersionImages(w http.ResponseWriter, req *http.Request, params Params) {
+	db, closer := dbSession.DB()
+	defer closer()
+	fileID := fmt.Sprintf("%s/%s-%s", params["repo"], params["chartName"], params["version"])
+	files, err := findChartFiles(db, fileID)
+	if err != nil {
+		log.WithError(err).Errorf("could not find files with id %s", fileID)
+		response.NewErrorResponse(http.StatusNotFound, "could not find chart version").Write(w)
+		return
+	}
+	if checkNotModified(w, req, cacheControlVersioned, chartFilesValidators(files, "images")) {
+		return
+	}
+	images := files.Images
+	if images == nil {
+		images = []models.Image{}
+	}
+	if files.ImagesError != "" {
+		response.NewDataResponseWithMeta(images, imagesMeta{RenderError: files.ImagesError}).Write(w)
+		return
+	}
+	response.NewDataResponse(images).Write(w)
+}
@@ -0,0 +1,169 @@
+/*
+Copyright (c) 2018 The Helm Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/globalsign/mgo/bson"
+	"github.com/helm/monocular/cmd/chartsvc/models"
+	"github.com/kubeapps/common/datastore/mockstore"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+const testImageDigest = "sha256:e4ab1b2fb1d29b1e0d1eb2bbcdc5c5a06c3ecbe2b4bfb6d44ac2a5e7d4b8e9d0"
+
+var (
+	nginxImage    = models.Image{Ref: "nginx:1.19", Name: "docker.io/library/nginx", Tag: "1.19"}
+	oldNginxImage = models.Image{Ref: "docker.io/library/nginx:1.17", Name: "docker.io/library/nginx", Tag: "1.17"}
+	pinnedImage   = models.Image{Ref: "quay.io/app@" + testImageDigest, Name: "quay.io/app", Digest: testImageDigest}
+)
+
+func Test_imageQuery(t *testing.T) {
+	tests := []struct {
+		ref      string
+		selector bson.M
+		matches  []bool
+	}{
+		{"nginx", bson.M{"name": "docker.io/library/nginx"}, []bool{true, true, false}},
+		{"docker.io/library/nginx:1.19", bson.M{"name": "docker.io/library/nginx", "tag": "1.19"}, []bool{true, false, false}},
+		{"quay.io/app@" + testImageDigest, bson.M{"name": "quay.io/app", "digest": testImageDigest}, []bool{false, false, true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.ref, func(t *testing.T) {
+			q, err := parseImageQuery(tt.ref)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.selector, q.selector(), "selector should match")
+			for i, img := range []models.Image{nginxImage, oldNginxImage, pinnedImage} {
+				assert.Equal(t, tt.matches[i], q.matches(img), img.Ref)
+			}
+		})
+	}
+}
+
+func Test_listImageCharts(t *testing.T) {
+	files := []models.ChartFiles{
+		{ID: "stable/nginx-1.0.0", Repo: models.Repo{Name: "stable"}, Chart: "nginx", Version: "1.0.0", Images: []models.Image{nginxImage, pinnedImage}},
+		{ID: "stable/nginx-0.1.0", Repo: models.Repo{Name: "stable"}, Chart: "nginx", Version: "0.1.0", Images: []models.Image{oldNginxImage}},
+	}
+	tests := []struct {
+		name       string
+		ref        string
+		wantCode   int
+		wantCharts []imageChartResponse
+	}{
+		{"missing ref", "", http.StatusBadRequest, nil},
+		{"invalid ref", "<no value>", http.StatusBadRequest, nil},
+		{"any tag", "nginx", http.StatusOK, []imageChartResponse{
+			{Repo: "stable", Chart: "nginx", Version: "0.1.0", Images: []models.Image{oldNginxImage}},
+			{Repo: "stable", Chart: "nginx", Version: "1.0.0", Images: []models.Image{nginxImage}},
+		}},
+		{"tag", "nginx:1.17", http.StatusOK, []imageChartResponse{
+			{Repo: "stable", Chart: "nginx", Version: "0.1.0", Images: []models.Image{oldNginxImage}},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var m mock.Mock
+			dbSession = mockstore.NewMockSession(&m)
+			var cf []models.ChartFiles
+			m.On("All", &cf).Run(func(args mock.Arguments) {
+				// the database only returns the files with matching images
+				var matching []models.ChartFiles
+				for _, f := range files {
+					for _, img := range f.Images {
+						if img.Tag == "1.17" || tt.ref == "nginx" {
+							matching = append(matching, f)
+							break
+						}
+					}
+				}
+				*args.Get(0).(*[]models.ChartFiles) = matching
+			})
+
+			w := httptest.NewRecorder()
+			listImageCharts(w, httptest.NewRequest("GET", "/v1/images?ref="+url.QueryEscape(tt.ref), nil))
+			assert.Equal(t, tt.wantCode, w.Code, "http status code should match")
+			if tt.wantCode != http.StatusOK {
+				return
+			}
+			var b struct {
+				Data []imageChartResponse
+			}
+			assert.NoError(t, json.NewDecoder(w.Body).Decode(&b))
+			assert.Equal(t, tt.wantCharts, b.Data, "charts should match")
+		})
+	}
+}
+
+func Test_listImageChartsTruncated(t *testing.T) {
+	var files []models.ChartFiles
+	for i := 0; i <= maxMatchingFiles; i++ {
+		files = append(files, models.ChartFiles{Repo: models.Repo{Name: "stable"}, Chart: "nginx", Images: []models.Image{nginxImage}})
+	}
+	var m mock.Mock
+	dbSession = mockstore.NewMockSession(&m)
+	var cf []models.ChartFiles
+	m.On("All", &cf).Run(func(args mock.Arguments) {
+		*args.Get(0).(*[]models.ChartFiles) = files
+	})
+
+	w := httptest.NewRecorder()
+	listImageCharts(w, httptest.NewRequest("GET", "/v1/images?ref=nginx", nil))
+	assert.Equal(t, http.StatusOK, w.Code, "http status code should match")
+	var b struct {
+		Data []imageChartResponse
+		Meta matchingFilesMeta
+	}
+	assert.NoError(t, json.NewDecoder(w.Body).Decode(&b))
+	assert.Len(t, b.Data, maxMatchingFiles, "charts should be truncated")
+	assert.True(t, b.Meta.Truncated, "list should be marked as truncated")
+}
+
+func Test_listChartVersionImages(t *testing.T) {
+	params := Params{"repo": "stable", "chartName": "nginx", "version": "1.0.0"}
+	tests := []struct {
+		name            string
+		files           models.ChartFiles
+		wantImages      []models.Image
+		wantRenderError string
+	}{
+		{"chart with images", models.ChartFiles{ID: "stable/nginx-1.0.0", Images: []models.Image{nginxImage}}, []models.Image{nginxImage}, ""},
+		{"chart without images", models.ChartFiles{ID: "stable/nginx-1.0.0"}, []models.Image{}, ""},
+		{"chart which could not be rendered", models.ChartFiles{ID: "stable/nginx-1.0.0", ImagesError: "rendering timed out after 10s"}, []models.Image{}, "rendering timed out after 10s"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			withChartFiles(tt.files)
+			w := httptest.NewRecorder()
+			listChartVersionImages(w, httptest.NewRequest("GET", "/", nil), params)
+			assert.Equal(t, http.StatusOK, w.Code, "http status code should match")
+			var b struct {
+				Data []models.Image
+				Meta imagesMeta
+			}
+			assert.NoError(t, json.NewDecoder(w.Body).Decode(&b))
+			assert.Equal(t, tt.wantImages, b.Data, "images should match")
+			assert.Equal(t, tt.wantRenderError, b.Meta.RenderError, "render error should match")
+		})
+	}
+}
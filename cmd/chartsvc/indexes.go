@@ -0,0 +1,108 @@
+/*
+Copyright (c) 2018 The Helm Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"time"
+
+	"github.com/globalsign/mgo"
+	"github.com/globalsign/mgo/bson"
+	"github.com/helm/monocular/cmd/chartsvc/models"
+	"github.com/kubeapps/common/datastore"
+)
+
+// maxMatchingFiles is the maximum number of chart versions returned by the
+// CRD and image queries
+const maxMatchingFiles = 1000
+
+// filesIndexes are the indexes of the files collection used by the CRD and
+// image queries
+var filesIndexes = []mgo.Index{
+	{Key: []string{"crds.kind", "crds.group"}, Background: true},
+	{Key: []string{"images.name"}, Background: true},
+}
+
+// eventsIndexes are the indexes of the events collection, which is listed by
+// time
+var eventsIndexes = []mgo.Index{
+	{Key: []string{"time", "_id"}, Background: true},
+}
+
+// ensureIndexes creates the indexes of the queries of chartsvc, the datastore
+// session does not expose them so a session of its own is opened
+func ensureIndexes(conf datastore.Config) error {
+	dialInfo, err := mgo.ParseURL(conf.URL)
+	if err != nil {
+		return err
+	}
+	if conf.Username != "" {
+		dialInfo.Username = conf.Username
+	}
+	if conf.Password != "" {
+		dialInfo.Password = conf.Password
+	}
+	dialInfo.Timeout = conf.Timeout
+	if dialInfo.Timeout == 0 {
+		dialInfo.Timeout = 10 * time.Second
+	}
+	session, err := mgo.DialWithInfo(dialInfo)
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+	for collection, indexes := range map[string][]mgo.Index{
+		filesCollection:  filesIndexes,
+		eventsCollection: eventsIndexes,
+	} {
+		for _, index := range indexes {
+			if err := session.DB(conf.Database).C(collection).EnsureIndex(index); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// matchingFilesMeta tells the clients that only the first maxMatchingFiles
+// chart versions are listed
+type matchingFilesMeta struct {
+	Truncated bool `json:"truncated"`
+}
+
+// findMatchingFiles returns the chart versions with an element of the array
+// field matching the selector, and whether there were more than
+// maxMatchingFiles of them. Only the field and what identifies the chart
+// version are read.
+func findMatchingFiles(field string, selector bson.M) ([]models.ChartFiles, bool, error) {
+	db, closer := dbSession.DB()
+	defer closer()
+	var files []models.ChartFiles
+	// Fetch an extra chart version to know if the list is truncated
+	err := db.C(filesCollection).Pipe([]bson.M{
+		{"$match": bson.M{field: bson.M{"$elemMatch": selector}}},
+		{"$sort": bson.M{"_id": 1}},
+		{"$limit": maxMatchingFiles + 1},
+		{"$project": bson.M{"repo.name": 1, "chart": 1, "version": 1, field: 1}},
+	}).All(&files)
+	if err != nil {
+		return nil, false, err
+	}
+	if len(files) > maxMatchingFiles {
+		return files[:maxMatchingFiles], true, nil
+	}
+	return files, false, nil
+}
@@ -17,11 +17,16 @@ limitations under the License.
 package main
 
 import (
+	"context"
 	"flag"
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/gorilla/mux"
+	"github.com/helm/monocular/pkg/blobstore"
 	"github.com/heptiolabs/healthcheck"
 	"github.com/kubeapps/common/datastore"
 	log "github.com/sirupsen/logrus"
@@ -32,6 +37,14 @@ const pathPrefix = "/v1"
 
 var dbSession datastore.Session
 
+// adminToken is the bearer token required by the admin endpoints, these are
+// disabled if it is empty
+var adminToken string
+
+// serveTarballs enables the chart.tgz endpoint. chartsvc does not authenticate
+// its clients, so it would also serve the tarballs of private repositories.
+var serveTarballs bool
+
 func setupRoutes() http.Handler {
 	r := mux.NewRouter()
 
@@ -54,23 +67,78 @@ func setupRoutes() http.Handler {
 	apiv1.Methods("GET").Path("/charts/{repo}/{chartName}").Handler(WithParams(getChart))
 	apiv1.Methods("GET").Path("/charts/{repo}/{chartName}/versions").Handler(WithParams(listChartVersions))
 	apiv1.Methods("GET").Path("/charts/{repo}/{chartName}/versions/{version}").Handler(WithParams(getChartVersion))
+	apiv1.Methods("GET").Path("/charts/{repo}/{chartName}/changelog").Handler(WithParams(getChartChangelog))
+	apiv1.Methods("GET").Path("/charts/{repo}/{chartName}/versions/{version}/crds").Handler(WithParams(listChartVersionCRDs))
+	apiv1.Methods("GET").Path("/crds").HandlerFunc(listCRDs)
+	apiv1.Methods("GET").Path("/charts/{repo}/{chartName}/versions/{version}/images").Handler(WithParams(listChartVersionImages))
+	apiv1.Methods("GET").Path("/images").HandlerFunc(listImageCharts)
+	apiv1.Methods("PUT").Path("/charts/{repo}/{chartName}/versions/{version}/yank").Handler(requireAdmin(WithParams(yankChartVersion)))
+	apiv1.Methods("DELETE").Path("/charts/{repo}/{chartName}/versions/{version}/yank").Handler(requireAdmin(WithParams(unyankChartVersion)))
+	apiv1.Methods("GET").Path("/events").HandlerFunc(listEvents)
+	apiv1.Methods("GET").Path("/events/{repo}/feed.{format:atom|rss}").Handler(WithParams(getRepoEventFeed))
+	apiv1.Methods("GET").Path("/events/{repo}/{chartName}/feed.{format:atom|rss}").Handler(WithParams(getChartEventFeed))
+	apiv1.Methods("GET").Path("/webhooks").Handler(requireAdmin(http.HandlerFunc(listWebhooks)))
+	apiv1.Methods("POST").Path("/webhooks").Handler(requireAdmin(http.HandlerFunc(createWebhook)))
+	apiv1.Methods("DELETE").Path("/webhooks/{id}").Handler(requireAdmin(WithParams(deleteWebhook)))
+	apiv1.Methods("GET").Path("/webhooks/{id}/deliveries").Handler(requireAdmin(WithParams(listWebhookDeliveries)))
 	apiv1.Methods("GET").Path("/assets/{repo}/{chartName}/logo").Handler(WithParams(getChartIcon))
 	// Maintain the logo-160x160-fit.png endpoint for backward compatibility /assets/{repo}/{chartName}/logo should be used instead
 	apiv1.Methods("GET").Path("/assets/{repo}/{chartName}/logo-160x160-fit.png").Handler(WithParams(getChartIcon))
 	apiv1.Methods("GET").Path("/assets/{repo}/{chartName}/versions/{version}/README.md").Handler(WithParams(getChartVersionReadme))
 	apiv1.Methods("GET").Path("/assets/{repo}/{chartName}/versions/{version}/values.yaml").Handler(WithParams(getChartVersionValues))
 	apiv1.Methods("GET").Path("/assets/{repo}/{chartName}/versions/{version}/values.schema.json").Handler(WithParams(getChartVersionSchema))
+	if serveTarballs {
+		apiv1.Methods("GET").Path("/assets/{repo}/{chartName}/versions/{version}/chart.tgz").Handler(WithParams(getChartVersionTarball))
+	}
+	apiv1.Methods("GET").Path("/assets/{repo}/{chartName}/versions/{version}/files").Handler(WithParams(listChartVersionFiles))
+	apiv1.Methods("GET").Path("/assets/{repo}/{chartName}/versions/{version}/files/{path:.+}").Handler(WithParams(getChartVersionFile))
 
 	n := negroni.Classic()
+	n.Use(compressHandler{})
 	n.UseHandler(r)
 	return n
 }
 
+// runServer serves HTTP requests until the listener fails or a signal is
+// received on stop. On a signal, in-flight requests are given up to
+// shutdownTimeout to complete before the server is closed.
+func runServer(srv *http.Server, stop <-chan os.Signal, shutdownTimeout time.Duration) error {
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- srv.ListenAndServe()
+	}()
+
+	select {
+	case err := <-errCh:
+		return err
+	case sig := <-stop:
+		log.WithFields(log.Fields{"signal": sig, "timeout": shutdownTimeout}).Info("Shutting down chartsvc")
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		return srv.Shutdown(ctx)
+	}
+}
+
+// closeDBSession releases the connections held by the database session if the
+// underlying implementation supports it
+func closeDBSession() {
+	if s, ok := dbSession.(interface{ Close() }); ok {
+		s.Close()
+	}
+}
+
 func main() {
 	dbURL := flag.String("mongo-url", "localhost", "MongoDB URL (see https://godoc.org/github.com/globalsign/mgo#Dial for format)")
 	dbName := flag.String("mongo-database", "charts", "MongoDB database")
 	dbUsername := flag.String("mongo-user", "", "MongoDB user")
+	readTimeout := flag.Duration("read-timeout", 10*time.Second, "maximum duration for reading an entire request")
+	writeTimeout := flag.Duration("write-timeout", 30*time.Second, "maximum duration before timing out writes of a response")
+	idleTimeout := flag.Duration("idle-timeout", 120*time.Second, "maximum amount of time to wait for the next request on keep-alive connections")
+	shutdownTimeout := flag.Duration("shutdown-timeout", 30*time.Second, "maximum amount of time to wait for in-flight requests on shutdown")
+	blobStore := flag.String("blob-store", "", "URL of the store chart-repo moves icons and files to (file:///path or s3://bucket/prefix)")
+	flag.BoolVar(&serveTarballs, "serve-tarballs", false, "serve the chart tarballs stored in the blob store, including the ones of private repositories")
 	dbPassword := os.Getenv("MONGO_PASSWORD")
+	adminToken = os.Getenv("ADMIN_TOKEN")
 	flag.Parse()
 
 	mongoConfig := datastore.Config{URL: *dbURL, Database: *dbName, Username: *dbUsername, Password: dbPassword}
@@ -79,6 +147,14 @@ func main() {
 	if err != nil {
 		log.WithFields(log.Fields{"host": *dbURL}).Fatal(err)
 	}
+	if err := ensureIndexes(mongoConfig); err != nil {
+		log.WithFields(log.Fields{"host": *dbURL}).WithError(err).Warn("could not create indexes")
+	}
+	if *blobStore != "" {
+		if blobs, err = blobstore.New(*blobStore); err != nil {
+			log.WithFields(log.Fields{"blob-store": *blobStore}).Fatal(err)
+		}
+	}
 
 	n := setupRoutes()
 
@@ -87,6 +163,22 @@ func main() {
 		port = "8080"
 	}
 	addr := ":" + port
+	srv := &http.Server{
+		Addr:         addr,
+		Handler:      n,
+		ReadTimeout:  *readTimeout,
+		WriteTimeout: *writeTimeout,
+		IdleTimeout:  *idleTimeout,
+	}
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGTERM, os.Interrupt)
+
 	log.WithFields(log.Fields{"addr": addr}).Info("Started chartsvc")
-	http.ListenAndServe(addr, n)
+	err = runServer(srv, stop, *shutdownTimeout)
+	closeDBSession()
+	if err != nil && err != http.ErrServerClosed {
+		log.WithFields(log.Fields{"addr": addr}).WithError(err).Fatal("chartsvc stopped unexpectedly")
+	}
+	log.Info("Stopped chartsvc")
 }
@@ -21,7 +21,10 @@ import (
 	"errors"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"syscall"
 	"testing"
+	"time"
 
 	"github.com/helm/monocular/cmd/chartsvc/models"
 	"github.com/kubeapps/common/datastore/mockstore"
@@ -80,6 +83,7 @@ func Test_GetCharts(t *testing.T) {
 		t.Run(tt.name, func(t *testing.T) {
 			var m mock.Mock
 			dbSession = mockstore.NewMockSession(&m)
+			m.On("All", &repoChecks)
 			m.On("All", &chartsList).Run(func(args mock.Arguments) {
 				*args.Get(0).(*[]*models.Chart) = tt.charts
 			})
@@ -122,6 +126,7 @@ func Test_GetChartsInRepo(t *testing.T) {
 		t.Run(tt.name, func(t *testing.T) {
 			var m mock.Mock
 			dbSession = mockstore.NewMockSession(&m)
+			m.On("All", &repoChecks)
 			m.On("All", &chartsList).Run(func(args mock.Arguments) {
 				*args.Get(0).(*[]*models.Chart) = tt.charts
 			})
@@ -522,3 +527,18 @@ func Test_GetChartSchema(t *testing.T) {
 		})
 	}
 }
+
+func Test_runServer(t *testing.T) {
+	t.Run("drains and stops on signal", func(t *testing.T) {
+		srv := &http.Server{Addr: "127.0.0.1:0", Handler: http.NotFoundHandler()}
+		stop := make(chan os.Signal, 1)
+		stop <- syscall.SIGTERM
+		assert.NoError(t, runServer(srv, stop, time.Second), "should shut down cleanly")
+	})
+
+	t.Run("returns listener errors", func(t *testing.T) {
+		srv := &http.Server{Addr: "invalid-address", Handler: http.NotFoundHandler()}
+		stop := make(chan os.Signal, 1)
+		assert.Error(t, runServer(srv, stop, time.Second), "should fail to listen")
+	})
+}
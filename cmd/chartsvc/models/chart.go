@@ -19,6 +19,7 @@ package models
 import (
 	"time"
 
+	"github.com/globalsign/mgo/bson"
 	"k8s.io/helm/pkg/proto/hapi/chart"
 )
 
@@ -28,6 +29,21 @@ type Repo struct {
 	URL  string `json:"url"`
 }
 
+// RepoCheck holds the checksum of the index of a repository when it was last
+// synced
+type RepoCheck struct {
+	ID         string    `bson:"_id"`
+	LastUpdate time.Time `bson:"last_update"`
+	Checksum   string    `bson:"checksum"`
+	// Revision is bumped, and LastChange set, when the charts of the
+	// repository are changed through the admin API, see admin.go
+	Revision   int       `bson:"revision"`
+	LastChange time.Time `bson:"last_change"`
+	// LastImport is set when chart-repo imports icons or files, which the
+	// list filters depend on
+	LastImport time.Time `bson:"last_import"`
+}
+
 // Chart is a higher-level representation of a chart package
 type Chart struct {
 	ID              string             `json:"-" bson:"_id"`
@@ -41,9 +57,22 @@ type Chart struct {
 	Icon            string             `json:"icon"`
 	RawIcon         []byte             `json:"-" bson:"raw_icon"`
 	IconContentType string             `json:"-" bson:"icon_content_type,omitempty"`
+	IconVariants    []IconVariant      `json:"-" bson:"icon_variants,omitempty"`
+	IconKey         string             `json:"-" bson:"icon_key,omitempty"`
+	Deprecated      bool               `json:"deprecated"`
+	ReplacedBy      string             `json:"replaced_by,omitempty"`
 	ChartVersions   []ChartVersion     `json:"-"`
 }
 
+// IconVariant is the icon of a chart resized and encoded in a given format
+type IconVariant struct {
+	Size        int    `bson:"size"`
+	Format      string `bson:"format"`
+	ContentType string `bson:"content_type"`
+	Data        []byte `bson:"data,omitempty"`
+	Key         string `bson:"key,omitempty"`
+}
+
 // ChartVersion is a representation of a specific version of a chart
 type ChartVersion struct {
 	Version    string    `json:"version"`
@@ -51,6 +80,8 @@ type ChartVersion struct {
 	Created    time.Time `json:"created"`
 	Digest     string    `json:"digest"`
 	URLs       []string  `json:"urls"`
+	Deprecated bool      `json:"deprecated"`
+	Yanked     bool      `json:"yanked"`
 	Readme     string    `json:"readme" bson:"-"`
 	Values     string    `json:"values" bson:"-"`
 	Schema     string    `json:"schema" bson:"-"`
@@ -58,8 +89,119 @@ type ChartVersion struct {
 
 // ChartFiles holds the README and values for a given chart version
 type ChartFiles struct {
-	ID     string `bson:"_id"`
-	Readme string
-	Values string
-	Schema string
+	ID        string `bson:"_id"`
+	Repo      Repo
+	Readme    string
+	Values    string
+	Schema    string
+	Digest    string
+	Changelog string
+	Changes   []ChartChange
+	// The files are in the blob store instead if their key is set
+	ReadmeKey  string
+	ValuesKey  string
+	SchemaKey  string
+	TarballKey string
+	// Shared files are in the file_contents collection, keyed by digest
+	Shared bool
+	Files  []ChartFile
+	CRDs   []CRD `bson:"crds"`
+	// Images are the container images of the default installation of the
+	// chart version, ImagesError is set if they could not be found
+	Images      []Image `bson:"images"`
+	ImagesError string  `bson:"images_error"`
+	Chart       string
+	Version     string
+}
+
+// Image is a container image reference, with its normalized name
+type Image struct {
+	Ref    string `json:"ref" bson:"ref"`
+	Name   string `json:"name" bson:"name"`
+	Tag    string `json:"tag,omitempty" bson:"tag,omitempty"`
+	Digest string `json:"digest,omitempty" bson:"digest,omitempty"`
+}
+
+// CRD is a CustomResourceDefinition shipped in the crds directory of a chart
+// version, or of one of its subcharts
+type CRD struct {
+	Name     string   `json:"name" bson:"name"`
+	Group    string   `json:"group" bson:"group"`
+	Kind     string   `json:"kind" bson:"kind"`
+	Plural   string   `json:"plural" bson:"plural"`
+	Scope    string   `json:"scope" bson:"scope"`
+	Versions []string `json:"versions" bson:"versions"`
+	Chart    string   `json:"chart" bson:"chart"`
+	Version  string   `json:"version" bson:"version"`
+	Path     string   `json:"path" bson:"path"`
+}
+
+// ChartFile is an entry of the file listing of a chart version, with the
+// content of small text files or its key in the blob store
+type ChartFile struct {
+	Path    string `json:"path" bson:"path"`
+	Size    int64  `json:"size" bson:"size"`
+	Mode    int64  `json:"mode" bson:"mode"`
+	Content string `json:"-" bson:"content,omitempty"`
+	Key     string `json:"-" bson:"key,omitempty"`
+}
+
+// ChartChange is a change introduced by a chart version, as listed in the
+// artifacthub.io/changes annotation
+type ChartChange struct {
+	Kind        string       `json:"kind,omitempty" bson:"kind,omitempty"`
+	Description string       `json:"description" bson:"description"`
+	Links       []ChangeLink `json:"links,omitempty" bson:"links,omitempty"`
+}
+
+// ChangeLink is a link related to a change
+type ChangeLink struct {
+	Name string `json:"name" bson:"name"`
+	URL  string `json:"url" bson:"url"`
+}
+
+// YankedVersion records a chart version withdrawn by an administrator
+type YankedVersion struct {
+	ID      string `bson:"_id"`
+	Repo    string `bson:"repo"`
+	Chart   string `bson:"chart"`
+	Version string `bson:"version"`
+}
+
+// Event records a change in the charts of a repository
+type Event struct {
+	ID      bson.ObjectId `json:"id" bson:"_id"`
+	Type    string        `json:"type" bson:"type"`
+	Repo    string        `json:"repo" bson:"repo"`
+	Chart   string        `json:"chart" bson:"chart"`
+	Name    string        `json:"name" bson:"name"`
+	Version string        `json:"version,omitempty" bson:"version,omitempty"`
+	Time    time.Time     `json:"time" bson:"time"`
+}
+
+// Webhook is a subscription to the events of the repositories and charts
+// matching its patterns
+type Webhook struct {
+	ID     bson.ObjectId `json:"id" bson:"_id"`
+	URL    string        `json:"url" bson:"url"`
+	Secret string        `json:"secret,omitempty" bson:"secret"`
+	Repo   string        `json:"repo,omitempty" bson:"repo,omitempty"`
+	Chart  string        `json:"chart,omitempty" bson:"chart,omitempty"`
+	Events []string      `json:"events,omitempty" bson:"events,omitempty"`
+}
+
+// WebhookDelivery is the delivery log entry of an event sent to a webhook
+type WebhookDelivery struct {
+	ID         bson.ObjectId `json:"id" bson:"_id"`
+	Webhook    bson.ObjectId `json:"webhook" bson:"webhook"`
+	Event      bson.ObjectId `json:"event" bson:"event"`
+	URL        string        `json:"url" bson:"url"`
+	Attempts   int           `json:"attempts" bson:"attempts"`
+	StatusCode int           `json:"status_code,omitempty" bson:"status_code,omitempty"`
+	Error      string        `json:"error,omitempty" bson:"error,omitempty"`
+	Delivered  bool          `json:"delivered" bson:"delivered"`
+	Time       time.Time     `json:"time" bson:"time"`
+	// Pending deliveries are attempted again by chart-repo at NextAttempt
+	Pending     bool      `json:"pending" bson:"pending"`
+	NextAttempt time.Time `json:"next_attempt,omitempty" bson:"next_attempt,omitempty"`
 }
@@ -0,0 +1,348 @@
+/*
+Copyright (c) 2018 The Helm Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/globalsign/mgo/bson"
+	"github.com/helm/monocular/cmd/chartsvc/models"
+)
+
+// sortField is a field used to order a list of charts
+type sortField struct {
+	Name string
+	Desc bool
+}
+
+// chartOrder defines how a list of charts is ordered. The last field must be
+// unique (e.g. the ID) so that the order is stable across requests.
+type chartOrder struct {
+	Fields []sortField
+	// AddFields computes the fields used for sorting that are not stored in the
+	// chart documents
+	AddFields bson.M
+}
+
+// chartOrders are the orders supported by the sort query param
+var chartOrders = map[string]chartOrder{
+	"name": {
+		Fields: []sortField{{Name: "name"}, {Name: "_id"}},
+	},
+	"repo": {
+		Fields: []sortField{{Name: "repo.name"}, {Name: "name"}, {Name: "_id"}},
+	},
+	"-created": {
+		Fields:    []sortField{{Name: "created", Desc: true}, {Name: "_id"}},
+		AddFields: bson.M{"created": bson.M{"$min": "$chartversions.created"}},
+	},
+	"-updated": {
+		Fields:    []sortField{{Name: "updated", Desc: true}, {Name: "_id"}},
+		AddFields: bson.M{"updated": bson.M{"$max": "$chartversions.created"}},
+	},
+}
+
+// sortStage returns the $sort stage for the order, or the reverse order if
+// reverse is set
+func (o chartOrder) sortStage(reverse bool) bson.M {
+	sort := bson.D{}
+	for _, f := range o.Fields {
+		dir := 1
+		if f.Desc != reverse {
+			dir = -1
+		}
+		sort = append(sort, bson.DocElem{Name: f.Name, Value: dir})
+	}
+	return bson.M{"$sort": sort}
+}
+
+// keys returns the values of the sort fields for the given chart, formatted to
+// be stored in a cursor
+func (o chartOrder) keys(c *models.Chart) []string {
+	var keys []string
+	for _, f := range o.Fields {
+		var k string
+		switch f.Name {
+		case "_id":
+			k = c.ID
+		case "name":
+			k = c.Name
+		case "repo.name":
+			k = c.Repo.Name
+		case "created", "updated":
+			var t time.Time
+			for _, cv := range c.ChartVersions {
+				if t.IsZero() || (f.Name == "created" && cv.Created.Before(t)) || (f.Name == "updated" && cv.Created.After(t)) {
+					t = cv.Created
+				}
+			}
+			k = t.Format(time.RFC3339Nano)
+		}
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+// afterStage returns a $match stage selecting the charts after the position
+// given by keys, or before it if reverse is set
+func (o chartOrder) afterStage(keys []string, reverse bool) (bson.M, error) {
+	if len(keys) != len(o.Fields) {
+		return nil, fmt.Errorf("expected %d keys, got %d", len(o.Fields), len(keys))
+	}
+
+	values := make([]interface{}, len(keys))
+	for i, f := range o.Fields {
+		values[i] = keys[i]
+		if f.Name == "created" || f.Name == "updated" {
+			t, err := time.Parse(time.RFC3339Nano, keys[i])
+			if err != nil {
+				return nil, err
+			}
+			values[i] = t
+		}
+	}
+
+	// Charts are after the position if they are equal on the first fields and
+	// greater on the next one
+	var or []bson.M
+	for i, f := range o.Fields {
+		cond := bson.M{}
+		for j := 0; j < i; j++ {
+			cond[o.Fields[j].Name] = values[j]
+		}
+		op := "$gt"
+		if f.Desc != reverse {
+			op = "$lt"
+		}
+		cond[f.Name] = bson.M{op: values[i]}
+		or = append(or, cond)
+	}
+	return bson.M{"$match": bson.M{"$or": or}}, nil
+}
+
+// chartListQuery holds the parameters used to select and order the charts of a
+// list
+type chartListQuery struct {
+	Repo           string
+	ShowDuplicates bool
+	Keyword        string
+	Maintainer     string
+	AppVersion     string
+	Deprecated     *bool
+	// IncludeDeprecated is set if deprecated charts should be listed when not
+	// filtering by Deprecated
+	IncludeDeprecated bool
+	HasSchema         *bool
+	Signed            *bool
+	Order             chartOrder
+	// Facets is set if the counts of charts per repository and keyword should
+	// be returned along with the list
+	Facets bool
+}
+
+// parseChartListQuery extracts the list parameters from the query of a request
+func parseChartListQuery(req *http.Request, repo string) (chartListQuery, error) {
+	q := chartListQuery{
+		Repo:              repo,
+		ShowDuplicates:    showDuplicates(req),
+		Keyword:           req.FormValue("keyword"),
+		Maintainer:        req.FormValue("maintainer"),
+		AppVersion:        req.FormValue("appVersion"),
+		Facets:            len(req.FormValue("facets")) > 0,
+		IncludeDeprecated: includeDeprecated(req),
+		Order:             chartOrders["name"],
+	}
+
+	if s := req.FormValue("sort"); s != "" {
+		o, ok := chartOrders[s]
+		if !ok {
+			return q, fmt.Errorf("unsupported sort %q", s)
+		}
+		q.Order = o
+	}
+
+	for param, v := range map[string]**bool{"deprecated": &q.Deprecated, "has-schema": &q.HasSchema, "signed": &q.Signed} {
+		s := req.FormValue(param)
+		if s == "" {
+			continue
+		}
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return q, fmt.Errorf("invalid value for %s: %q", param, s)
+		}
+		*v = &b
+	}
+	return q, nil
+}
+
+// chartFields leaves the icons out of the charts returned by the queries, they
+// are only needed by getChartIcon
+var chartFields = bson.M{"raw_icon": 0, "icon_variants.data": 0}
+
+// chartListPipeline returns the aggregation pipeline used to select the charts
+// of the list. Callers are responsible for ordering the results.
+func chartListPipeline(q chartListQuery) []bson.M {
+	match := bson.M{}
+	if q.Repo != "" {
+		match["repo.name"] = q.Repo
+	}
+	if q.Keyword != "" {
+		match["keywords"] = q.Keyword
+	}
+	if q.Maintainer != "" {
+		match["maintainers.name"] = q.Maintainer
+	}
+	if q.AppVersion != "" {
+		match["chartversions.0.appversion"] = q.AppVersion
+	}
+	if q.Deprecated != nil {
+		if *q.Deprecated {
+			match["deprecated"] = true
+		} else {
+			match["deprecated"] = bson.M{"$ne": true}
+		}
+	} else if !q.IncludeDeprecated {
+		match["deprecated"] = bson.M{"$ne": true}
+	}
+
+	pipeline := []bson.M{}
+	if len(match) > 0 {
+		pipeline = append(pipeline, bson.M{"$match": match})
+	}
+	pipeline = append(pipeline, bson.M{"$project": chartFields})
+
+	if !q.ShowDuplicates {
+		// We should query unique charts
+		pipeline = append(pipeline,
+			// Add a new field to store the latest version
+			bson.M{"$addFields": bson.M{"firstChartVersion": bson.M{"$arrayElemAt": []interface{}{"$chartversions", 0}}}},
+			// Group by unique digest for the latest version (remove duplicates)
+			bson.M{"$group": bson.M{"_id": "$firstChartVersion.digest", "chart": bson.M{"$first": "$$ROOT"}}},
+			// Restore original object struct
+			bson.M{"$replaceRoot": bson.M{"newRoot": "$chart"}},
+		)
+	}
+
+	if q.HasSchema != nil || q.Signed != nil {
+		// The schema and signature are stored with the files of the latest version
+		pipeline = append(pipeline,
+			bson.M{"$addFields": bson.M{"latestFilesID": bson.M{"$concat": []interface{}{
+				"$repo.name", "/", "$name", "-", bson.M{"$arrayElemAt": []interface{}{"$chartversions.version", 0}},
+			}}}},
+			bson.M{"$lookup": bson.M{"from": filesCollection, "localField": "latestFilesID", "foreignField": "_id", "as": "latestFiles"}},
+		)
+		filesMatch := bson.M{}
+		if q.HasSchema != nil {
+			// The schema is either in the files or in the content they share
+			// with other repositories, and in the blob store if its key is set
+			pipeline = append(pipeline,
+				bson.M{"$lookup": bson.M{"from": contentsCollection, "localField": "latestFiles.digest", "foreignField": "_id", "as": "latestContents"}},
+			)
+			var schemaFields []string
+			for _, files := range []string{"latestFiles", "latestContents"} {
+				schemaFields = append(schemaFields, files+".schema", files+".schemakey")
+			}
+			if *q.HasSchema {
+				var anyField []bson.M
+				for _, f := range schemaFields {
+					anyField = append(anyField, bson.M{f: bson.M{"$nin": []interface{}{"", nil}}})
+				}
+				filesMatch["$or"] = anyField
+			} else {
+				for _, f := range schemaFields {
+					filesMatch[f] = bson.M{"$not": bson.M{"$nin": []interface{}{"", nil}}}
+				}
+			}
+		}
+		if q.Signed != nil {
+			if *q.Signed {
+				filesMatch["latestFiles.signed"] = true
+			} else {
+				filesMatch["latestFiles.signed"] = bson.M{"$ne": true}
+			}
+		}
+		pipeline = append(pipeline,
+			bson.M{"$match": filesMatch},
+			bson.M{"$project": bson.M{"latestFilesID": 0, "latestFiles": 0, "latestContents": 0}},
+		)
+	}
+
+	if q.Order.AddFields != nil {
+		pipeline = append(pipeline, bson.M{"$addFields": q.Order.AddFields})
+	}
+	return pipeline
+}
+
+// facetCount is the number of charts with a given value of a facet
+type facetCount struct {
+	Value string `json:"value" bson:"_id"`
+	Count int    `json:"count"`
+}
+
+// facets holds the number of charts per repository and keyword in a list
+type facets struct {
+	Repos    []facetCount `json:"repos"`
+	Keywords []facetCount `json:"keywords"`
+}
+
+// listMeta is the metadata of a chart list that includes facet counts
+type listMeta struct {
+	meta
+	Facets *facets `json:"facets,omitempty"`
+}
+
+// getChartListFacets counts the charts matching the query per repository and
+// keyword
+func getChartListFacets(q chartListQuery) (*facets, error) {
+	db, closer := dbSession.DB()
+	defer closer()
+
+	byCount := bson.M{"$sort": bson.D{{Name: "count", Value: -1}, {Name: "_id", Value: 1}}}
+	pipeline := append(chartListPipeline(q), bson.M{"$facet": bson.M{
+		"repos": []bson.M{
+			{"$group": bson.M{"_id": "$repo.name", "count": bson.M{"$sum": 1}}},
+			byCount,
+		},
+		"keywords": []bson.M{
+			{"$unwind": "$keywords"},
+			{"$group": bson.M{"_id": "$keywords", "count": bson.M{"$sum": 1}}},
+			byCount,
+		},
+	}})
+
+	f := &facets{}
+	if err := db.C(chartCollection).Pipe(pipeline).One(f); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// newListMeta returns the metadata of a list, including the facet counts if
+// they were requested
+func newListMeta(q chartListQuery, totalPages int) (interface{}, error) {
+	if !q.Facets {
+		return meta{totalPages}, nil
+	}
+	f, err := getChartListFacets(q)
+	if err != nil {
+		return nil, err
+	}
+	return listMeta{meta{totalPages}, f}, nil
+}
@@ -0,0 +1,141 @@
+/*
+Copyright (c) 2018 The Helm Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/globalsign/mgo/bson"
+	"github.com/helm/monocular/cmd/chartsvc/models"
+	"github.com/kubeapps/common/datastore/mockstore"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func Test_parseChartListQuery(t *testing.T) {
+	t.Run("defaults", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/charts", nil)
+		q, err := parseChartListQuery(req, "stable")
+		assert.NoError(t, err)
+		assert.Equal(t, "stable", q.Repo)
+		assert.Equal(t, chartOrders["name"], q.Order)
+		assert.Nil(t, q.Deprecated)
+		assert.False(t, q.Facets)
+	})
+
+	t.Run("filters", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/charts?sort=-created&keyword=cms&maintainer=bitnami&appVersion=5.0&deprecated=false&has-schema=1&signed=true&facets=1", nil)
+		q, err := parseChartListQuery(req, "")
+		assert.NoError(t, err)
+		assert.Equal(t, chartOrders["-created"], q.Order)
+		assert.Equal(t, "cms", q.Keyword)
+		assert.Equal(t, "bitnami", q.Maintainer)
+		assert.Equal(t, "5.0", q.AppVersion)
+		assert.False(t, *q.Deprecated)
+		assert.True(t, *q.HasSchema)
+		assert.True(t, *q.Signed)
+		assert.True(t, q.Facets)
+	})
+
+	for _, query := range []string{"sort=size", "deprecated=maybe", "signed=yes"} {
+		t.Run("invalid "+query, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/charts?"+query, nil)
+			_, err := parseChartListQuery(req, "")
+			assert.Error(t, err)
+		})
+	}
+}
+
+func Test_chartListPipeline(t *testing.T) {
+	yes := true
+	q := chartListQuery{Repo: "stable", Keyword: "cms", ShowDuplicates: true, Order: chartOrders["name"]}
+	pipeline := chartListPipeline(q)
+	assert.Equal(t, []bson.M{{"$match": bson.M{"repo.name": "stable", "keywords": "cms", "deprecated": bson.M{"$ne": true}}}, {"$project": chartFields}}, pipeline)
+
+	q.IncludeDeprecated = true
+	pipeline = chartListPipeline(q)
+	assert.Equal(t, []bson.M{{"$match": bson.M{"repo.name": "stable", "keywords": "cms"}}, {"$project": chartFields}}, pipeline)
+
+	q.HasSchema = &yes
+	q.Order = chartOrders["-updated"]
+	pipeline = chartListPipeline(q)
+	assert.Len(t, pipeline, 8, "files and their shared content should be looked up and the sort field added")
+	assert.Equal(t, bson.M{"$addFields": chartOrders["-updated"].AddFields}, pipeline[7])
+}
+
+func Test_chartOrderAfterStage(t *testing.T) {
+	created := time.Date(2019, 3, 1, 10, 0, 0, 0, time.UTC)
+	o := chartOrders["-created"]
+	c := &models.Chart{ID: "stable/drupal", ChartVersions: []models.ChartVersion{
+		{Version: "0.2.0", Created: created.Add(time.Hour)},
+		{Version: "0.1.0", Created: created},
+	}}
+	keys := o.keys(c)
+	assert.Equal(t, []string{created.Format(time.RFC3339Nano), "stable/drupal"}, keys)
+
+	stage, err := o.afterStage(keys, false)
+	assert.NoError(t, err)
+	assert.Equal(t, bson.M{"$match": bson.M{"$or": []bson.M{
+		{"created": bson.M{"$lt": created}},
+		{"created": created, "_id": bson.M{"$gt": "stable/drupal"}},
+	}}}, stage)
+
+	stage, err = o.afterStage(keys, true)
+	assert.NoError(t, err)
+	assert.Equal(t, bson.M{"$match": bson.M{"$or": []bson.M{
+		{"created": bson.M{"$gt": created}},
+		{"created": created, "_id": bson.M{"$lt": "stable/drupal"}},
+	}}}, stage)
+
+	_, err = o.afterStage([]string{"not-a-date", "stable/drupal"}, false)
+	assert.Error(t, err)
+}
+
+func Test_listChartsWithFacets(t *testing.T) {
+	f := facets{
+		Repos:    []facetCount{{"stable", 2}, {"incubator", 1}},
+		Keywords: []facetCount{{"cms", 2}},
+	}
+
+	var m mock.Mock
+	dbSession = mockstore.NewMockSession(&m)
+	m.On("All", &repoChecks)
+	m.On("All", &chartsList)
+	m.On("One", &cc).Run(func(args mock.Arguments) {
+		*args.Get(0).(*count) = count{3}
+	})
+	m.On("One", &facets{}).Run(func(args mock.Arguments) {
+		*args.Get(0).(*facets) = f
+	})
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/charts?size=2&facets=1", nil)
+	listCharts(w, req)
+
+	m.AssertExpectations(t)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var b struct {
+		Meta listMeta `json:"meta"`
+	}
+	json.NewDecoder(w.Body).Decode(&b)
+	assert.Equal(t, 2, b.Meta.TotalPages)
+	assert.Equal(t, f, *b.Meta.Facets)
+}
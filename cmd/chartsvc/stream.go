@@ -0,0 +1,110 @@
+/*
+Copyright (c) 2018 The Helm Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/globalsign/mgo"
+	"github.com/helm/monocular/cmd/chartsvc/models"
+	"github.com/kubeapps/common/datastore"
+)
+
+// errStreamNotStarted is returned when a list could not be streamed and nothing
+// has been written to the response yet
+var errStreamNotStarted = errors.New("could not start streaming the response")
+
+// chartIter iterates over the charts returned by a database query
+type chartIter interface {
+	Next(result interface{}) bool
+	Close() error
+}
+
+// iterPipe returns an iterator over the results of the pipe. If the pipe is not
+// able to return its results in batches (e.g. *mgo.Pipe does) all the results
+// are read at once.
+func iterPipe(p datastore.Pipe) chartIter {
+	if ip, ok := p.(interface{ Iter() *mgo.Iter }); ok {
+		return ip.Iter()
+	}
+	var charts []*models.Chart
+	err := p.All(&charts)
+	return &sliceIter{charts: charts, err: err}
+}
+
+// sliceIter is a chartIter over a list of charts already in memory
+type sliceIter struct {
+	charts []*models.Chart
+	err    error
+}
+
+func (it *sliceIter) Next(result interface{}) bool {
+	if it.err != nil || len(it.charts) == 0 {
+		return false
+	}
+	*result.(*models.Chart) = *it.charts[0]
+	it.charts = it.charts[1:]
+	return true
+}
+
+func (it *sliceIter) Close() error {
+	return it.err
+}
+
+// writeChartListStream writes a chart list response, encoding each chart as it
+// is read from the iterator. If the iterator fails before the first chart is
+// read errStreamNotStarted is returned and the caller can still write an error
+// response, otherwise the response is truncated.
+func writeChartListStream(w http.ResponseWriter, it chartIter, meta interface{}) error {
+	var c models.Chart
+	more := it.Next(&c)
+	if !more {
+		if err := it.Close(); err != nil {
+			return errStreamNotStarted
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(http.StatusOK)
+	bw := bufio.NewWriter(w)
+	enc := json.NewEncoder(bw)
+	bw.WriteString(`{"data":[`)
+	for first := true; more; first = false {
+		if !first {
+			bw.WriteByte(',')
+		}
+		if err := enc.Encode(newChartResponse(&c)); err != nil {
+			it.Close()
+			return err
+		}
+		// Reset the chart so no fields leak into the next one
+		c = models.Chart{}
+		more = it.Next(&c)
+	}
+	bw.WriteString(`],"meta":`)
+	if err := enc.Encode(meta); err != nil {
+		return err
+	}
+	bw.WriteString("}")
+	if err := bw.Flush(); err != nil {
+		return err
+	}
+	return it.Close()
+}
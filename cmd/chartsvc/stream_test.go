@@ -0,0 +1,70 @@
+/*
+Copyright (c) 2018 The Helm Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/helm/monocular/cmd/chartsvc/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_writeChartListStream(t *testing.T) {
+	tests := []struct {
+		name   string
+		charts []*models.Chart
+	}{
+		{"no charts", []*models.Chart{}},
+		{"one chart", []*models.Chart{
+			{ID: "my-repo/my-chart", ChartVersions: []models.ChartVersion{{Version: "0.0.1", Digest: "123"}}},
+		}},
+		{"two charts", []*models.Chart{
+			{ID: "my-repo/my-chart", RawIcon: iconBytes(), ChartVersions: []models.ChartVersion{{Version: "0.0.1", Digest: "123"}}},
+			{ID: "my-repo/dokuwiki", ChartVersions: []models.ChartVersion{{Version: "1.2.3", Digest: "1234"}}},
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			err := writeChartListStream(w, &sliceIter{charts: tt.charts}, meta{1})
+			assert.NoError(t, err)
+			assert.Equal(t, http.StatusOK, w.Code)
+
+			var b bodyAPIListResponse
+			assert.NoError(t, json.NewDecoder(w.Body).Decode(&b), "response should be valid JSON")
+			data := *b.Data
+			assert.Len(t, data, len(tt.charts))
+			for i, resp := range data {
+				assert.Equal(t, tt.charts[i].ID, resp.ID, "chart id in the response should be the same")
+				assert.Equal(t, chartAttributes(*tt.charts[i]).Icon, resp.Attributes.(map[string]interface{})["icon"], "icon should not leak between charts")
+			}
+			assert.Equal(t, meta{1}, b.Meta)
+		})
+	}
+
+	t.Run("query fails", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		err := writeChartListStream(w, &sliceIter{err: errors.New("connection lost")}, meta{1})
+		assert.Equal(t, errStreamNotStarted, err)
+		assert.Empty(t, w.Body.Bytes(), "nothing should be written")
+	})
+}
@@ -0,0 +1,148 @@
+/*
+Copyright (c) 2018 The Helm Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path/filepath"
+
+	"github.com/globalsign/mgo"
+	"github.com/globalsign/mgo/bson"
+	"github.com/helm/monocular/cmd/chartsvc/models"
+	"github.com/kubeapps/common/response"
+	log "github.com/sirupsen/logrus"
+)
+
+// maxWebhookDeliveries is the number of deliveries returned by the delivery log
+const maxWebhookDeliveries = 100
+
+// webhookEventTypes are the event types webhooks can subscribe to
+var webhookEventTypes = map[string]bool{"chart_added": true, "version_added": true, "chart_removed": true}
+
+// validateWebhook checks the subscription can be delivered by chart-repo
+func validateWebhook(wh models.Webhook) error {
+	u, err := url.Parse(wh.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid url %q", wh.URL)
+	}
+	for _, p := range []string{wh.Repo, wh.Chart} {
+		if _, err := filepath.Match(p, ""); err != nil {
+			return fmt.Errorf("invalid pattern %q", p)
+		}
+	}
+	for _, t := range wh.Events {
+		if !webhookEventTypes[t] {
+			return fmt.Errorf("unsupported event type %q", t)
+		}
+	}
+	return nil
+}
+
+// createWebhook subscribes a URL to the catalog events. If no secret is given
+// one is generated, the secret is only returned in this response.
+func createWebhook(w http.ResponseWriter, req *http.Request) {
+	var wh models.Webhook
+	if err := json.NewDecoder(req.Body).Decode(&wh); err != nil {
+		response.NewErrorResponse(http.StatusBadRequest, "invalid webhook").Write(w)
+		return
+	}
+	if err := validateWebhook(wh); err != nil {
+		response.NewErrorResponse(http.StatusBadRequest, err.Error()).Write(w)
+		return
+	}
+	if wh.Secret == "" {
+		b := make([]byte, 32)
+		if _, err := rand.Read(b); err != nil {
+			log.WithError(err).Error("could not generate webhook secret")
+			response.NewErrorResponse(http.StatusInternalServerError, "could not create webhook").Write(w)
+			return
+		}
+		wh.Secret = hex.EncodeToString(b)
+	}
+	wh.ID = bson.NewObjectId()
+
+	db, closer := dbSession.DB()
+	defer closer()
+	if err := db.C(webhooksCollection).Insert(wh); err != nil {
+		log.WithError(err).Error("could not create webhook")
+		response.NewErrorResponse(http.StatusInternalServerError, "could not create webhook").Write(w)
+		return
+	}
+	response.NewDataResponse(wh).WithCode(http.StatusCreated).Write(w)
+}
+
+// listWebhooks returns the webhook subscriptions, without their secrets
+func listWebhooks(w http.ResponseWriter, req *http.Request) {
+	db, closer := dbSession.DB()
+	defer closer()
+	webhooks := []models.Webhook{}
+	if err := db.C(webhooksCollection).Find(bson.M{}).All(&webhooks); err != nil {
+		log.WithError(err).Error("could not fetch webhooks")
+		response.NewErrorResponse(http.StatusInternalServerError, "could not fetch webhooks").Write(w)
+		return
+	}
+	for i := range webhooks {
+		webhooks[i].Secret = ""
+	}
+	response.NewDataResponse(webhooks).Write(w)
+}
+
+// deleteWebhook removes a webhook subscription
+func deleteWebhook(w http.ResponseWriter, req *http.Request, params Params) {
+	if !bson.IsObjectIdHex(params["id"]) {
+		response.NewErrorResponse(http.StatusNotFound, "could not find webhook").Write(w)
+		return
+	}
+	db, closer := dbSession.DB()
+	defer closer()
+	if err := db.C(webhooksCollection).Remove(bson.M{"_id": bson.ObjectIdHex(params["id"])}); err != nil {
+		if err == mgo.ErrNotFound {
+			response.NewErrorResponse(http.StatusNotFound, "could not find webhook").Write(w)
+			return
+		}
+		log.WithError(err).Errorf("could not delete webhook %s", params["id"])
+		response.NewErrorResponse(http.StatusInternalServerError, "could not delete webhook").Write(w)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// listWebhookDeliveries returns the latest deliveries of a webhook
+func listWebhookDeliveries(w http.ResponseWriter, req *http.Request, params Params) {
+	if !bson.IsObjectIdHex(params["id"]) {
+		response.NewErrorResponse(http.StatusNotFound, "could not find webhook").Write(w)
+		return
+	}
+	db, closer := dbSession.DB()
+	defer closer()
+	deliveries := []models.WebhookDelivery{}
+	if err := db.C(webhookDeliveriesCollection).Pipe([]bson.M{
+		{"$match": bson.M{"webhook": bson.ObjectIdHex(params["id"])}},
+		{"$sort": bson.M{"time": -1}},
+		{"$limit": maxWebhookDeliveries},
+	}).All(&deliveries); err != nil {
+		log.WithError(err).Errorf("could not fetch deliveries of webhook %s", params["id"])
+		response.NewErrorResponse(http.StatusInternalServerError, "could not fetch deliveries").Write(w)
+		return
+	}
+	response.NewDataResponse(deliveries).Write(w)
+}
@@ -0,0 +1,130 @@
+/*
+Copyright (c) 2018 The Helm Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/globalsign/mgo/bson"
+	"github.com/helm/monocular/cmd/chartsvc/models"
+	"github.com/kubeapps/common/datastore/mockstore"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func Test_createWebhook(t *testing.T) {
+	tests := []struct {
+		name       string
+		body       string
+		wantCode   int
+		wantSecret string
+	}{
+		{"invalid body", `{`, http.StatusBadRequest, ""},
+		{"invalid url", `{"url": "ftp://example.com"}`, http.StatusBadRequest, ""},
+		{"invalid pattern", `{"url": "https://example.com", "chart": "["}`, http.StatusBadRequest, ""},
+		{"invalid event type", `{"url": "https://example.com", "events": ["chart_updated"]}`, http.StatusBadRequest, ""},
+		{"with secret", `{"url": "https://example.com", "secret": "s3cr3t", "repo": "stable", "events": ["version_added"]}`, http.StatusCreated, "s3cr3t"},
+		{"generated secret", `{"url": "https://example.com"}`, http.StatusCreated, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var m mock.Mock
+			dbSession = mockstore.NewMockSession(&m)
+			if tt.wantCode == http.StatusCreated {
+				m.On("Insert", mock.Anything)
+			}
+
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest("POST", "/v1/webhooks", strings.NewReader(tt.body))
+			createWebhook(w, req)
+
+			m.AssertExpectations(t)
+			assert.Equal(t, tt.wantCode, w.Code)
+			if tt.wantCode == http.StatusCreated {
+				var b struct {
+					Data models.Webhook `json:"data"`
+				}
+				json.NewDecoder(w.Body).Decode(&b)
+				stored := m.Calls[0].Arguments.Get(0).(models.Webhook)
+				assert.Equal(t, stored, b.Data)
+				assert.True(t, b.Data.ID.Valid())
+				if tt.wantSecret != "" {
+					assert.Equal(t, tt.wantSecret, b.Data.Secret)
+				} else {
+					assert.Len(t, b.Data.Secret, 64)
+				}
+			}
+		})
+	}
+}
+
+func Test_listWebhooks(t *testing.T) {
+	var m mock.Mock
+	dbSession = mockstore.NewMockSession(&m)
+	webhooks := []models.Webhook{{ID: bson.NewObjectId(), URL: "https://example.com", Secret: "s3cr3t"}}
+	m.On("All", &[]models.Webhook{}).Run(func(args mock.Arguments) {
+		*args.Get(0).(*[]models.Webhook) = webhooks
+	})
+
+	w := httptest.NewRecorder()
+	listWebhooks(w, httptest.NewRequest("GET", "/v1/webhooks", nil))
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NotContains(t, w.Body.String(), "s3cr3t", "secrets are not listed")
+}
+
+func Test_listWebhookDeliveries(t *testing.T) {
+	tests := []struct {
+		name     string
+		id       string
+		wantCode int
+	}{
+		{"invalid id", "foo", http.StatusNotFound},
+		{"webhook id", bson.NewObjectId().Hex(), http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var m mock.Mock
+			dbSession = mockstore.NewMockSession(&m)
+			deliveries := []models.WebhookDelivery{{ID: bson.NewObjectId(), Attempts: 3, StatusCode: 500, Error: "unexpected status code 500"}}
+			if tt.wantCode == http.StatusOK {
+				m.On("All", &[]models.WebhookDelivery{}).Run(func(args mock.Arguments) {
+					*args.Get(0).(*[]models.WebhookDelivery) = deliveries
+				})
+			}
+
+			w := httptest.NewRecorder()
+			listWebhookDeliveries(w, httptest.NewRequest("GET", "/v1/webhooks/"+tt.id+"/deliveries", nil), Params{"id": tt.id})
+
+			m.AssertExpectations(t)
+			assert.Equal(t, tt.wantCode, w.Code)
+			if tt.wantCode == http.StatusOK {
+				var b struct {
+					Data []models.WebhookDelivery `json:"data"`
+				}
+				json.NewDecoder(w.Body).Decode(&b)
+				assert.Equal(t, deliveries, b.Data)
+			}
+		})
+	}
+}
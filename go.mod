@@ -4,7 +4,10 @@ go 1.12
 
 require (
 	github.com/BurntSushi/toml v0.3.0 // indirect
-	github.com/Masterminds/semver v1.3.1 // indirect
+	github.com/Masterminds/semver v1.3.1
+	github.com/Masterminds/sprig v2.17.1+incompatible // indirect
+	github.com/andybalholm/brotli v1.0.0
+	github.com/aokoli/goutils v1.0.1 // indirect
 	github.com/arschles/assert v1.0.0
 	github.com/beorn7/perks v0.0.0-20180321164747-3a771d992973 // indirect
 	github.com/cyphar/filepath-securejoin v0.2.2 // indirect
@@ -15,28 +18,31 @@ require (
 	github.com/gobwas/glob v0.2.3 // indirect
 	github.com/gogo/protobuf v1.2.1 // indirect
 	github.com/golang/protobuf v1.2.0 // indirect
+	github.com/google/uuid v1.1.0 // indirect
 	github.com/gorilla/context v1.1.1 // indirect
 	github.com/gorilla/mux v1.6.2
 	github.com/heptiolabs/healthcheck v0.0.0-20180807145615-6ff867650f40
+	github.com/huandu/xstrings v1.2.0 // indirect
+	github.com/imdario/mergo v0.3.6 // indirect
 	github.com/inconshreveable/mousetrap v1.0.0 // indirect
 	github.com/jinzhu/copier v0.0.0-20180308034124-7e38e58719c3
 	github.com/kubeapps/common v0.0.0-20190307100129-fcd6537ca4e3
 	github.com/matttproud/golang_protobuf_extensions v1.0.1 // indirect
+	github.com/minio/minio-go/v6 v6.0.57
 	github.com/pkg/errors v0.8.1 // indirect
 	github.com/prometheus/client_golang v0.0.0-20181001174001-0a8115f42e03 // indirect
 	github.com/prometheus/client_model v0.0.0-20180712105110-5c3871d89910 // indirect
 	github.com/prometheus/common v0.0.0-20180801064454-c7de2306084e // indirect
 	github.com/prometheus/procfs v0.0.0-20180920065004-418d78d0b9a7 // indirect
-	github.com/sirupsen/logrus v1.1.0
+	github.com/sirupsen/logrus v1.5.0
 	github.com/spf13/cobra v0.0.3
 	github.com/spf13/pflag v1.0.1 // indirect
 	github.com/stretchr/objx v0.1.1 // indirect
-	github.com/stretchr/testify v1.2.2
+	github.com/stretchr/testify v1.3.0
 	github.com/unrolled/render v0.0.0-20180914162206-b9786414de4d // indirect
 	github.com/urfave/negroni v1.0.0
-	golang.org/x/image v0.0.0-20180926015637-991ec62608f3 // indirect
+	golang.org/x/image v0.0.0-20180926015637-991ec62608f3
 	golang.org/x/sync v0.0.0-20190227155943-e225da77a7e6 // indirect
-	golang.org/x/sys v0.0.0-20180928133829-e4b3c5e90611 // indirect
 	gopkg.in/DATA-DOG/go-sqlmock.v1 v1.3.0 // indirect
 	gopkg.in/yaml.v2 v2.2.1 // indirect
 	k8s.io/apimachinery v0.0.0-20180621070125-103fd098999d // indirect
@@ -0,0 +1,103 @@
+/*
+Copyright (c) 2018 The Helm Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package blobstore stores the icons and files of the charts outside of
+// MongoDB, either in a directory or in an S3 compatible bucket. Blobs are
+// content addressed: their key is the SHA-256 digest of their content, so the
+// documents referencing them only hold the key.
+package blobstore
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"regexp"
+	"time"
+)
+
+// ErrNotFound is returned when no blob is stored under a key
+var ErrNotFound = errors.New("blob not found")
+
+// Store stores blobs under their key
+type Store interface {
+	// Put stores the content under the key, replacing any existing blob
+	Put(key string, content []byte) error
+	// Get returns the content stored under the key and its size
+	Get(key string) (io.ReadCloser, int64, error)
+	// Exists returns true if a blob is stored under the key
+	Exists(key string) (bool, error)
+	// Delete removes the blob stored under the key, if any
+	Delete(key string) error
+	// Walk calls fn with the key and the modification time of each blob
+	// until it returns an error
+	Walk(fn func(key string, modified time.Time) error) error
+}
+
+var keyRegexp = regexp.MustCompile("^[0-9a-f]{64}$")
+
+// Key returns the key of the content
+func Key(content []byte) string {
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
+
+// Put stores the content under its key, unless it is already stored, and
+// returns the key
+func Put(s Store, content []byte) (string, error) {
+	key := Key(content)
+	exists, err := s.Exists(key)
+	if err != nil {
+		return "", err
+	}
+	if !exists {
+		if err := s.Put(key, content); err != nil {
+			return "", err
+		}
+	}
+	return key, nil
+}
+
+// validKey returns an error if the key is not a SHA-256 digest, so keys never
+// escape the directory or the bucket prefix of the store
+func validKey(key string) error {
+	if !keyRegexp.MatchString(key) {
+		return fmt.Errorf("invalid blob key %q", key)
+	}
+	return nil
+}
+
+// New returns the store for the URL, either a directory (file:///path) or an
+// S3 compatible bucket (s3://bucket/prefix?endpoint=https://host&region=name)
+func New(rawurl string) (Store, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, err
+	}
+	switch u.Scheme {
+	case "file":
+		if u.Path == "" {
+			return nil, errors.New("missing directory of the blob store")
+		}
+		return NewFileStore(u.Path), nil
+	case "s3":
+		return newS3StoreFromURL(u)
+	default:
+		return nil, fmt.Errorf("unsupported blob store %q, expected a file:// or s3:// URL", rawurl)
+	}
+}
@@ -0,0 +1,114 @@
+/*
+Copyright (c) 2018 The Helm Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package blobstore
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/arschles/assert"
+)
+
+// testStore checks the operations of a store
+func testStore(t *testing.T, s Store) {
+	content := []byte("# README")
+	key := Key(content)
+
+	exists, err := s.Exists(key)
+	assert.NoErr(t, err)
+	assert.False(t, exists, "blob exists before it is stored")
+	_, _, err = s.Get(key)
+	assert.Equal(t, err, ErrNotFound, "error")
+
+	stored, err := Put(s, content)
+	assert.NoErr(t, err)
+	assert.Equal(t, stored, key, "key")
+	assert.Equal(t, walk(t, s), []string{key}, "walked keys")
+	exists, err = s.Exists(key)
+	assert.NoErr(t, err)
+	assert.True(t, exists, "blob exists once stored")
+
+	r, size, err := s.Get(key)
+	assert.NoErr(t, err)
+	b, err := ioutil.ReadAll(r)
+	r.Close()
+	assert.NoErr(t, err)
+	assert.Equal(t, string(b), string(content), "content")
+	assert.Equal(t, size, int64(len(content)), "size")
+
+	assert.NoErr(t, s.Delete(key))
+	exists, err = s.Exists(key)
+	assert.NoErr(t, err)
+	assert.False(t, exists, "blob exists once deleted")
+	assert.Equal(t, len(walk(t, s)), 0, "walked keys once deleted")
+	assert.NoErr(t, s.Delete(key))
+
+	assert.ExistsErr(t, s.Put("../../etc/passwd", content), "invalid key")
+	_, _, err = s.Get("../" + key)
+	assert.ExistsErr(t, err, "invalid key")
+}
+
+// walk returns the keys of the blobs of the store, which must have been
+// modified recently
+func walk(t *testing.T, s Store) []string {
+	var keys []string
+	err := s.Walk(func(key string, modified time.Time) error {
+		if d := time.Since(modified); d > time.Minute || d < -time.Minute {
+			t.Errorf("blob %s modified at %s", key, modified)
+		}
+		keys = append(keys, key)
+		return nil
+	})
+	assert.NoErr(t, err)
+	return keys
+}
+
+func Test_FileStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "blobs")
+	assert.NoErr(t, err)
+	defer os.RemoveAll(dir)
+	testStore(t, NewFileStore(dir))
+
+	t.Run("missing directory", func(t *testing.T) {
+		assert.Equal(t, len(walk(t, NewFileStore(dir+"/missing"))), 0, "walked keys")
+	})
+}
+
+func Test_New(t *testing.T) {
+	tests := []struct {
+		url   string
+		valid bool
+	}{
+		{"file:///var/lib/monocular", true},
+		{"s3://charts", true},
+		{"s3://charts/monocular/blobs?endpoint=http://minio:9000&region=eu-west-1", true},
+		{"s3://", false},
+		{"s3://charts/a%20b", false},
+		{"s3://charts?endpoint=ftp://minio", false},
+		{"file://", false},
+		{"/var/lib/monocular", false},
+		{"gs://charts", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.url, func(t *testing.T) {
+			_, err := New(tt.url)
+			assert.Equal(t, err == nil, tt.valid, "valid")
+		})
+	}
+}
@@ -0,0 +1,131 @@
+/*
+Copyright (c) 2018 The Helm Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package blobstore
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// FileStore stores blobs in a directory, e.g. a volume shared by chart-repo
+// and chartsvc. Blobs are spread in subdirectories named after the first two
+// characters of their key.
+type FileStore struct {
+	dir string
+}
+
+// NewFileStore returns a store of the blobs in the directory
+func NewFileStore(dir string) *FileStore {
+	return &FileStore{dir: dir}
+}
+
+func (s *FileStore) path(key string) string {
+	return filepath.Join(s.dir, key[:2], key)
+}
+
+// Put writes the blob to a temporary file renamed once complete, so readers
+// never get a partial blob
+func (s *FileStore) Put(key string, content []byte) error {
+	if err := validKey(key); err != nil {
+		return err
+	}
+	p := s.path(key)
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile(filepath.Dir(p), ".tmp-"+key)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(content); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(f.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), p)
+}
+
+// Get opens the blob
+func (s *FileStore) Get(key string) (io.ReadCloser, int64, error) {
+	if err := validKey(key); err != nil {
+		return nil, 0, err
+	}
+	f, err := os.Open(s.path(key))
+	if os.IsNotExist(err) {
+		return nil, 0, ErrNotFound
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, 0, err
+	}
+	return f, info.Size(), nil
+}
+
+// Exists returns true if the blob file exists
+func (s *FileStore) Exists(key string) (bool, error) {
+	if err := validKey(key); err != nil {
+		return false, err
+	}
+	_, err := os.Stat(s.path(key))
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// Delete removes the blob file
+func (s *FileStore) Delete(key string) error {
+	if err := validKey(key); err != nil {
+		return err
+	}
+	err := os.Remove(s.path(key))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// Walk lists the blob files, ignoring the temporary files of Put
+func (s *FileStore) Walk(fn func(key string, modified time.Time) error) error {
+	err := filepath.Walk(s.dir, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() || !keyRegexp.MatchString(info.Name()) {
+			return nil
+		}
+		return fn(info.Name(), info.ModTime())
+	})
+	if os.IsNotExist(err) {
+		// Nothing was stored yet
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,203 @@
+/*
+Copyright (c) 2018 The Helm Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package blobstore
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	minio "github.com/minio/minio-go/v6"
+	"github.com/minio/minio-go/v6/pkg/credentials"
+)
+
+var s3PathRegexp = regexp.MustCompile("^[A-Za-z0-9._/-]*$")
+
+// S3Config configures a store in an S3 compatible bucket
+type S3Config struct {
+	// Endpoint is the URL of the S3 API, e.g. the URL of a MinIO server,
+	// defaults to the AWS endpoint of the region
+	Endpoint string
+	// Region defaults to us-east-1
+	Region string
+	Bucket string
+	// Prefix is prepended to the keys of the blobs
+	Prefix string
+	// Requests are anonymous if no access key is given
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+}
+
+// S3Store stores blobs in an S3 compatible bucket with the MinIO client.
+// Objects are addressed with path-style URLs, which every S3 compatible server
+// supports.
+type S3Store struct {
+	client *minio.Client
+	bucket string
+	prefix string
+}
+
+// NewS3Store returns a store in the bucket
+func NewS3Store(config S3Config) (*S3Store, error) {
+	if config.Region == "" {
+		config.Region = "us-east-1"
+	}
+	if config.Endpoint == "" {
+		config.Endpoint = "https://s3." + config.Region + ".amazonaws.com"
+	}
+	endpoint, err := url.Parse(config.Endpoint)
+	if err != nil {
+		return nil, err
+	}
+	if (endpoint.Scheme != "http" && endpoint.Scheme != "https") || strings.Trim(endpoint.Path, "/") != "" {
+		return nil, fmt.Errorf("invalid S3 endpoint %q", config.Endpoint)
+	}
+	if config.Bucket == "" || strings.Contains(config.Bucket, "/") || !s3PathRegexp.MatchString(config.Bucket) {
+		return nil, fmt.Errorf("invalid S3 bucket %q", config.Bucket)
+	}
+	if !s3PathRegexp.MatchString(config.Prefix) {
+		return nil, fmt.Errorf("invalid S3 prefix %q", config.Prefix)
+	}
+	prefix := strings.Trim(config.Prefix, "/")
+	if prefix != "" {
+		prefix += "/"
+	}
+	client, err := minio.NewWithOptions(endpoint.Host, &minio.Options{
+		Creds:        credentials.NewStaticV4(config.AccessKeyID, config.SecretAccessKey, config.SessionToken),
+		Secure:       endpoint.Scheme == "https",
+		Region:       config.Region,
+		BucketLookup: minio.BucketLookupPath,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &S3Store{client: client, bucket: config.Bucket, prefix: prefix}, nil
+}
+
+// newS3StoreFromURL returns the store for a s3://bucket/prefix URL, the
+// endpoint and region are given as query parameters and the credentials are
+// read from the usual AWS environment variables
+func newS3StoreFromURL(u *url.URL) (*S3Store, error) {
+	q := u.Query()
+	return NewS3Store(S3Config{
+		Endpoint:        q.Get("endpoint"),
+		Region:          q.Get("region"),
+		Bucket:          u.Host,
+		Prefix:          u.Path,
+		AccessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+		SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		SessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+	})
+}
+
+// SetTransport sets the transport of the requests to the S3 API
+func (s *S3Store) SetTransport(transport http.RoundTripper) {
+	s.client.SetCustomTransport(transport)
+}
+
+func (s *S3Store) object(key string) (string, error) {
+	if err := validKey(key); err != nil {
+		return "", err
+	}
+	return s.prefix + key, nil
+}
+
+// Put uploads the blob
+func (s *S3Store) Put(key string, content []byte) error {
+	object, err := s.object(key)
+	if err != nil {
+		return err
+	}
+	_, err = s.client.PutObject(s.bucket, object, bytes.NewReader(content), int64(len(content)), minio.PutObjectOptions{})
+	return err
+}
+
+// Get downloads the blob, the caller closes the returned body
+func (s *S3Store) Get(key string) (io.ReadCloser, int64, error) {
+	object, err := s.object(key)
+	if err != nil {
+		return nil, 0, err
+	}
+	obj, err := s.client.GetObject(s.bucket, object, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, 0, err
+	}
+	// The object is only requested when it is first used
+	info, err := obj.Stat()
+	if err != nil {
+		obj.Close()
+		if isNoSuchKey(err) {
+			return nil, 0, ErrNotFound
+		}
+		return nil, 0, err
+	}
+	return obj, info.Size, nil
+}
+
+// Exists sends a HEAD request for the blob
+func (s *S3Store) Exists(key string) (bool, error) {
+	object, err := s.object(key)
+	if err != nil {
+		return false, err
+	}
+	if _, err := s.client.StatObject(s.bucket, object, minio.StatObjectOptions{}); err != nil {
+		if isNoSuchKey(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// Delete removes the blob
+func (s *S3Store) Delete(key string) error {
+	object, err := s.object(key)
+	if err != nil {
+		return err
+	}
+	return s.client.RemoveObject(s.bucket, object)
+}
+
+// Walk lists the objects under the prefix of the store
+func (s *S3Store) Walk(fn func(key string, modified time.Time) error) error {
+	done := make(chan struct{})
+	defer close(done)
+	for obj := range s.client.ListObjectsV2(s.bucket, s.prefix, true, done) {
+		if obj.Err != nil {
+			return obj.Err
+		}
+		key := strings.TrimPrefix(obj.Key, s.prefix)
+		if !keyRegexp.MatchString(key) {
+			continue
+		}
+		if err := fn(key, obj.LastModified); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func isNoSuchKey(err error) bool {
+	return minio.ToErrorResponse(err).Code == "NoSuchKey"
+}
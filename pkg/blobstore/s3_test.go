@@ -0,0 +1,137 @@
+/*
+Copyright (c) 2018 The Helm Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package blobstore
+
+import (
+	"encoding/xml"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/arschles/assert"
+)
+
+// s3Stub is a minimal S3 compatible server keeping the objects in memory
+type s3Stub struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+}
+
+func (s *s3Stub) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if !strings.HasPrefix(req.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=minio/") {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if req.Method == "GET" && req.URL.Query().Get("list-type") == "2" {
+		s.list(w, req)
+		return
+	}
+	content, ok := s.objects[req.URL.Path]
+	switch req.Method {
+	case "PUT":
+		b, _ := ioutil.ReadAll(req.Body)
+		if sum := req.Header.Get("X-Amz-Content-Sha256"); sum != "UNSIGNED-PAYLOAD" && sum != Key(b) {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		s.objects[req.URL.Path] = b
+		w.Header().Set("ETag", `"`+Key(b)+`"`)
+	case "GET", "HEAD":
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("ETag", `"`+Key(content)+`"`)
+		w.Header().Set("Last-Modified", time.Now().UTC().Format(http.TimeFormat))
+		w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+		w.Write(content)
+	case "DELETE":
+		delete(s.objects, req.URL.Path)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// list writes the objects of the bucket with the requested prefix
+func (s *s3Stub) list(w http.ResponseWriter, req *http.Request) {
+	type object struct {
+		Key          string
+		LastModified string
+		ETag         string
+		Size         int
+	}
+	result := struct {
+		XMLName     xml.Name `xml:"ListBucketResult"`
+		Name        string
+		Prefix      string
+		KeyCount    int
+		MaxKeys     int
+		IsTruncated bool
+		Contents    []object
+	}{Name: strings.Trim(req.URL.Path, "/"), Prefix: req.URL.Query().Get("prefix"), MaxKeys: 1000}
+	for p, content := range s.objects {
+		key := strings.TrimPrefix(p, "/"+result.Name+"/")
+		if strings.HasPrefix(key, result.Prefix) {
+			result.Contents = append(result.Contents, object{key, time.Now().UTC().Format("2006-01-02T15:04:05.000Z"), `"` + Key(content) + `"`, len(content)})
+		}
+	}
+	result.KeyCount = len(result.Contents)
+	w.Header().Set("Content-Type", "application/xml")
+	xml.NewEncoder(w).Encode(result)
+}
+
+func Test_S3Store(t *testing.T) {
+	stub := &s3Stub{objects: map[string][]byte{}}
+	server := httptest.NewTLSServer(stub)
+	defer server.Close()
+
+	s, err := NewS3Store(S3Config{Endpoint: server.URL, Bucket: "charts", Prefix: "/blobs/", AccessKeyID: "minio", SecretAccessKey: "minio123"})
+	assert.NoErr(t, err)
+	s.SetTransport(server.Client().Transport)
+	testStore(t, s)
+
+	key, err := Put(s, []byte("icon"))
+	assert.NoErr(t, err)
+	_, ok := stub.objects["/charts/blobs/"+key]
+	assert.True(t, ok, "object stored under the prefix")
+
+	t.Run("denied", func(t *testing.T) {
+		s, err := NewS3Store(S3Config{Endpoint: server.URL, Bucket: "charts"})
+		assert.NoErr(t, err)
+		s.SetTransport(server.Client().Transport)
+		_, err = s.Exists(key)
+		assert.ExistsErr(t, err, "anonymous request")
+	})
+}
+
+func Test_NewS3Store(t *testing.T) {
+	for _, config := range []S3Config{
+		{Endpoint: "ftp://minio:9000", Bucket: "charts"},
+		{Endpoint: "http://minio:9000/path", Bucket: "charts"},
+		{Endpoint: "http://minio:9000", Bucket: ""},
+		{Endpoint: "http://minio:9000", Bucket: "charts", Prefix: "blobs?v=1"},
+	} {
+		_, err := NewS3Store(config)
+		assert.ExistsErr(t, err, "invalid config "+config.Endpoint+"/"+config.Bucket+"/"+config.Prefix)
+	}
+}
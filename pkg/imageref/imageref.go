@@ -0,0 +1,98 @@
+/*
+Copyright (c) 2018 The Helm Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package imageref parses container image references the way Docker does, so
+// that nginx:1.19 and docker.io/library/nginx:1.19 refer to the same image.
+package imageref
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+const (
+	defaultDomain    = "docker.io"
+	officialRepoPath = "library/"
+	defaultTag       = "latest"
+)
+
+var (
+	// pathRegexp matches the repository path of an image, with its domain
+	pathRegexp   = regexp.MustCompile(`^[a-zA-Z0-9]+([._-][a-zA-Z0-9]+)*(:[0-9]+)?(/[a-z0-9]+([._-]+[a-z0-9]+)*)+$`)
+	tagRegexp    = regexp.MustCompile(`^[a-zA-Z0-9_][a-zA-Z0-9_.-]{0,127}$`)
+	digestRegexp = regexp.MustCompile(`^[a-z0-9]+([+._-][a-z0-9]+)*:[a-zA-Z0-9=_-]{32,}$`)
+)
+
+// Reference is a parsed image reference
+type Reference struct {
+	// Name is the repository of the image with its domain, such as
+	// docker.io/library/nginx
+	Name string
+	// Tag is the tag of the image, latest if neither a tag nor a digest is
+	// given
+	Tag string
+	// Digest is the digest of the image, if given
+	Digest string
+}
+
+// String returns the normalized reference
+func (r Reference) String() string {
+	s := r.Name
+	if r.Tag != "" {
+		s += ":" + r.Tag
+	}
+	if r.Digest != "" {
+		s += "@" + r.Digest
+	}
+	return s
+}
+
+// Parse parses and normalizes the image reference
+func Parse(ref string) (Reference, error) {
+	var r Reference
+	name := ref
+	if i := strings.Index(name, "@"); i >= 0 {
+		name, r.Digest = name[:i], name[i+1:]
+		if !digestRegexp.MatchString(r.Digest) {
+			return Reference{}, fmt.Errorf("invalid digest in image reference %q", ref)
+		}
+	}
+	if i := strings.LastIndex(name, ":"); i > strings.LastIndex(name, "/") {
+		name, r.Tag = name[:i], name[i+1:]
+		if !tagRegexp.MatchString(r.Tag) {
+			return Reference{}, fmt.Errorf("invalid tag in image reference %q", ref)
+		}
+	}
+
+	// The first component is a domain if it looks like one, otherwise the image
+	// is on Docker Hub
+	domain := defaultDomain
+	if i := strings.Index(name, "/"); i >= 0 && (strings.ContainsAny(name[:i], ".:") || name[:i] == "localhost") {
+		domain, name = name[:i], name[i+1:]
+	}
+	if domain == defaultDomain && !strings.Contains(name, "/") {
+		name = officialRepoPath + name
+	}
+	r.Name = domain + "/" + name
+	if !pathRegexp.MatchString(r.Name) {
+		return Reference{}, fmt.Errorf("invalid image reference %q", ref)
+	}
+	if r.Tag == "" && r.Digest == "" {
+		r.Tag = defaultTag
+	}
+	return r, nil
+}
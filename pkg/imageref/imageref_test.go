@@ -0,0 +1,57 @@
+/*
+Copyright (c) 2018 The Helm Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package imageref
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Parse(t *testing.T) {
+	const digest = "sha256:e4ab1b2fb1d29b1e0d1eb2bbcdc5c5a06c3ecbe2b4bfb6d44ac2a5e7d4b8e9d0"
+	tests := []struct {
+		ref  string
+		want Reference
+	}{
+		{"nginx", Reference{Name: "docker.io/library/nginx", Tag: "latest"}},
+		{"nginx:1.19", Reference{Name: "docker.io/library/nginx", Tag: "1.19"}},
+		{"docker.io/library/nginx:1.19", Reference{Name: "docker.io/library/nginx", Tag: "1.19"}},
+		{"bitnami/wordpress:5.5.1-debian-10-r0", Reference{Name: "docker.io/bitnami/wordpress", Tag: "5.5.1-debian-10-r0"}},
+		{"quay.io/jetstack/cert-manager-controller:v1.0.0", Reference{Name: "quay.io/jetstack/cert-manager-controller", Tag: "v1.0.0"}},
+		{"localhost:5000/app", Reference{Name: "localhost:5000/app", Tag: "latest"}},
+		{"localhost/app:1", Reference{Name: "localhost/app", Tag: "1"}},
+		{"nginx@" + digest, Reference{Name: "docker.io/library/nginx", Digest: digest}},
+		{"gcr.io/google-containers/pause:3.1@" + digest, Reference{Name: "gcr.io/google-containers/pause", Tag: "3.1", Digest: digest}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.ref, func(t *testing.T) {
+			r, err := Parse(tt.ref)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, r, "reference should match")
+		})
+	}
+
+	assert.Equal(t, "docker.io/library/nginx:1.19@"+digest, Reference{Name: "docker.io/library/nginx", Tag: "1.19", Digest: digest}.String(), "normalized reference should match")
+
+	for _, ref := range []string{"", "<no value>", "nginx:", "Nginx", "nginx:1.19:2", "nginx@sha256:abc", ":1.19", "registry.example.com/"} {
+		t.Run("invalid "+ref, func(t *testing.T) {
+			_, err := Parse(ref)
+			assert.Error(t, err)
+		})
+	}
+}
@@ -0,0 +1,396 @@
+/*
+Copyright (c) 2018 The Helm Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package webp encodes lossless WebP (VP8L) images. golang.org/x/image/webp
+// only decodes them.
+package webp
+
+import (
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	"io"
+	"sort"
+)
+
+// The encoder follows
+// https://developers.google.com/speed/webp/docs/webp_lossless_bitstream_specification
+//
+// It only uses the subtract green and predictor transforms and one set of
+// prefix codes for the whole image, which is enough for icons.
+
+const (
+	vp8lMaxSize = 1 << 14
+	// vp8lPredictorBits is the log2 of the size of the blocks of the predictor
+	// transform, the largest allowed so icons are a single block
+	vp8lPredictorBits = 9
+	// vp8lPredictorLeft predicts each pixel from the one on its left
+	vp8lPredictorLeft = 1
+
+	vp8lTransformPredictor     = 0
+	vp8lTransformSubtractGreen = 2
+
+	maxCodeLength           = 15
+	maxCodeLengthCodeLength = 7
+)
+
+// alphabet sizes of the green, red, blue, alpha and distance prefix codes, no
+// backward references nor color cache are used
+var vp8lAlphabetSizes = [5]int{256 + 24, 256, 256, 256, 40}
+
+var codeLengthCodeOrder = [19]int{17, 18, 0, 1, 2, 3, 4, 5, 16, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15}
+
+// Encode writes the image as a lossless WebP. Images are limited to 16384
+// pixels in each dimension.
+func Encode(w io.Writer, m image.Image) error {
+	b := m.Bounds()
+	width, height := b.Dx(), b.Dy()
+	if width < 1 || height < 1 || width > vp8lMaxSize || height > vp8lMaxSize {
+		return errors.New("invalid image size for WebP")
+	}
+
+	argb := make([]uint32, width*height)
+	hasAlpha := false
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			c := color.NRGBAModel.Convert(m.At(b.Min.X+x, b.Min.Y+y)).(color.NRGBA)
+			hasAlpha = hasAlpha || c.A != 0xff
+			argb[y*width+x] = uint32(c.A)<<24 | uint32(c.R)<<16 | uint32(c.G)<<8 | uint32(c.B)
+		}
+	}
+
+	bw := &bitWriter{}
+	bw.write(0x2f, 8)
+	bw.write(uint32(width-1), 14)
+	bw.write(uint32(height-1), 14)
+	if hasAlpha {
+		bw.write(1, 1)
+	} else {
+		bw.write(0, 1)
+	}
+	bw.write(0, 3)
+
+	// The decoder undoes the transforms in the reverse order
+	bw.write(1, 1)
+	bw.write(vp8lTransformSubtractGreen, 2)
+	subtractGreen(argb)
+
+	bw.write(1, 1)
+	bw.write(vp8lTransformPredictor, 2)
+	bw.write(vp8lPredictorBits-2, 3)
+	tiles := make([]uint32, tileCount(width)*tileCount(height))
+	for i := range tiles {
+		tiles[i] = 0xff000000 | vp8lPredictorLeft<<8
+	}
+	writeEntropyImage(bw, tiles, false)
+	argb = predictLeft(argb, width, height)
+
+	bw.write(0, 1)
+	writeEntropyImage(bw, argb, true)
+	data := bw.bytes()
+
+	// RIFF container with a single VP8L chunk
+	chunkSize := len(data)
+	padding := chunkSize % 2
+	header := make([]byte, 20)
+	copy(header[0:], "RIFF")
+	binary.LittleEndian.PutUint32(header[4:], uint32(4+8+chunkSize+padding))
+	copy(header[8:], "WEBPVP8L")
+	binary.LittleEndian.PutUint32(header[16:], uint32(chunkSize))
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+	if padding != 0 {
+		data = append(data, 0)
+	}
+	_, err := w.Write(data)
+	return err
+}
+
+func tileCount(size int) int {
+	return (size + 1<<vp8lPredictorBits - 1) >> vp8lPredictorBits
+}
+
+// subtractGreen subtracts the green component from the red and blue ones
+func subtractGreen(argb []uint32) {
+	for i, p := range argb {
+		g := (p >> 8) & 0xff
+		r := ((p >> 16) - g) & 0xff
+		b := (p - g) & 0xff
+		argb[i] = p&0xff00ff00 | r<<16 | b
+	}
+}
+
+// predictLeft returns the residuals of the pixels predicted from the pixel on
+// their left, or above them for the first column
+func predictLeft(argb []uint32, width, height int) []uint32 {
+	residuals := make([]uint32, len(argb))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			i := y*width + x
+			var prediction uint32
+			switch {
+			case x == 0 && y == 0:
+				prediction = 0xff000000
+			case x == 0:
+				prediction = argb[i-width]
+			default:
+				prediction = argb[i-1]
+			}
+			residuals[i] = subPixels(argb[i], prediction)
+		}
+	}
+	return residuals
+}
+
+// subPixels subtracts each component of the pixels modulo 256
+func subPixels(a, b uint32) uint32 {
+	var r uint32
+	for shift := uint(0); shift < 32; shift += 8 {
+		r |= (((a >> shift) - (b >> shift)) & 0xff) << shift
+	}
+	return r
+}
+
+// writeEntropyImage writes the pixels with a single set of prefix codes
+func writeEntropyImage(bw *bitWriter, argb []uint32, topLevel bool) {
+	// No color cache
+	bw.write(0, 1)
+	if topLevel {
+		// No meta prefix codes
+		bw.write(0, 1)
+	}
+
+	var histograms [5][]int
+	for i, size := range vp8lAlphabetSizes {
+		histograms[i] = make([]int, size)
+	}
+	for _, p := range argb {
+		histograms[0][(p>>8)&0xff]++
+		histograms[1][(p>>16)&0xff]++
+		histograms[2][p&0xff]++
+		histograms[3][p>>24]++
+	}
+	var codes [5]prefixCode
+	for i, h := range histograms {
+		codes[i] = writePrefixCode(bw, h)
+	}
+	for _, p := range argb {
+		codes[0].writeSymbol(bw, int((p>>8)&0xff))
+		codes[1].writeSymbol(bw, int((p>>16)&0xff))
+		codes[2].writeSymbol(bw, int(p&0xff))
+		codes[3].writeSymbol(bw, int(p>>24))
+	}
+}
+
+// prefixCode holds the length and the bit reversed canonical code of each
+// symbol, symbols of a code with a single symbol have a length of 0
+type prefixCode struct {
+	lengths []int
+	codes   []uint32
+}
+
+func (c prefixCode) writeSymbol(bw *bitWriter, symbol int) {
+	if n := c.lengths[symbol]; n > 0 {
+		bw.write(c.codes[symbol], uint(n))
+	}
+}
+
+// writePrefixCode writes the code for the histogram and returns it
+func writePrefixCode(bw *bitWriter, histogram []int) prefixCode {
+	var used []int
+	for s, n := range histogram {
+		if n > 0 {
+			used = append(used, s)
+		}
+	}
+	if len(used) == 0 {
+		used = []int{0}
+	}
+
+	if len(used) <= 2 && used[len(used)-1] < 256 {
+		// Simple code, the symbols are written in increasing order so that
+		// the first one gets code 0
+		lengths := make([]int, len(histogram))
+		bw.write(1, 1)
+		bw.write(uint32(len(used)-1), 1)
+		if used[0] < 2 {
+			bw.write(0, 1)
+			bw.write(uint32(used[0]), 1)
+		} else {
+			bw.write(1, 1)
+			bw.write(uint32(used[0]), 8)
+		}
+		if len(used) == 2 {
+			bw.write(uint32(used[1]), 8)
+			lengths[used[0]], lengths[used[1]] = 1, 1
+		}
+		return newPrefixCode(lengths)
+	}
+
+	lengths := huffmanLengths(histogram, maxCodeLength)
+	clHistogram := make([]int, len(codeLengthCodeOrder))
+	for _, l := range lengths {
+		clHistogram[l]++
+	}
+	clLengths := huffmanLengths(clHistogram, maxCodeLengthCodeLength)
+	// The code of the code lengths needs two symbols to use one bit per
+	// code length
+	var clUsed []int
+	for s, l := range clLengths {
+		if l > 0 {
+			clUsed = append(clUsed, s)
+		}
+	}
+	if len(clUsed) == 1 {
+		clLengths[clUsed[0]] = 1
+		if clUsed[0] == 0 {
+			clLengths[1] = 1
+		} else {
+			clLengths[0] = 1
+		}
+	}
+	clCode := newPrefixCode(clLengths)
+
+	n := 4
+	for i, s := range codeLengthCodeOrder {
+		if clLengths[s] > 0 && i+1 > n {
+			n = i + 1
+		}
+	}
+	bw.write(0, 1)
+	bw.write(uint32(n-4), 4)
+	for _, s := range codeLengthCodeOrder[:n] {
+		bw.write(uint32(clLengths[s]), 3)
+	}
+	// The code lengths of all the symbols are written
+	bw.write(0, 1)
+	for _, l := range lengths {
+		clCode.writeSymbol(bw, l)
+	}
+	return newPrefixCode(lengths)
+}
+
+// newPrefixCode returns the canonical code for the lengths
+func newPrefixCode(lengths []int) prefixCode {
+	var count [maxCodeLength + 1]uint32
+	for _, l := range lengths {
+		count[l]++
+	}
+	count[0] = 0
+	var next [maxCodeLength + 1]uint32
+	code := uint32(0)
+	for l := 1; l <= maxCodeLength; l++ {
+		code = (code + count[l-1]) << 1
+		next[l] = code
+	}
+	c := prefixCode{lengths: lengths, codes: make([]uint32, len(lengths))}
+	for s, l := range lengths {
+		if l > 0 {
+			c.codes[s] = reverseBits(next[l], uint(l))
+			next[l]++
+		}
+	}
+	return c
+}
+
+func reverseBits(v uint32, n uint) uint32 {
+	var r uint32
+	for i := uint(0); i < n; i++ {
+		r = r<<1 | (v>>i)&1
+	}
+	return r
+}
+
+// huffmanLengths returns the lengths of the Huffman code of the histogram,
+// flattening the histogram until no length is above the limit
+func huffmanLengths(histogram []int, limit int) []int {
+	h := make([]int, len(histogram))
+	copy(h, histogram)
+	for {
+		lengths := huffmanTree(h)
+		max := 0
+		for _, l := range lengths {
+			if l > max {
+				max = l
+			}
+		}
+		if max <= limit {
+			return lengths
+		}
+		for i, n := range h {
+			if n > 0 {
+				h[i] = (n + 1) / 2
+			}
+		}
+	}
+}
+
+// huffmanTree returns the depth of each symbol in the Huffman tree of the
+// histogram
+func huffmanTree(histogram []int) []int {
+	type node struct {
+		weight  int
+		symbols []int
+	}
+	var nodes []node
+	for s, n := range histogram {
+		if n > 0 {
+			nodes = append(nodes, node{weight: n, symbols: []int{s}})
+		}
+	}
+	lengths := make([]int, len(histogram))
+	if len(nodes) == 1 {
+		lengths[nodes[0].symbols[0]] = 1
+		return lengths
+	}
+	for len(nodes) > 1 {
+		sort.SliceStable(nodes, func(i, j int) bool { return nodes[i].weight < nodes[j].weight })
+		merged := node{weight: nodes[0].weight + nodes[1].weight}
+		merged.symbols = append(append(merged.symbols, nodes[0].symbols...), nodes[1].symbols...)
+		for _, s := range merged.symbols {
+			lengths[s]++
+		}
+		nodes = append([]node{merged}, nodes[2:]...)
+	}
+	return lengths
+}
+
+// bitWriter writes values least significant bit first
+type bitWriter struct {
+	buf   []byte
+	acc   uint64
+	nbits uint
+}
+
+func (b *bitWriter) write(v uint32, n uint) {
+	b.acc |= uint64(v) << b.nbits
+	b.nbits += n
+	for b.nbits >= 8 {
+		b.buf = append(b.buf, byte(b.acc))
+		b.acc >>= 8
+		b.nbits -= 8
+	}
+}
+
+func (b *bitWriter) bytes() []byte {
+	if b.nbits > 0 {
+		b.buf = append(b.buf, byte(b.acc))
+		b.acc, b.nbits = 0, 0
+	}
+	return b.buf
+}
@@ -0,0 +1,150 @@
+/*
+Copyright (c) 2018 The Helm Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webp
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"math/rand"
+	"testing"
+
+	"github.com/arschles/assert"
+	"golang.org/x/image/webp"
+)
+
+func Test_Encode(t *testing.T) {
+	gradient := image.NewNRGBA(image.Rect(0, 0, 64, 48))
+	for y := 0; y < 48; y++ {
+		for x := 0; x < 64; x++ {
+			gradient.SetNRGBA(x, y, color.NRGBA{uint8(x * 4), uint8(y * 5), uint8(x + y), 0xff})
+		}
+	}
+	noise := image.NewNRGBA(image.Rect(0, 0, 600, 3))
+	r := rand.New(rand.NewSource(1))
+	r.Read(noise.Pix)
+	uniform := image.NewNRGBA(image.Rect(0, 0, 7, 5))
+	for i := range uniform.Pix {
+		uniform.Pix[i] = 0x80
+	}
+	twoColors := image.NewNRGBA(image.Rect(0, 0, 9, 9))
+	for y := 0; y < 9; y++ {
+		for x := 0; x < 9; x++ {
+			c := color.NRGBA{0xff, 0xff, 0xff, 0xff}
+			if (x+y)%2 == 0 {
+				c = color.NRGBA{0x20, 0x40, 0x60, 0x00}
+			}
+			twoColors.SetNRGBA(x, y, c)
+		}
+	}
+	pixel := image.NewNRGBA(image.Rect(3, 3, 4, 4))
+	pixel.SetNRGBA(3, 3, color.NRGBA{1, 2, 3, 4})
+
+	tests := []struct {
+		name string
+		img  *image.NRGBA
+	}{
+		{"opaque gradient", gradient},
+		{"noise with alpha", noise},
+		{"uniform", uniform},
+		{"two colors", twoColors},
+		{"single pixel", pixel},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := roundTrip(tt.img); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+
+	t.Run("other color models", func(t *testing.T) {
+		gray := image.NewGray(image.Rect(0, 0, 5, 4))
+		for i := range gray.Pix {
+			gray.Pix[i] = uint8(i * 12)
+		}
+		var buf bytes.Buffer
+		assert.NoErr(t, Encode(&buf, gray))
+		decoded, err := webp.Decode(&buf)
+		assert.NoErr(t, err)
+		for i := range gray.Pix {
+			x, y := i%5, i/5
+			want := color.NRGBAModel.Convert(gray.At(x, y))
+			got := color.NRGBAModel.Convert(decoded.At(x, y))
+			assert.Equal(t, got, want, "pixel")
+		}
+	})
+
+	for _, r := range []image.Rectangle{image.Rect(0, 0, 0, 4), image.Rect(0, 0, 1<<14+1, 1)} {
+		t.Run("invalid size "+r.String(), func(t *testing.T) {
+			var buf bytes.Buffer
+			assert.ExistsErr(t, Encode(&buf, image.NewNRGBA(r)), "invalid size")
+			assert.Equal(t, buf.Len(), 0, "written bytes")
+		})
+	}
+}
+
+// roundTrip encodes the image and checks it is decoded with the same pixels
+func roundTrip(img *image.NRGBA) error {
+	var buf bytes.Buffer
+	if err := Encode(&buf, img); err != nil {
+		return err
+	}
+	decoded, err := webp.Decode(&buf)
+	if err != nil {
+		return err
+	}
+	b := img.Bounds()
+	if decoded.Bounds().Size() != b.Size() {
+		return fmt.Errorf("decoded size is %v, expected %v", decoded.Bounds().Size(), b.Size())
+	}
+	for y := 0; y < b.Dy(); y++ {
+		for x := 0; x < b.Dx(); x++ {
+			want := img.NRGBAAt(b.Min.X+x, b.Min.Y+y)
+			got := color.NRGBAModel.Convert(decoded.At(x, y)).(color.NRGBA)
+			if want.A == 0 {
+				// Fully transparent pixels have no color once premultiplied
+				want = color.NRGBA{}
+				got.R, got.G, got.B = 0, 0, 0
+			}
+			if got != want {
+				return fmt.Errorf("pixel (%d, %d) is %v, expected %v", x, y, got, want)
+			}
+		}
+	}
+	return nil
+}
+
+func Test_huffmanLengths(t *testing.T) {
+	// A Fibonacci histogram needs more than 15 bits without a limit
+	histogram := make([]int, 30)
+	a, b := 1, 1
+	for i := range histogram {
+		histogram[i] = a
+		a, b = b, a+b
+	}
+	lengths := huffmanLengths(histogram, maxCodeLength)
+	kraft := 0.0
+	for _, l := range lengths {
+		if l > maxCodeLength {
+			t.Fatalf("length %d above the limit", l)
+		}
+		kraft += 1 / float64(uint(1)<<uint(l))
+	}
+	assert.Equal(t, kraft, 1.0, "the code is complete")
+}
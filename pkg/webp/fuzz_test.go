@@ -0,0 +1,45 @@
+//go:build go1.18
+// +build go1.18
+
+/*
+Copyright (c) 2018 The Helm Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webp
+
+import (
+	"image"
+	"testing"
+)
+
+// FuzzEncode checks that any image is decoded with the pixels it was encoded
+// with, run it with go test -fuzz=FuzzEncode ./pkg/webp
+func FuzzEncode(f *testing.F) {
+	f.Add(uint8(1), []byte{1, 2, 3, 4})
+	f.Add(uint8(3), []byte{0xff, 0, 0, 0xff, 0, 0xff, 0, 0x80, 0, 0, 0xff, 0})
+	f.Add(uint8(2), make([]byte, 64))
+	f.Fuzz(func(t *testing.T, width uint8, pix []byte) {
+		w := int(width%64) + 1
+		h := len(pix) / 4 / w
+		if h == 0 {
+			return
+		}
+		img := image.NewNRGBA(image.Rect(0, 0, w, h))
+		copy(img.Pix, pix)
+		if err := roundTrip(img); err != nil {
+			t.Fatal(err)
+		}
+	})
+}